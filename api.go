@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
 
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -18,53 +20,158 @@ import (
 
 const TokenFile = "gmail-token.json"
 
-// SetupGmailService sets a token file if not already present. This needs human intervention, so it is advised
+// defaultState is the OAuth state value used when SetupOptions.State is empty.
+const defaultState = "state-token"
+
+// SetupOptions customizes the authorization flow run by SetupGmailServiceWithOptions.
+type SetupOptions struct {
+	// State is the OAuth state value sent with the authorization request.
+	// Defaults to "state-token".
+	State string
+	// ForceConsent shows the consent screen (prompt=consent) even to users who
+	// already authorized the application. Google only returns a refresh token
+	// on consent, so without it re-running the setup may produce a token that
+	// stops working once it expires.
+	ForceConsent bool
+	// RedirectURL overrides the redirect URI found in the credentials file.
+	RedirectURL string
+	// AuthCodeOptions are added to the authorization URL, e.g.
+	// oauth2.SetAuthURLParam("include_granted_scopes", "true") for incremental
+	// authorization.
+	AuthCodeOptions []oauth2.AuthCodeOption
+}
+
+// SetupResult is the outcome of SetupGmailService and EnsureToken.
+type SetupResult struct {
+	// TokenPath is the path of the token file.
+	TokenPath string
+	// Created is true when the token file was written, false when EnsureToken
+	// found a usable one already.
+	Created bool
+}
+
+// SetupGmailService sets a token file, replacing any existing one, so it is
+// also the way to authorize the application again (see ErrReauthRequired and
+// ErrInsufficientScope). This needs human intervention, so it is advised
 // to run the application at /cmd/setup directory before using this lib.
-func SetupGmailService(credentialsPath string, scope ...string) error {
-	cacheFile, err := newTokenizer()
+func SetupGmailService(credentialsPath string, scope ...string) (SetupResult, error) {
+	return SetupGmailServiceWithOptions(credentialsPath, SetupOptions{}, scope...)
+}
+
+// SetupGmailServiceWithOptions works like SetupGmailService, customizing the
+// authorization request with opts.
+func SetupGmailServiceWithOptions(credentialsPath string, opts SetupOptions, scope ...string) (SetupResult, error) {
+	cacheFile, err := tokenCacheFile()
 	if err != nil {
-		return err
+		return SetupResult{}, err
 	}
-
 	credentialsFile, err := os.ReadFile(credentialsPath)
 	if err != nil {
-		return err
+		return SetupResult{}, err
 	}
 
 	config, err := google.ConfigFromJSON(credentialsFile, scope...)
 	if err != nil {
-		return err
+		return SetupResult{}, err
 	}
 
-	saveToken(cacheFile, getTokenFromWeb(config))
+	if opts.RedirectURL != "" {
+		config.RedirectURL = opts.RedirectURL
+	}
+
+	saveToken(cacheFile, tokenFromWeb(config, opts))
 	log.Println("gmail service credentials set")
-	return nil
+	return SetupResult{TokenPath: cacheFile, Created: true}, nil
+}
+
+// usableToken reports whether the token can be refreshed or hasn't expired.
+func usableToken(token *oauth2.Token) bool {
+	return token.RefreshToken != "" || token.Valid()
+}
+
+// runSetup runs the interactive authorization flow of EnsureToken.
+var runSetup = SetupGmailService
+
+// tokenFromWeb runs the authorization flow of SetupGmailService. Tests
+// replace it to skip the browser.
+var tokenFromWeb = getTokenFromWeb
+
+// EnsureToken runs the interactive setup (see SetupGmailService) when there
+// is no usable token yet, reporting whether it did. A token is usable when it
+// can be refreshed or hasn't expired.
+func EnsureToken(credentialsPath string, scopes ...string) (SetupResult, error) {
+	cacheFile, err := tokenCacheFile()
+	if err != nil {
+		return SetupResult{}, err
+	}
+	if token, err := tokenFromFile(cacheFile); err == nil && usableToken(token) {
+		return SetupResult{TokenPath: cacheFile}, nil
+	}
+	return runSetup(credentialsPath, scopes...)
+}
+
+// EnsureGmailService returns a Service like NewGmailService, first running
+// the interactive setup when there is no usable token yet (see EnsureToken),
+// so CLI tools don't need cmd/setup to be run beforehand.
+func EnsureGmailService(credentialsPath string, scopes ...string) (*Service, error) {
+	if _, err := EnsureToken(credentialsPath, scopes...); err != nil {
+		return nil, err
+	}
+	return NewGmailService(credentialsPath, scopes...)
 }
 
 // GetGmailServiceFromFile will use a credentials file and a token file set to build a gmail.Service instance
 // if one of the files are not present, this function will return an error.
 func GetGmailServiceFromFile(credentialsPath string, scope ...string) (*gmail.Service, error) {
-	credentialsFile, err := os.ReadFile(credentialsPath)
+	client, err := clientFromFile(credentialsPath, scope...)
 	if err != nil {
 		return nil, err
 	}
+	return gmail.NewService(context.Background(), option.WithHTTPClient(client))
+}
 
-	config, err := google.ConfigFromJSON(credentialsFile, scope...)
+// clientFromFile builds an authorized http.Client from a credentials file and the
+// token file created by SetupGmailService.
+func clientFromFile(credentialsPath string, scope ...string) (*http.Client, error) {
+	ts, err := tokenSourceFromFile(credentialsPath, nil, scope...)
 	if err != nil {
 		return nil, err
 	}
+	return oauth2.NewClient(context.Background(), ts), nil
+}
 
-	cacheFile, err := newTokenizer()
+// tokenSourceFromFile returns the source of the token loaded from the store,
+// refreshed with the credentials file. A nil store means the token file
+// created by SetupGmailService.
+func tokenSourceFromFile(credentialsPath string, store TokenStore, scope ...string) (*tokenSource, error) {
+	credentialsFile, err := os.ReadFile(credentialsPath)
 	if err != nil {
 		return nil, err
 	}
-	token, err := tokenFromFile(cacheFile)
+
+	config, err := google.ConfigFromJSON(credentialsFile, scope...)
 	if err != nil {
 		return nil, err
 	}
-	return gmail.New(config.Client(context.Background(), token))
+
+	if store == nil {
+		cacheFile, err := tokenCacheFile()
+		if err != nil {
+			return nil, err
+		}
+		store = FileTokenStore(cacheFile)
+	}
+	token, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return newTokenSource(config, token, store), nil
 }
 
+// tokenCacheFile returns the path of the token file. It is a variable so tests
+// can move it away from the home directory.
+var tokenCacheFile = newTokenizer
+
 // newTokenizer returns a new token and generates credential file path and
 // returns the generated credential path/filename along with any errors.
 func newTokenizer() (string, error) {
@@ -91,10 +198,25 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return token, err
 }
 
+// authCodeURL returns the URL the user has to visit to authorize the application.
+func authCodeURL(config *oauth2.Config, opts SetupOptions) string {
+	state := opts.State
+	if state == "" {
+		state = defaultState
+	}
+
+	authOpts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if opts.ForceConsent {
+		authOpts = append(authOpts, oauth2.SetAuthURLParam("prompt", "consent"))
+	}
+	authOpts = append(authOpts, opts.AuthCodeOptions...)
+	return config.AuthCodeURL(state, authOpts...)
+}
+
 // getTokenFromWeb uses Config to request a Token. It returns the retrieved
 // Token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+func getTokenFromWeb(config *oauth2.Config, opts SetupOptions) *oauth2.Token {
+	authURL := authCodeURL(config, opts)
 	fmt.Printf("Go to the following link in your browser then type the authorization code: \n%v\n", authURL)
 
 	var code string
@@ -113,10 +235,20 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 // saveToken uses a file path to create a file and store the token in it.
 func saveToken(file string, token *oauth2.Token) {
 	fmt.Printf("saving credential file to: %s\n", file)
+	if err := writeToken(file, token); err != nil {
+		log.Fatalf("unable to cache oauth token: %v", err)
+	}
+}
+
+// writeToken writes the token to the file, readable by the user only.
+func writeToken(file string, token *oauth2.Token) error {
 	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("unable to cache oauth token: %v", err)
+		return err
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	if err := json.NewEncoder(f).Encode(token); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
@@ -0,0 +1,123 @@
+package inboxer
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/oauth2"
+)
+
+func TestAuthCodeURL(t *testing.T) {
+	c := qt.New(t)
+
+	config := &oauth2.Config{
+		ClientID:    "client-id",
+		Endpoint:    oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth"},
+		RedirectURL: "urn:ietf:wg:oauth:2.0:oob",
+		Scopes:      []string{"https://mail.google.com/"},
+	}
+	parse := func(c *qt.C, opts SetupOptions) url.Values {
+		u, err := url.Parse(authCodeURL(config, opts))
+		c.Assert(err, qt.IsNil)
+		return u.Query()
+	}
+
+	c.Run("defaults", func(c *qt.C) {
+		q := parse(c, SetupOptions{})
+		c.Assert(q.Get("state"), qt.Equals, "state-token")
+		c.Assert(q.Get("access_type"), qt.Equals, "offline")
+		c.Assert(q.Has("prompt"), qt.IsFalse)
+	})
+
+	c.Run("options", func(c *qt.C) {
+		q := parse(c, SetupOptions{
+			State:           "xyz",
+			ForceConsent:    true,
+			AuthCodeOptions: []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("include_granted_scopes", "true")},
+		})
+		c.Assert(q.Get("state"), qt.Equals, "xyz")
+		c.Assert(q.Get("prompt"), qt.Equals, "consent")
+		c.Assert(q.Get("include_granted_scopes"), qt.Equals, "true")
+		c.Assert(q.Get("access_type"), qt.Equals, "offline")
+	})
+}
+
+func TestEnsureGmailService(t *testing.T) {
+	c := qt.New(t)
+
+	dir := c.TempDir()
+	credentials := writeCredentials(c)
+	tokenFile := filepath.Join(dir, TokenFile)
+	c.Patch(&tokenCacheFile, func() (string, error) { return tokenFile, nil })
+
+	setups := 0
+	c.Patch(&runSetup, func(credentialsPath string, scope ...string) (SetupResult, error) {
+		setups++
+		saveToken(tokenFile, &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"})
+		return SetupResult{TokenPath: tokenFile, Created: true}, nil
+	})
+
+	c.Run("no token", func(c *qt.C) {
+		s, err := EnsureGmailService(credentials, "https://mail.google.com/")
+		c.Assert(err, qt.IsNil)
+		c.Assert(s.GmailSvc, qt.Not(qt.IsNil))
+		c.Assert(setups, qt.Equals, 1)
+	})
+
+	c.Run("token present", func(c *qt.C) {
+		_, err := EnsureGmailService(credentials, "https://mail.google.com/")
+		c.Assert(err, qt.IsNil)
+		c.Assert(setups, qt.Equals, 1)
+	})
+
+	c.Run("expired token", func(c *qt.C) {
+		saveToken(tokenFile, &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(-time.Hour)})
+		_, err := EnsureGmailService(credentials, "https://mail.google.com/")
+		c.Assert(err, qt.IsNil)
+		c.Assert(setups, qt.Equals, 2)
+	})
+}
+
+func TestSetupGmailService(t *testing.T) {
+	c := qt.New(t)
+
+	credentials := writeCredentials(c)
+	tokenFile := filepath.Join(c.TempDir(), TokenFile)
+	c.Patch(&tokenCacheFile, func() (string, error) { return tokenFile, nil })
+	flows := 0
+	c.Patch(&tokenFromWeb, func(config *oauth2.Config, opts SetupOptions) *oauth2.Token {
+		flows++
+		return &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	})
+
+	res, err := SetupGmailService(credentials, "https://mail.google.com/")
+	c.Assert(err, qt.IsNil)
+	c.Assert(res, qt.Equals, SetupResult{TokenPath: tokenFile, Created: true})
+	c.Assert(flows, qt.Equals, 1)
+	token, err := tokenFromFile(tokenFile)
+	c.Assert(err, qt.IsNil)
+	c.Assert(token.RefreshToken, qt.Equals, "refresh")
+
+	// an existing token doesn't stop the flow, so it can authorize again
+	res, err = SetupGmailService(credentials, "https://mail.google.com/")
+	c.Assert(err, qt.IsNil)
+	c.Assert(res, qt.Equals, SetupResult{TokenPath: tokenFile, Created: true})
+	c.Assert(flows, qt.Equals, 2)
+
+	res, err = EnsureToken(credentials, "https://mail.google.com/")
+	c.Assert(err, qt.IsNil)
+	c.Assert(res, qt.Equals, SetupResult{TokenPath: tokenFile, Created: false})
+	c.Assert(flows, qt.Equals, 2)
+
+	_, err = SetupGmailService(filepath.Join(c.TempDir(), "missing.json"))
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(os.Remove(tokenFile), qt.IsNil)
+	res, err = EnsureToken(credentials, "https://mail.google.com/")
+	c.Assert(err, qt.IsNil)
+	c.Assert(res, qt.Equals, SetupResult{TokenPath: tokenFile, Created: true})
+	c.Assert(flows, qt.Equals, 3)
+}
@@ -0,0 +1,200 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	// PartID is the ID of the message part holding the attachment.
+	PartID   string
+	Filename string
+	MimeType string
+	// DetectedMimeType is the mime type sniffed from the content (see
+	// http.DetectContentType), useful when MimeType is missing or generic
+	// like application/octet-stream. It is application/octet-stream when the
+	// content isn't recognized.
+	DetectedMimeType string
+	// Size is the size of the attachment as declared by the message.
+	Size int64
+	// Data is the decoded content of the attachment.
+	Data []byte
+}
+
+// ErrAttachmentSizeMismatch is returned (wrapped) by GetAttachments when the
+// downloaded content doesn't have the size declared by the message, which
+// means it was truncated or corrupted.
+var ErrAttachmentSizeMismatch = errors.New("attachment size mismatch")
+
+// attachmentSizeTolerance is how many bytes the declared size of an attachment
+// may be off by.
+const attachmentSizeTolerance = 16
+
+// GetAttachments downloads every attachment of the message.
+func (s *Service) GetAttachments(msg *gmail.Message) ([]*Attachment, error) {
+	return s.GetAttachmentsByType(msg)
+}
+
+// GetAttachmentsByType downloads the attachments of the message matching one
+// of the given mime types. Types may use a wildcard subtype ("image/*"), and
+// no types at all matches every attachment. Attachments that don't match are
+// never downloaded. Attachments larger than s.MaxBodyBytes make it fail with
+// ErrBodyTooLarge, and the ones that don't have their declared size with
+// ErrAttachmentSizeMismatch.
+func (s *Service) GetAttachmentsByType(msg *gmail.Message, mimeTypes ...string) ([]*Attachment, error) {
+	var attachments []*Attachment
+	var err error
+	walkParts(msg.Payload, func(p *gmail.MessagePart) {
+		if err != nil || !isAttachment(p) || !matchesMimeType(p.MimeType, mimeTypes) {
+			return
+		}
+
+		a := &Attachment{PartID: p.PartId, Filename: p.Filename, MimeType: p.MimeType, Size: p.Body.Size}
+		if err = checkBodySize(p.Body, s.maxBodyBytes()); err != nil {
+			return
+		}
+		body := p.Body
+		if p.Body.AttachmentId != "" {
+			if body, err = s.GetAttachment(msg.Id, p.Body.AttachmentId); err != nil {
+				return
+			}
+			if err = checkBodySize(body, s.maxBodyBytes()); err != nil {
+				return
+			}
+		}
+		if a.Data, err = decodeBase64URL(body.Data); err != nil {
+			return
+		}
+		if err = checkAttachmentSize(a); err != nil {
+			return
+		}
+		a.DetectedMimeType = http.DetectContentType(a.Data)
+		attachments = append(attachments, a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// GetAttachmentData downloads and decodes the attachment, given the IDs of its
+// message and of the attachment (the part's Body.AttachmentId), e.g. as found
+// when the message was listed, without getting the message. Attachments
+// larger than s.MaxBodyBytes make it fail with ErrBodyTooLarge.
+func (s *Service) GetAttachmentData(msgID, attachmentID string) ([]byte, error) {
+	body, err := s.GetAttachment(msgID, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBodySize(body, s.maxBodyBytes()); err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(body.Data)
+}
+
+// checkAttachmentSize checks that the decoded data of the attachment has its
+// declared size, which gmail gives decoded too. A zero size is an unknown one.
+func checkAttachmentSize(a *Attachment) error {
+	if a.Size == 0 {
+		return nil
+	}
+	decoded := int64(len(a.Data))
+	if a.Size < decoded-attachmentSizeTolerance || a.Size > decoded+attachmentSizeTolerance {
+		return fmt.Errorf("%w: %s: expected %d bytes, got %d", ErrAttachmentSizeMismatch, a.Filename, a.Size, decoded)
+	}
+	return nil
+}
+
+// walkParts calls fn for part and all of its descendants, depth first.
+func walkParts(part *gmail.MessagePart, fn func(*gmail.MessagePart)) {
+	if part == nil {
+		return
+	}
+	fn(part)
+	for _, p := range part.Parts {
+		walkParts(p, fn)
+	}
+}
+
+// isAttachment reports whether the part is a file rather than a body or a
+// multipart container.
+func isAttachment(p *gmail.MessagePart) bool {
+	if p.Body == nil || strings.HasPrefix(p.MimeType, "multipart/") {
+		return false
+	}
+	return p.Filename != "" || p.Body.AttachmentId != ""
+}
+
+// matchesMimeType reports whether mimeType matches one of the patterns, which
+// may have a wildcard subtype ("image/*"). No patterns matches everything.
+func matchesMimeType(mimeType string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "*" || p == "*/*" || p == mimeType:
+			return true
+		case strings.HasSuffix(p, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(p, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBase64URL decodes the base64url data returned by the API, which may or
+// may not be padded.
+func decodeBase64URL(data string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+}
+
+// AttachmentStats are the number and total size of attachments, as returned
+// by Service.AttachmentStats.
+type AttachmentStats struct {
+	Count int
+	Size  int64
+	// ByMimeType holds the stats of each attachment mime type.
+	ByMimeType map[string]MimeTypeStats
+}
+
+// MimeTypeStats are the number and total size of attachments of a mime type.
+type MimeTypeStats struct {
+	Count int
+	Size  int64
+}
+
+// AttachmentStats counts the attachments of the messages matching the query
+// and sums their sizes, grouped by mime type. Sizes are the ones declared by
+// the messages: attachments are never downloaded.
+func (s *Service) AttachmentStats(query string) (AttachmentStats, error) {
+	stats := AttachmentStats{ByMimeType: map[string]MimeTypeStats{}}
+	// the parts are needed, whatever DefaultFormat is
+	err := s.forEachMessage(s.GmailSvc.Users.Messages.List("me").Q(query), "full", 0, func(msg *gmail.Message) error {
+		walkParts(msg.Payload, func(p *gmail.MessagePart) {
+			if !isAttachment(p) {
+				return
+			}
+			mimeType := strings.ToLower(strings.TrimSpace(strings.Split(p.MimeType, ";")[0]))
+			t := stats.ByMimeType[mimeType]
+			t.Count++
+			t.Size += p.Body.Size
+			stats.ByMimeType[mimeType] = t
+			stats.Count++
+			stats.Size += p.Body.Size
+		})
+		return nil
+	})
+	if err != nil {
+		return AttachmentStats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,199 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestGetAttachmentsByType(t *testing.T) {
+	c := qt.New(t)
+
+	encode := func(s string) string { return base64.URLEncoding.EncodeToString([]byte(s)) }
+	msg := newMessage(
+		newPart("text/plain", "see attached"),
+		newAttachmentPart("invoice.pdf", "application/pdf", "a1", 4),
+		newAttachmentPart("photo.jpg", "image/jpeg", "a2", 4),
+		newAttachmentPart("notes.txt", "text/plain", "a3", 5),
+	)
+	msg.Id = "m1"
+	// small attachments can be inlined in the message itself
+	inline := newPart("image/png", "png!")
+	inline.Filename = "logo.png"
+	msg.Payload.Parts = append(msg.Payload.Parts, inline)
+
+	fake := &fakeGmail{attachments: map[string]string{
+		"a1": encode("%PDF"),
+		"a2": encode("jpeg"),
+		"a3": encode("notes"),
+	}}
+
+	names := func(atts []*Attachment) []string {
+		var out []string
+		for _, a := range atts {
+			out = append(out, a.Filename)
+		}
+		return out
+	}
+
+	c.Run("all", func(c *qt.C) {
+		atts, err := newFakeService(t, fake).GetAttachments(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(names(atts), qt.DeepEquals, []string{"invoice.pdf", "photo.jpg", "notes.txt", "logo.png"})
+		c.Assert(string(atts[0].Data), qt.Equals, "%PDF")
+		c.Assert(string(atts[3].Data), qt.Equals, "png!")
+	})
+
+	c.Run("exact", func(c *qt.C) {
+		atts, err := newFakeService(t, fake).GetAttachmentsByType(msg, "application/pdf")
+		c.Assert(err, qt.IsNil)
+		c.Assert(names(atts), qt.DeepEquals, []string{"invoice.pdf"})
+	})
+
+	c.Run("wildcard", func(c *qt.C) {
+		f := &fakeGmail{attachments: fake.attachments}
+		atts, err := newFakeService(t, f).GetAttachmentsByType(msg, "application/pdf", "IMAGE/*")
+		c.Assert(err, qt.IsNil)
+		c.Assert(names(atts), qt.DeepEquals, []string{"invoice.pdf", "photo.jpg", "logo.png"})
+		// notes.txt is never downloaded
+		c.Assert(f.callCount("GET /messages/m1/attachments/a3"), qt.Equals, 0)
+	})
+
+	c.Run("no match", func(c *qt.C) {
+		atts, err := newFakeService(t, fake).GetAttachmentsByType(msg, "video/*")
+		c.Assert(err, qt.IsNil)
+		c.Assert(atts, qt.HasLen, 0)
+	})
+}
+
+func TestMatchesMimeType(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(matchesMimeType("image/png", []string{"image/*"}), qt.IsTrue)
+	c.Assert(matchesMimeType("application/pdf; name=a.pdf", []string{"application/pdf"}), qt.IsTrue)
+	c.Assert(matchesMimeType("imagery/png", []string{"image/*"}), qt.IsFalse)
+	c.Assert(matchesMimeType("text/plain", []string{"*/*"}), qt.IsTrue)
+	c.Assert(matchesMimeType("text/plain", nil), qt.IsTrue)
+}
+
+func TestAttachmentStats(t *testing.T) {
+	c := qt.New(t)
+
+	m1 := newMessage(
+		newPart("text/plain", "see attached"),
+		newAttachmentPart("invoice.pdf", "application/pdf", "a1", 1000),
+		newAttachmentPart("photo.jpg", "image/jpeg", "a2", 300),
+	)
+	m1.Id = "m1"
+	m2 := newMessage(
+		newPart("text/plain", "another one"),
+		newAttachmentPart("receipt.pdf", "application/pdf", "a3", 500),
+	)
+	m2.Id = "m2"
+	m3 := newMessage(newPart("text/plain", "nothing attached"))
+	m3.Id = "m3"
+
+	fake := &fakeGmail{messages: []*gmail.Message{m1, m2, m3}}
+	stats, err := newFakeService(t, fake).AttachmentStats("has:attachment")
+	c.Assert(err, qt.IsNil)
+	c.Assert(stats, qt.DeepEquals, AttachmentStats{
+		Count: 3,
+		Size:  1800,
+		ByMimeType: map[string]MimeTypeStats{
+			"application/pdf": {Count: 2, Size: 1500},
+			"image/jpeg":      {Count: 1, Size: 300},
+		},
+	})
+	// sizes come from the messages, nothing is downloaded
+	c.Assert(fake.callCount("GET /messages/m1/attachments/a1"), qt.Equals, 0)
+}
+
+func TestGetAttachmentsTooLarge(t *testing.T) {
+	c := qt.New(t)
+
+	data := strings.Repeat("A", 4<<10)
+	msg := newMessage(newAttachmentPart("huge.iso", "application/octet-stream", "a1", int64(len(data))))
+	msg.Id = "m1"
+	fake := &fakeGmail{attachments: map[string]string{"a1": base64.URLEncoding.EncodeToString([]byte(data))}}
+
+	s := newFakeService(t, fake)
+	s.MaxBodyBytes = 1 << 10
+	_, err := s.GetAttachments(msg)
+	c.Assert(errors.Is(err, ErrBodyTooLarge), qt.IsTrue, qt.Commentf("%v", err))
+	// the size is checked before downloading anything
+	c.Assert(fake.callCount("GET /messages/m1/attachments/a1"), qt.Equals, 0)
+
+	s.MaxBodyBytes = -1
+	atts, err := s.GetAttachments(msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(atts, qt.HasLen, 1)
+}
+
+func TestDetectedMimeType(t *testing.T) {
+	c := qt.New(t)
+
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+	msg := newMessage(
+		newAttachmentPart("image", "application/octet-stream", "a1", int64(len(png))),
+		newAttachmentPart("report.pdf", "", "a2", 8),
+	)
+	msg.Id = "m1"
+	fake := &fakeGmail{attachments: map[string]string{
+		"a1": base64.URLEncoding.EncodeToString([]byte(png)),
+		"a2": base64.URLEncoding.EncodeToString([]byte("%PDF-1.7")),
+	}}
+
+	atts, err := newFakeService(t, fake).GetAttachments(msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(atts, qt.HasLen, 2)
+	c.Assert(atts[0].MimeType, qt.Equals, "application/octet-stream")
+	c.Assert(atts[0].DetectedMimeType, qt.Equals, "image/png")
+	c.Assert(atts[1].MimeType, qt.Equals, "")
+	c.Assert(atts[1].DetectedMimeType, qt.Equals, "application/pdf")
+}
+
+func TestGetAttachmentsSizeMismatch(t *testing.T) {
+	c := qt.New(t)
+
+	data := strings.Repeat("x", 1000)
+	msg := newMessage(
+		newAttachmentPart("exact.bin", "application/octet-stream", "a1", 1000),
+		// within the tolerance
+		newAttachmentPart("close.bin", "application/octet-stream", "a1", 1010),
+	)
+	msg.Id = "m1"
+	fake := &fakeGmail{attachments: map[string]string{"a1": base64.URLEncoding.EncodeToString([]byte(data))}}
+
+	atts, err := newFakeService(t, fake).GetAttachments(msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(atts, qt.HasLen, 2)
+
+	// the download was cut short
+	msg.Payload.Parts = append(msg.Payload.Parts, newAttachmentPart("truncated.bin", "application/octet-stream", "a1", 4000))
+	_, err = newFakeService(t, fake).GetAttachments(msg)
+	c.Assert(errors.Is(err, ErrAttachmentSizeMismatch), qt.IsTrue)
+	c.Assert(err, qt.ErrorMatches, "attachment size mismatch: truncated.bin: expected 4000 bytes, got 1000")
+
+	// even when only a part of it is missing
+	msg.Payload.Parts[2] = newAttachmentPart("short.bin", "application/octet-stream", "a1", 1200)
+	_, err = newFakeService(t, fake).GetAttachments(msg)
+	c.Assert(err, qt.ErrorMatches, "attachment size mismatch: short.bin: expected 1200 bytes, got 1000")
+}
+
+func TestGetAttachmentData(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{attachments: map[string]string{"a1": base64.URLEncoding.EncodeToString([]byte("%PDF-1.7"))}}
+	s := newFakeService(t, fake)
+	data, err := s.GetAttachmentData("m1", "a1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "%PDF-1.7")
+	c.Assert(fake.calls, qt.DeepEquals, []string{"GET /messages/m1/attachments/a1"})
+
+	s.MaxBodyBytes = 4
+	_, err = s.GetAttachmentData("m1", "a1")
+	c.Assert(errors.Is(err, ErrBodyTooLarge), qt.IsTrue)
+}
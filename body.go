@@ -0,0 +1,74 @@
+package inboxer
+
+import (
+	"errors"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"google.golang.org/api/gmail/v1"
+)
+
+// DecodedBody is a message body along with how it was encoded, as returned by
+// GetBodyDetailed.
+type DecodedBody struct {
+	// Content is the body converted to UTF-8.
+	Content  string
+	MimeType string
+	// Charset is the character set the body was written in, as declared by
+	// its Content-Type ("iso-8859-1"...), in lower case. Empty when none is
+	// declared.
+	Charset string
+	// Encoding is the Content-Transfer-Encoding the body was sent with
+	// ("quoted-printable", "base64"...), in lower case. Gmail has already
+	// decoded it. Empty when none is declared.
+	Encoding string
+	// Truncated reports whether Content was cut at DefaultMaxBodyBytes.
+	Truncated bool
+}
+
+// GetBodyDetailed works like GetBody, but also reports the charset and
+// encoding of the body, and converts it to UTF-8. Bodies larger than
+// DefaultMaxBodyBytes are truncated rather than rejected. Bodies in a charset
+// that isn't known are returned as is.
+func GetBodyDetailed(msg *gmail.Message, mimeType string) (*DecodedBody, error) {
+	part := findBodyPart(msg, mimeType)
+	if part == nil {
+		return nil, errors.New("couldn't read body")
+	}
+
+	body := &DecodedBody{
+		MimeType: part.MimeType,
+		Encoding: strings.ToLower(strings.TrimSpace(partHeader(part, "Content-Transfer-Encoding"))),
+	}
+	if _, params, err := mime.ParseMediaType(partHeader(part, "Content-Type")); err == nil {
+		body.Charset = strings.ToLower(params["charset"])
+	}
+
+	data := strings.TrimRight(part.Body.Data, "=")
+	// 4 base64 characters hold 3 bytes; the declared size can't be trusted
+	if limit := DefaultMaxBodyBytes / 3 * 4; len(data) > limit {
+		data = data[:limit]
+		body.Truncated = true
+	}
+	raw, err := decodeBase64URL(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body.Content = string(raw)
+	switch body.Charset {
+	case "", "utf-8", "utf8", "us-ascii":
+	default:
+		if enc, err := htmlindex.Get(body.Charset); err == nil {
+			if content, err := enc.NewDecoder().String(body.Content); err == nil {
+				body.Content = content
+			}
+		}
+	}
+	if body.Truncated {
+		// the last character may have been cut
+		body.Content = strings.ToValidUTF8(body.Content, "")
+	}
+	return body, nil
+}
@@ -0,0 +1,64 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestGetBodyDetailed(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("latin-1", func(c *qt.C) {
+		part := newPart("text/plain", "caf\xe9 cr\xe8me")
+		part.Headers = []*gmail.MessagePartHeader{
+			{Name: "Content-Type", Value: `text/plain; charset="ISO-8859-1"`},
+			{Name: "Content-Transfer-Encoding", Value: "Quoted-Printable"},
+		}
+		body, err := GetBodyDetailed(newMessage(part), "text/plain")
+		c.Assert(err, qt.IsNil)
+		c.Assert(body, qt.DeepEquals, &DecodedBody{
+			Content:  "café crème",
+			MimeType: "text/plain",
+			Charset:  "iso-8859-1",
+			Encoding: "quoted-printable",
+		})
+	})
+
+	c.Run("undeclared", func(c *qt.C) {
+		body, err := GetBodyDetailed(newMessage(newPart("text/html", "<p>hi</p>")), "text/html")
+		c.Assert(err, qt.IsNil)
+		c.Assert(body.Content, qt.Equals, "<p>hi</p>")
+		c.Assert(body.Charset, qt.Equals, "")
+		c.Assert(body.Encoding, qt.Equals, "")
+	})
+
+	c.Run("truncated", func(c *qt.C) {
+		part := newPart("text/plain", "")
+		part.Body.Data = base64.URLEncoding.EncodeToString([]byte(strings.Repeat("é", DefaultMaxBodyBytes)))
+		part.Body.Size = 2 * DefaultMaxBodyBytes
+		body, err := GetBodyDetailed(newMessage(part), "text/plain")
+		c.Assert(err, qt.IsNil)
+		c.Assert(body.Truncated, qt.IsTrue)
+		c.Assert(len(body.Content) <= DefaultMaxBodyBytes, qt.IsTrue)
+		c.Assert(strings.Trim(body.Content, "é"), qt.Equals, "")
+	})
+
+	c.Run("declared too large", func(c *qt.C) {
+		// only the declared size is over the limit
+		part := newPart("text/plain", "the body is actually small")
+		part.Body.Size = 300 << 20
+		body, err := GetBodyDetailed(newMessage(part), "text/plain")
+		c.Assert(err, qt.IsNil)
+		c.Assert(body.Truncated, qt.IsFalse)
+		c.Assert(body.Content, qt.Equals, "the body is actually small")
+	})
+
+	c.Run("missing", func(c *qt.C) {
+		_, err := GetBodyDetailed(newMessage(newPart("text/html", "<p>hi</p>")), "text/plain")
+		c.Assert(err, qt.ErrorMatches, "couldn't read body")
+	})
+}
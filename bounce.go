@@ -0,0 +1,112 @@
+package inboxer
+
+import (
+	"bufio"
+	"mime"
+	"net/textproto"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// BounceInfo is the delivery failure reported by a bounce message, as returned
+// by ParseBounce.
+type BounceInfo struct {
+	// Recipient is the address the message couldn't be delivered to.
+	Recipient string
+	// Action is what happened to the message for the recipient: "failed",
+	// or "delayed" when the server is still trying.
+	Action string
+	// Status is the status code of the failure (RFC 3463), e.g. "5.1.1" when
+	// the address doesn't exist. 5.x.x codes are permanent failures, 4.x.x
+	// ones temporary.
+	Status string
+	// Diagnostic is the reply of the recipient's server, e.g. "550 5.1.1 user
+	// unknown", when reported.
+	Diagnostic string
+	// ReportingMTA is the server that reported the failure.
+	ReportingMTA string
+}
+
+// Permanent reports whether the failure is permanent: retrying won't deliver
+// the message, and the address can be considered undeliverable.
+func (b *BounceInfo) Permanent() bool {
+	return strings.HasPrefix(b.Status, "5")
+}
+
+// ParseBounce parses a delivery status notification (RFC 3464), the
+// multipart/report message a server sends back when it can't deliver a
+// message, returning the failure of the first failed recipient. It returns
+// false for other messages. The message must have been got in the full format.
+func ParseBounce(msg *gmail.Message) (*BounceInfo, bool) {
+	var status *gmail.MessagePart
+	walkParts(msg.Payload, func(p *gmail.MessagePart) {
+		if status == nil && isDeliveryReport(p) {
+			for _, part := range p.Parts {
+				if strings.EqualFold(part.MimeType, "message/delivery-status") && hasBodyData(part) {
+					status = part
+				}
+			}
+		}
+	})
+	if status == nil {
+		return nil, false
+	}
+	body, err := decodeBody(status.Body, DefaultMaxBodyBytes)
+	if err != nil {
+		return nil, false
+	}
+
+	// a block of fields about the message, then one block per recipient
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimLeft(body, "\r\n"))))
+	perMessage, err := r.ReadMIMEHeader()
+	if err != nil && len(perMessage) == 0 {
+		return nil, false
+	}
+	var info *BounceInfo
+	for err == nil {
+		var fields textproto.MIMEHeader
+		fields, err = r.ReadMIMEHeader()
+		if len(fields) == 0 {
+			continue
+		}
+		recipient := &BounceInfo{
+			Recipient:    typedValue(firstNonEmpty(fields.Get("Final-Recipient"), fields.Get("Original-Recipient"))),
+			Action:       strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+			Status:       strings.TrimSpace(fields.Get("Status")),
+			Diagnostic:   typedValue(fields.Get("Diagnostic-Code")),
+			ReportingMTA: typedValue(perMessage.Get("Reporting-MTA")),
+		}
+		if info == nil || info.Action != "failed" && recipient.Action == "failed" {
+			info = recipient
+		}
+	}
+	if info == nil {
+		return nil, false
+	}
+	return info, true
+}
+
+// isDeliveryReport reports whether the part is a multipart/report of the
+// delivery-status type.
+func isDeliveryReport(p *gmail.MessagePart) bool {
+	if !strings.EqualFold(p.MimeType, "multipart/report") {
+		return false
+	}
+	for _, h := range p.Headers {
+		if strings.EqualFold(h.Name, "Content-Type") {
+			_, params, err := mime.ParseMediaType(h.Value)
+			return err == nil && strings.EqualFold(params["report-type"], "delivery-status")
+		}
+	}
+	return false
+}
+
+// typedValue returns the value of a delivery status field of the form
+// "type; value", such as "rfc822; bob@example.com".
+func typedValue(field string) string {
+	if _, v, ok := strings.Cut(field, ";"); ok {
+		field = v
+	}
+	return strings.Join(strings.Fields(field), " ")
+}
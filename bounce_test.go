@@ -0,0 +1,57 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// deliveryStatus is the message/delivery-status part of a bounce of a
+// message sent to two recipients, one of them having failed.
+const deliveryStatus = "Reporting-MTA: dns; mx.example.org\r\n" +
+	"Arrival-Date: Mon, 2 Jan 2023 12:00:00 +0000\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; alice@example.org\r\n" +
+	"Action: delivered\r\n" +
+	"Status: 2.0.0\r\n" +
+	"\r\n" +
+	"Original-Recipient: rfc822;Bob@example.org\r\n" +
+	"Final-Recipient: rfc822; bob@example.org\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 <bob@example.org>:\r\n" +
+	"    Recipient address rejected: User unknown\r\n"
+
+func newBounce(reportType string) *gmail.Message {
+	report := newMessage(
+		newPart("text/plain", "Delivery to the following recipient failed permanently: bob@example.org"),
+		newPart("message/delivery-status", deliveryStatus),
+		&gmail.MessagePart{MimeType: "message/rfc822"},
+	)
+	report.Payload.MimeType = "multipart/report"
+	report.Payload.Headers = []*gmail.MessagePartHeader{
+		{Name: "Content-Type", Value: `multipart/report; report-type=` + reportType + `; boundary="000000000000abcdef"`},
+	}
+	return withHeaders(report, "From", "Mail Delivery Subsystem <mailer-daemon@googlemail.com>")
+}
+
+func TestParseBounce(t *testing.T) {
+	c := qt.New(t)
+
+	info, ok := ParseBounce(newBounce("delivery-status"))
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info, qt.DeepEquals, &BounceInfo{
+		Recipient:    "bob@example.org",
+		Action:       "failed",
+		Status:       "5.1.1",
+		Diagnostic:   "550 5.1.1 <bob@example.org>: Recipient address rejected: User unknown",
+		ReportingMTA: "mx.example.org",
+	})
+	c.Assert(info.Permanent(), qt.IsTrue)
+
+	_, ok = ParseBounce(newBounce("disposition-notification"))
+	c.Assert(ok, qt.IsFalse)
+	_, ok = ParseBounce(newMessage(newPart("text/plain", "Hi")))
+	c.Assert(ok, qt.IsFalse)
+}
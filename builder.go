@@ -0,0 +1,329 @@
+package inboxer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the line length headers are folded at (RFC 5322 2.1.1).
+const maxLineLength = 78
+
+// MessageBuilder builds RFC 2822 messages ready to be sent through the Gmail
+// API. The zero value is ready to use.
+type MessageBuilder struct {
+	headers     []header
+	contentType string
+	body        string
+	attachments []*builderPart
+	inline      []*builderPart
+}
+
+type header struct {
+	name, value string
+}
+
+type builderPart struct {
+	filename    string
+	contentType string
+	contentID   string
+	data        []byte
+}
+
+// NewMessageBuilder returns an empty MessageBuilder.
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{}
+}
+
+// SetHeader sets the header name to value, replacing any previous value.
+// Headers are written in the order they were first set. Build fails if name
+// or value holds a line break, which would let the value inject headers.
+func (b *MessageBuilder) SetHeader(name, value string) *MessageBuilder {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	for i := range b.headers {
+		if b.headers[i].name == name {
+			b.headers[i].value = value
+			return b
+		}
+	}
+	b.headers = append(b.headers, header{name, value})
+	return b
+}
+
+// SetBody sets the body of the message. contentType is usually "text/plain"
+// or "text/html"; the body is always sent as UTF-8.
+func (b *MessageBuilder) SetBody(contentType, body string) *MessageBuilder {
+	b.contentType = contentType
+	b.body = body
+	return b
+}
+
+// AddAttachment attaches data to the message as filename.
+func (b *MessageBuilder) AddAttachment(filename, contentType string, data []byte) *MessageBuilder {
+	b.attachments = append(b.attachments, &builderPart{filename: filename, contentType: contentType, data: data})
+	return b
+}
+
+// AddInline adds a part that can be referenced from the html body as
+// "cid:<contentID>" (e.g. an embedded image).
+func (b *MessageBuilder) AddInline(contentID, filename, contentType string, data []byte) *MessageBuilder {
+	b.inline = append(b.inline, &builderPart{filename: filename, contentType: contentType, contentID: contentID, data: data})
+	return b
+}
+
+// Build returns the raw message.
+func (b *MessageBuilder) Build() ([]byte, error) {
+	if b.contentType == "" && len(b.attachments) == 0 && len(b.inline) == 0 {
+		return nil, errors.New("message has no body")
+	}
+
+	for _, h := range b.headers {
+		if strings.ContainsAny(h.name, "\r\n") || strings.ContainsAny(h.value, "\r\n") {
+			return nil, fmt.Errorf("header %q holds a line break", h.name)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "MIME-Version", "1.0")
+	for _, h := range b.headers {
+		if h.name == "Mime-Version" || h.name == "Content-Type" || h.name == "Content-Transfer-Encoding" {
+			continue
+		}
+		writeHeader(&buf, h.name, encodeHeader(h.name, h.value))
+	}
+
+	if err := b.writeContent(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BuildBase64 returns the raw message encoded the way the Gmail API expects it
+// in gmail.Message.Raw.
+func (b *MessageBuilder) BuildBase64() (string, error) {
+	raw, err := b.Build()
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(raw), nil
+}
+
+// writeContent writes the Content-Type header followed by the (possibly
+// multipart) content of the message.
+func (b *MessageBuilder) writeContent(buf *bytes.Buffer) error {
+	if len(b.attachments) == 0 {
+		return b.writeRelated(buf, nil)
+	}
+
+	mw := multipart.NewWriter(buf)
+	writeHeader(buf, "Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+
+	if b.contentType != "" || len(b.inline) > 0 {
+		if err := b.writeRelated(buf, mw); err != nil {
+			return err
+		}
+	}
+	for _, a := range b.attachments {
+		if err := writeBinaryPart(mw, a, "attachment"); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeRelated writes the body along with its inline parts, either as the top
+// level content or as a part of parent.
+func (b *MessageBuilder) writeRelated(buf *bytes.Buffer, parent *multipart.Writer) error {
+	if len(b.inline) == 0 {
+		return b.writeBody(buf, parent)
+	}
+
+	mw := multipart.NewWriter(buf)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType("multipart/related", map[string]string{"boundary": mw.Boundary()}))
+	if _, err := section(buf, parent, h); err != nil {
+		return err
+	}
+
+	if b.contentType != "" {
+		if err := b.writeBody(buf, mw); err != nil {
+			return err
+		}
+	}
+	for _, p := range b.inline {
+		if err := writeBinaryPart(mw, p, "inline"); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeBody writes the quoted-printable encoded body, either as the top level
+// content or as a part of parent.
+func (b *MessageBuilder) writeBody(buf *bytes.Buffer, parent *multipart.Writer) error {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(b.contentType, map[string]string{"charset": "utf-8"}))
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	w, err := section(buf, parent, h)
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(b.body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// section starts a new section of the message with the headers h. Without a
+// parent the headers are written at the top level of the message.
+func section(buf *bytes.Buffer, parent *multipart.Writer, h textproto.MIMEHeader) (io.Writer, error) {
+	if parent != nil {
+		return parent.CreatePart(h)
+	}
+
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			writeHeader(buf, k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	return buf, nil
+}
+
+// writeBinaryPart writes p as a base64 encoded part of mw.
+func writeBinaryPart(mw *multipart.Writer, p *builderPart, disposition string) error {
+	contentType := p.contentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	if p.filename != "" {
+		h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": p.filename}))
+	} else {
+		h.Set("Content-Disposition", disposition)
+	}
+	if p.contentID != "" {
+		h.Set("Content-ID", "<"+p.contentID+">")
+	}
+
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+
+	enc := base64.StdEncoding.EncodeToString(p.data)
+	for len(enc) > 76 {
+		if _, err := fmt.Fprintf(w, "%s\r\n", enc[:76]); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = fmt.Fprintf(w, "%s\r\n", enc)
+	return err
+}
+
+// addressHeaders are the headers holding address lists, whose display names
+// have to be encoded separately from the addresses.
+var addressHeaders = map[string]bool{
+	"From":        true,
+	"To":          true,
+	"Cc":          true,
+	"Bcc":         true,
+	"Reply-To":    true,
+	"Sender":      true,
+	"Resent-From": true,
+	"Resent-To":   true,
+}
+
+// encodeHeader encodes non-ASCII text in a header value as RFC 2047 encoded
+// words. In address headers only the display names are encoded.
+func encodeHeader(name, value string) string {
+	if isASCII(value) {
+		return value
+	}
+
+	if addressHeaders[name] {
+		if addrs, err := mail.ParseAddressList(value); err == nil {
+			list := make([]string, len(addrs))
+			for i, a := range addrs {
+				// mail.Address.String encodes non-ASCII names
+				list[i] = a.String()
+			}
+			return strings.Join(list, ", ")
+		}
+	}
+	return encodeWords(value)
+}
+
+// encodeWords encodes s with whichever of the Q and B encodings is shorter.
+// Long values are split in several encoded words separated by spaces, so they
+// can be folded.
+func encodeWords(s string) string {
+	q := mime.QEncoding.Encode("utf-8", s)
+	b := mime.BEncoding.Encode("utf-8", s)
+	if len(b) < len(q) {
+		return b
+	}
+	return q
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// writeHeader writes a header line, folding it at whitespace so no line is
+// longer than maxLineLength when possible.
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(foldHeader(name + ": " + value))
+	buf.WriteString("\r\n")
+}
+
+// foldHeader folds a header line at whitespace (RFC 5322 2.2.3). Words longer
+// than the limit are kept whole, since they can't be split.
+func foldHeader(line string) string {
+	if len(line) <= maxLineLength {
+		return line
+	}
+
+	var out strings.Builder
+	lineLen := 0
+	for i, word := range strings.Split(line, " ") {
+		if i > 0 {
+			if lineLen+1+len(word) > maxLineLength {
+				out.WriteString("\r\n")
+				lineLen = 0
+			}
+			out.WriteString(" ")
+			lineLen++
+		}
+		out.WriteString(word)
+		lineLen += len(word)
+	}
+	return out.String()
+}
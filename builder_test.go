@@ -0,0 +1,162 @@
+package inboxer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// parseBuilt parses a message produced by MessageBuilder.
+func parseBuilt(c *qt.C, b *MessageBuilder) *mail.Message {
+	raw, err := b.Build()
+	c.Assert(err, qt.IsNil)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	c.Assert(err, qt.IsNil)
+	return msg
+}
+
+func TestMessageBuilder(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("plain body", func(c *qt.C) {
+		b := NewMessageBuilder().
+			SetHeader("To", "bob@example.com").
+			SetHeader("subject", "Hello").
+			SetBody("text/plain", "Hi Bob,\nhow are you?")
+		msg := parseBuilt(c, b)
+		c.Assert(msg.Header.Get("Subject"), qt.Equals, "Hello")
+		c.Assert(msg.Header.Get("MIME-Version"), qt.Equals, "1.0")
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(mediaType, qt.Equals, "text/plain")
+		c.Assert(params["charset"], qt.Equals, "utf-8")
+
+		body, err := io.ReadAll(msg.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Equals, "Hi Bob,\r\nhow are you?")
+	})
+
+	c.Run("header folding", func(c *qt.C) {
+		to := strings.Repeat("someone@example.com, ", 10) + "last@example.com"
+		raw, err := NewMessageBuilder().SetHeader("To", to).SetBody("text/plain", "hi").Build()
+		c.Assert(err, qt.IsNil)
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			c.Assert(len(line) <= maxLineLength, qt.IsTrue, qt.Commentf("line too long: %q", line))
+		}
+
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		c.Assert(err, qt.IsNil)
+		addrs, err := msg.Header.AddressList("To")
+		c.Assert(err, qt.IsNil)
+		c.Assert(addrs, qt.HasLen, 11)
+	})
+
+	c.Run("non-ASCII headers", func(c *qt.C) {
+		subject := "🎉 Party tonight — don't forget to bring snacks, drinks and your best dance moves 🕺"
+		raw, err := NewMessageBuilder().
+			SetHeader("From", `"山田 太郎" <taro@example.jp>`).
+			SetHeader("To", "bob@example.com").
+			SetHeader("Subject", subject).
+			SetBody("text/plain", "hi").
+			Build()
+		c.Assert(err, qt.IsNil)
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			c.Assert(isASCII(line), qt.IsTrue, qt.Commentf("line not encoded: %q", line))
+			c.Assert(len(line) <= maxLineLength, qt.IsTrue, qt.Commentf("line too long: %q", line))
+		}
+		c.Assert(strings.Contains(string(raw), "=?utf-8?"), qt.IsTrue)
+
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		c.Assert(err, qt.IsNil)
+		got, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.Equals, subject)
+
+		from, err := msg.Header.AddressList("From")
+		c.Assert(err, qt.IsNil)
+		c.Assert(from, qt.DeepEquals, []*mail.Address{{Name: "山田 太郎", Address: "taro@example.jp"}})
+		c.Assert(msg.Header.Get("To"), qt.Equals, "bob@example.com")
+	})
+
+	c.Run("attachments and inline parts", func(c *qt.C) {
+		pdf := bytes.Repeat([]byte("%PDF-1.4 "), 50)
+		b := NewMessageBuilder().
+			SetHeader("Subject", "Report").
+			SetBody("text/html", `<p>See <img src="cid:logo"></p>`).
+			AddInline("logo", "logo.png", "image/png", []byte("\x89PNG")).
+			AddAttachment("report.pdf", "application/pdf", pdf)
+		msg := parseBuilt(c, b)
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(mediaType, qt.Equals, "multipart/mixed")
+
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+		related, err := mr.NextPart()
+		c.Assert(err, qt.IsNil)
+		mediaType, relParams, err := mime.ParseMediaType(related.Header.Get("Content-Type"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(mediaType, qt.Equals, "multipart/related")
+		c.Assert(relParams["boundary"], qt.Not(qt.Equals), params["boundary"])
+
+		rr := multipart.NewReader(related, relParams["boundary"])
+		html, err := rr.NextPart()
+		c.Assert(err, qt.IsNil)
+		body, err := io.ReadAll(html)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Equals, `<p>See <img src="cid:logo"></p>`)
+		logo, err := rr.NextPart()
+		c.Assert(err, qt.IsNil)
+		c.Assert(logo.Header.Get("Content-ID"), qt.Equals, "<logo>")
+		c.Assert(logo.FileName(), qt.Equals, "logo.png")
+		_, err = rr.NextPart()
+		c.Assert(err, qt.Equals, io.EOF)
+
+		att, err := mr.NextPart()
+		c.Assert(err, qt.IsNil)
+		c.Assert(att.FileName(), qt.Equals, "report.pdf")
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, att))
+		c.Assert(err, qt.IsNil)
+		c.Assert(data, qt.DeepEquals, pdf)
+		_, err = mr.NextPart()
+		c.Assert(err, qt.Equals, io.EOF)
+	})
+
+	c.Run("base64", func(c *qt.C) {
+		b := NewMessageBuilder().SetBody("text/plain", "hi")
+		raw, err := b.Build()
+		c.Assert(err, qt.IsNil)
+		enc, err := b.BuildBase64()
+		c.Assert(err, qt.IsNil)
+		dec, err := base64.URLEncoding.DecodeString(enc)
+		c.Assert(err, qt.IsNil)
+		c.Assert(dec, qt.DeepEquals, raw)
+	})
+
+	c.Run("no body", func(c *qt.C) {
+		_, err := NewMessageBuilder().SetHeader("Subject", "empty").Build()
+		c.Assert(err, qt.ErrorMatches, "message has no body")
+	})
+
+	c.Run("line breaks in headers", func(c *qt.C) {
+		_, err := NewMessageBuilder().
+			SetHeader("Subject", "hi\r\nBcc: everyone@example.com").
+			SetBody("text/plain", "hi").
+			Build()
+		c.Assert(err, qt.ErrorMatches, `header "Subject" holds a line break`)
+
+		_, err = NewMessageBuilder().
+			SetHeader("X-Custom\nBcc", "everyone@example.com").
+			SetBody("text/plain", "hi").
+			Build()
+		c.Assert(err, qt.ErrorMatches, `header "X-Custom\\nBcc" holds a line break`)
+	})
+}
@@ -0,0 +1,231 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// batchModifyLimit is the maximum number of messages a BatchModify request
+// can change.
+var batchModifyLimit = 1000
+
+// batchConcurrency is how many BatchModify requests MarkAllAsRead makes at
+// once.
+var batchConcurrency = 4
+
+// ApplyToQuery adds and removes labels (by ID) on every message matching the
+// query, returning how many messages were matched. It is the building block of
+// rules like "archive every promotion older than a month":
+//
+//	s.ApplyToQuery("category:promotions older_than:1m", nil, []string{"INBOX"})
+//
+// With s.DryRun set, the messages are counted but left untouched.
+func (s *Service) ApplyToQuery(query string, add, remove []string) (int, error) {
+	ids, err := s.listIDs(s.GmailSvc.Users.Messages.List("me").Q(query))
+	if err != nil {
+		return 0, err
+	}
+	if s.DryRun {
+		return len(ids), nil
+	}
+	if err := s.BatchModify(ids, add, remove); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// ArchiveOlderThan archives (removes from the inbox) the inbox messages
+// received more than d ago, returning how many messages were matched. With
+// s.DryRun set, the messages are counted but left untouched.
+func (s *Service) ArchiveOlderThan(d time.Duration) (int, error) {
+	// before: takes seconds since the epoch as well as dates
+	call := s.GmailSvc.Users.Messages.List("me").LabelIds("INBOX").Q(fmt.Sprintf("before:%d", now().Add(-d).Unix()))
+	ids, err := s.listIDs(call)
+	if err != nil {
+		return 0, err
+	}
+	if s.DryRun {
+		return len(ids), nil
+	}
+	if err := s.BatchModify(ids, nil, []string{"INBOX"}); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// listIDs returns the IDs of every message listed by the call.
+func (s *Service) listIDs(call *gmail.UsersMessagesListCall) ([]string, error) {
+	var ids []string
+	err := s.listPages(call, func(res *gmail.ListMessagesResponse) error {
+		for _, msg := range res.Messages {
+			ids = append(ids, msg.Id)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// BatchModify adds and removes labels (by ID) on the messages, in as many
+// requests as needed. It stops at the first failed request, leaving the
+// changes already made in place; see BatchModifyAtomic.
+func (s *Service) BatchModify(ids, add, remove []string) error {
+	for _, chunk := range chunks(ids, batchModifyLimit) {
+		if err := s.modifyChunk(chunk, add, remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchResult is the outcome of BatchModifyPartial.
+type BatchResult struct {
+	// Succeeded are the IDs of the messages changed.
+	Succeeded []string
+	// Failed holds the error of each message not changed, by ID. A request
+	// changes up to 1000 messages at once, and either all or none of them:
+	// the messages of a failed request share the same error.
+	Failed map[string]error
+}
+
+// BatchModifyPartial works like BatchModify, but doesn't stop at the first
+// failed request: it reports which messages were changed and which weren't,
+// so only the latter need to be retried. Failed is nil when every message was
+// changed.
+func (s *Service) BatchModifyPartial(ids, add, remove []string) BatchResult {
+	var res BatchResult
+	for _, chunk := range chunks(ids, batchModifyLimit) {
+		if err := s.modifyChunk(chunk, add, remove); err != nil {
+			if res.Failed == nil {
+				res.Failed = map[string]error{}
+			}
+			for _, id := range chunk {
+				res.Failed[id] = err
+			}
+			continue
+		}
+		res.Succeeded = append(res.Succeeded, chunk...)
+	}
+	return res
+}
+
+// BatchModifyAtomic works like BatchModify, but when a request fails the
+// changes made by the previous requests are reverted before returning, so
+// that either every message is changed or none is. The labels of the messages
+// are read before they are changed (a request per message), so that reverting
+// only takes back the labels a message actually gained or lost. Reverting can
+// fail too, in which case both errors are returned and the messages are left
+// partially changed.
+func (s *Service) BatchModifyAtomic(ids, add, remove []string) error {
+	var done []labelChange
+	for _, chunk := range chunks(ids, batchModifyLimit) {
+		changes, err := s.labelChanges(chunk, add, remove)
+		if err == nil {
+			err = s.modifyChunk(chunk, add, remove)
+		}
+		if err == nil {
+			done = append(done, changes...)
+			continue
+		}
+
+		if rbErr := s.revertLabelChanges(done); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback failed: %w", rbErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// labelChange is what a modification changes on a message: the labels it
+// gains and the ones it loses.
+type labelChange struct {
+	id             string
+	added, removed []string
+}
+
+// labelChanges returns what adding and removing the labels changes on each
+// message, given the labels they have now.
+func (s *Service) labelChanges(ids, add, remove []string) ([]labelChange, error) {
+	msgs := make([]*gmail.Message, len(ids))
+	for i, id := range ids {
+		msgs[i] = &gmail.Message{Id: id}
+	}
+	var changes []labelChange
+	err := s.forEachByID(msgs, "minimal", nil, func(msg *gmail.Message) error {
+		change := labelChange{id: msg.Id}
+		for _, l := range add {
+			if !contains(msg.LabelIds, l) {
+				change.added = append(change.added, l)
+			}
+		}
+		for _, l := range remove {
+			if contains(msg.LabelIds, l) {
+				change.removed = append(change.removed, l)
+			}
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	return changes, err
+}
+
+// revertLabelChanges takes back the changes, in one request (or more, past
+// batchModifyLimit) per set of messages changed the same way.
+func (s *Service) revertLabelChanges(changes []labelChange) error {
+	type revert struct {
+		ids, add, remove []string
+	}
+	var reverts []*revert
+	byChange := map[string]*revert{}
+	for _, change := range changes {
+		if len(change.added) == 0 && len(change.removed) == 0 {
+			continue
+		}
+		key := strings.Join(change.added, ",") + "/" + strings.Join(change.removed, ",")
+		r := byChange[key]
+		if r == nil {
+			r = &revert{add: change.removed, remove: change.added}
+			byChange[key] = r
+			reverts = append(reverts, r)
+		}
+		r.ids = append(r.ids, change.id)
+	}
+
+	for _, r := range reverts {
+		for _, chunk := range chunks(r.ids, batchModifyLimit) {
+			if err := s.modifyChunk(chunk, r.add, r.remove); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// modifyChunk changes the labels of up to batchModifyLimit messages.
+func (s *Service) modifyChunk(ids, add, remove []string) error {
+	if err := s.checkWritable("modify messages"); err != nil {
+		return err
+	}
+	req := &gmail.BatchModifyMessagesRequest{Ids: ids, AddLabelIds: add, RemoveLabelIds: remove}
+	defer s.cache.forget(ids...)
+	return s.call(func(ctx context.Context) error {
+		return s.GmailSvc.Users.Messages.BatchModify("me", req).Context(ctx).Do()
+	})
+}
+
+// chunks splits ids in slices of at most size IDs.
+func chunks(ids []string, size int) [][]string {
+	var out [][]string
+	for len(ids) > size {
+		out = append(out, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		out = append(out, ids)
+	}
+	return out
+}
@@ -0,0 +1,218 @@
+package inboxer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// promotions is a mailbox where the query "promo" matches three messages.
+func promotions() *fakeGmail {
+	return &fakeGmail{
+		messages: []*gmail.Message{
+			{Id: "p1", LabelIds: []string{"INBOX", "CATEGORY_PROMOTIONS"}},
+			{Id: "m1", LabelIds: []string{"INBOX"}},
+			{Id: "p2", LabelIds: []string{"INBOX", "CATEGORY_PROMOTIONS"}},
+			{Id: "p3", LabelIds: []string{"INBOX", "CATEGORY_PROMOTIONS"}},
+		},
+		match: func(q string, msg *gmail.Message) bool {
+			return q == "promo" && strings.HasPrefix(msg.Id, "p")
+		},
+	}
+}
+
+// countingLimiter counts the requests it lets through.
+type countingLimiter struct {
+	mu    sync.Mutex
+	waits int
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waits++
+	return nil
+}
+
+func TestApplyToQuery(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("applies", func(c *qt.C) {
+		fake := promotions()
+		n, err := newFakeService(t, fake).ApplyToQuery("promo", []string{"Label_1"}, []string{"INBOX"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, 3)
+		c.Assert(fake.batches, qt.HasLen, 1)
+		c.Assert(fake.batches[0].Ids, qt.DeepEquals, []string{"p1", "p2", "p3"})
+		c.Assert(fake.message("p2").LabelIds, qt.DeepEquals, []string{"CATEGORY_PROMOTIONS", "Label_1"})
+		c.Assert(fake.message("m1").LabelIds, qt.DeepEquals, []string{"INBOX"})
+	})
+
+	c.Run("chunks", func(c *qt.C) {
+		c.Patch(&batchModifyLimit, 2)
+		fake := promotions()
+		n, err := newFakeService(t, fake).ApplyToQuery("promo", nil, []string{"INBOX"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, 3)
+		c.Assert(fake.batches, qt.HasLen, 2)
+		c.Assert(fake.batches[1].Ids, qt.DeepEquals, []string{"p3"})
+	})
+
+	c.Run("dry run", func(c *qt.C) {
+		fake := promotions()
+		s := newFakeService(t, fake)
+		s.DryRun = true
+		n, err := s.ApplyToQuery("promo", nil, []string{"INBOX"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, 3)
+		c.Assert(fake.callCount("POST /messages/batchModify"), qt.Equals, 0)
+	})
+
+	c.Run("rate limited", func(c *qt.C) {
+		fake := promotions()
+		limiter := &countingLimiter{}
+		s := newFakeService(t, fake)
+		s.Limiter = limiter
+		_, err := s.ApplyToQuery("promo", nil, []string{"INBOX"})
+		c.Assert(err, qt.IsNil)
+		// one list and one batch request
+		c.Assert(limiter.waits, qt.Equals, 2)
+	})
+}
+
+func TestArchiveOlderThan(t *testing.T) {
+	c := qt.New(t)
+
+	current := time.Date(2023, 3, 15, 12, 0, 0, 0, time.UTC)
+	c.Patch(&now, func() time.Time { return current })
+	mailbox := func() *fakeGmail {
+		day := int64(24 * time.Hour / time.Millisecond)
+		f := &fakeGmail{messages: []*gmail.Message{
+			{Id: "new", LabelIds: []string{"INBOX"}, InternalDate: current.UnixMilli() - day},
+			{Id: "old", LabelIds: []string{"INBOX", "UNREAD"}, InternalDate: current.UnixMilli() - 40*day},
+			{Id: "older", LabelIds: []string{"INBOX"}, InternalDate: current.UnixMilli() - 400*day},
+			{Id: "archived", LabelIds: []string{"Label_1"}, InternalDate: current.UnixMilli() - 400*day},
+		}}
+		f.match = func(q string, m *gmail.Message) bool {
+			var before int64
+			fmt.Sscanf(q, "before:%d", &before)
+			return m.InternalDate < before*1000
+		}
+		return f
+	}
+
+	fake := mailbox()
+	s := newFakeService(t, fake)
+	s.DryRun = true
+	n, err := s.ArchiveOlderThan(30 * 24 * time.Hour)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 2)
+	c.Assert(fake.callCount("POST /messages/batchModify"), qt.Equals, 0)
+
+	s.DryRun = false
+	n, err = s.ArchiveOlderThan(30 * 24 * time.Hour)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 2)
+	c.Assert(fake.queries[0].Get("q"), qt.Equals, "before:1676289600")
+	c.Assert(fake.queries[0]["labelIds"], qt.DeepEquals, []string{"INBOX"})
+	c.Assert(fake.batches, qt.HasLen, 1)
+	c.Assert(fake.batches[0].Ids, qt.DeepEquals, []string{"old", "older"})
+	c.Assert(fake.batches[0].RemoveLabelIds, qt.DeepEquals, []string{"INBOX"})
+	c.Assert(fake.message("old").LabelIds, qt.DeepEquals, []string{"UNREAD"})
+	c.Assert(fake.message("new").LabelIds, qt.DeepEquals, []string{"INBOX"})
+}
+
+// failingBatch serves requests with f, failing the nth BatchModify request.
+func failingBatch(f *fakeGmail, n int) http.Handler {
+	count := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/messages/batchModify") {
+			if count++; count == n {
+				writeError(w, http.StatusInternalServerError, "backend error")
+				return
+			}
+		}
+		f.ServeHTTP(w, r)
+	})
+}
+
+func TestBatchModifyAtomic(t *testing.T) {
+	c := qt.New(t)
+	c.Patch(&batchModifyLimit, 2)
+	ids := []string{"p1", "m1", "p2", "p3"}
+
+	c.Run("success", func(c *qt.C) {
+		fake := promotions()
+		err := newFakeService(t, fake).BatchModifyAtomic(ids, []string{"Label_1"}, nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(fake.batches, qt.HasLen, 2)
+		c.Assert(fake.message("p3").LabelIds, qt.Contains, "Label_1")
+	})
+
+	c.Run("rollback", func(c *qt.C) {
+		fake := promotions()
+		fake.message("m1").LabelIds = []string{"INBOX", "Label_1"}
+		err := newTestService(t, failingBatch(fake, 2)).BatchModifyAtomic(ids, []string{"Label_1"}, []string{"CATEGORY_PROMOTIONS"})
+		c.Assert(err, qt.ErrorMatches, ".*backend error.*")
+		// the first chunk was applied, then reverted where it changed anything
+		c.Assert(fake.batches, qt.HasLen, 2)
+		c.Assert(fake.batches[1].Ids, qt.DeepEquals, []string{"p1"})
+		c.Assert(fake.batches[1].AddLabelIds, qt.DeepEquals, []string{"CATEGORY_PROMOTIONS"})
+		c.Assert(fake.batches[1].RemoveLabelIds, qt.DeepEquals, []string{"Label_1"})
+		c.Assert(fake.message("m1").LabelIds, qt.DeepEquals, []string{"INBOX", "Label_1"})
+		for _, id := range []string{"p1", "p2", "p3"} {
+			c.Assert(fake.message(id).LabelIds, qt.Not(qt.Contains), "Label_1")
+			c.Assert(fake.message(id).LabelIds, qt.Contains, "CATEGORY_PROMOTIONS")
+		}
+	})
+
+	c.Run("rollback failure", func(c *qt.C) {
+		fake := promotions()
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/messages/batchModify") && fake.callCount("POST /messages/batchModify") > 0 {
+				writeError(w, http.StatusInternalServerError, "backend error")
+				return
+			}
+			fake.ServeHTTP(w, r)
+		})
+		err := newTestService(t, h).BatchModifyAtomic(ids, []string{"Label_1"}, nil)
+		c.Assert(err, qt.ErrorMatches, "(?s).*backend error.*rollback failed.*")
+		c.Assert(fake.message("p1").LabelIds, qt.Contains, "Label_1")
+	})
+
+	c.Run("not atomic", func(c *qt.C) {
+		fake := promotions()
+		err := newTestService(t, failingBatch(fake, 2)).BatchModify(ids, []string{"Label_1"}, nil)
+		c.Assert(err, qt.Not(qt.IsNil))
+		c.Assert(fake.message("p1").LabelIds, qt.Contains, "Label_1")
+		c.Assert(fake.message("p3").LabelIds, qt.Not(qt.Contains), "Label_1")
+	})
+}
+
+func TestBatchModifyPartial(t *testing.T) {
+	c := qt.New(t)
+	c.Patch(&batchModifyLimit, 2)
+
+	fake := promotions()
+	fake.messages = append(fake.messages, &gmail.Message{Id: "m2", LabelIds: []string{"INBOX"}})
+	res := newTestService(t, failingBatch(fake, 2)).BatchModifyPartial([]string{"p1", "m1", "p2", "p3", "m2"}, []string{"Label_1"}, nil)
+
+	c.Assert(res.Succeeded, qt.DeepEquals, []string{"p1", "m1", "m2"})
+	c.Assert(res.Failed, qt.HasLen, 2)
+	c.Assert(res.Failed["p2"], qt.ErrorMatches, ".*backend error.*")
+	c.Assert(res.Failed["p3"], qt.Equals, res.Failed["p2"])
+	c.Assert(fake.message("m2").LabelIds, qt.Contains, "Label_1")
+	c.Assert(fake.message("p2").LabelIds, qt.Not(qt.Contains), "Label_1")
+
+	// retrying the failed messages only
+	res = newFakeService(t, fake).BatchModifyPartial([]string{"p2", "p3"}, []string{"Label_1"}, nil)
+	c.Assert(res.Failed, qt.IsNil)
+	c.Assert(res.Succeeded, qt.DeepEquals, []string{"p2", "p3"})
+}
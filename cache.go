@@ -0,0 +1,125 @@
+package inboxer
+
+import (
+	"container/list"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// messageCache is the LRU cache of the messages got by a Service (see
+// Service.CacheSize). A message can be cached in several variants (formats).
+type messageCache struct {
+	mu sync.Mutex
+	// entries holds the *cacheEntry values, the most recently used first.
+	entries *list.List
+	byID    map[string]map[string]*list.Element
+	// fetches holds the messages being fetched, for a fetch overlapping a
+	// forget not to cache the message as it was before being changed.
+	fetches map[string]*cacheFetch
+}
+
+// cacheFetch counts the fetches in flight for a message, and how many times
+// it was forgotten meanwhile.
+type cacheFetch struct {
+	pending    int
+	generation uint64
+}
+
+type cacheEntry struct {
+	id, variant string
+	msg         *gmail.Message
+}
+
+// get returns the cached variant of the message, if any.
+func (c *messageCache) get(id, variant string) (*gmail.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byID[id][variant]
+	if !ok {
+		return nil, false
+	}
+	c.entries.MoveToFront(e)
+	return e.Value.(*cacheEntry).msg, true
+}
+
+// fetching records that the message is being fetched, returning the
+// generation to give to fetched.
+func (c *messageCache) fetching(id string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetches == nil {
+		c.fetches = map[string]*cacheFetch{}
+	}
+	f := c.fetches[id]
+	if f == nil {
+		f = &cacheFetch{}
+		c.fetches[id] = f
+	}
+	f.pending++
+	return f.generation
+}
+
+// fetched ends a fetch started with fetching, caching the variant of the
+// message unless it was forgotten since (msg is nil when the fetch failed).
+func (c *messageCache) fetched(id string, generation uint64, variant string, msg *gmail.Message, max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.fetches[id]
+	if f.pending--; f.pending == 0 {
+		delete(c.fetches, id)
+	}
+	if msg != nil && f.generation == generation {
+		c.addLocked(id, variant, msg, max)
+	}
+}
+
+// addLocked caches the variant of the message, evicting the least recently
+// used messages beyond max entries.
+func (c *messageCache) addLocked(id, variant string, msg *gmail.Message, max int) {
+	if c.entries == nil {
+		c.entries = list.New()
+		c.byID = map[string]map[string]*list.Element{}
+	}
+	if e, ok := c.byID[id][variant]; ok {
+		e.Value.(*cacheEntry).msg = msg
+		c.entries.MoveToFront(e)
+		return
+	}
+	if c.byID[id] == nil {
+		c.byID[id] = map[string]*list.Element{}
+	}
+	c.byID[id][variant] = c.entries.PushFront(&cacheEntry{id: id, variant: variant, msg: msg})
+	for c.entries.Len() > max {
+		c.removeLocked(c.entries.Back())
+	}
+}
+
+// forget removes every variant of the messages from the cache.
+func (c *messageCache) forget(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		for _, e := range c.byID[id] {
+			c.removeLocked(e)
+		}
+		if f := c.fetches[id]; f != nil {
+			f.generation++
+		}
+	}
+}
+
+func (c *messageCache) removeLocked(e *list.Element) {
+	entry := c.entries.Remove(e).(*cacheEntry)
+	delete(c.byID[entry.id], entry.variant)
+	if len(c.byID[entry.id]) == 0 {
+		delete(c.byID, entry.id)
+	}
+}
+
+// ForgetMessages removes the messages from the cache (see CacheSize), e.g.
+// after changing them through GmailSvc directly. The methods of the Service
+// changing messages already do it.
+func (s *Service) ForgetMessages(ids ...string) {
+	s.cache.forget(ids...)
+}
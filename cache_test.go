@@ -0,0 +1,111 @@
+package inboxer
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestMessageCache(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "m1", LabelIds: []string{"INBOX", "UNREAD"}},
+		{Id: "m2", LabelIds: []string{"INBOX"}},
+		{Id: "m3", LabelIds: []string{"INBOX"}},
+	}}
+	s := newFakeService(t, fake)
+	s.CacheSize = 2
+
+	for i := 0; i < 2; i++ {
+		msg, err := s.GetMessage("m1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX", "UNREAD"})
+	}
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 1)
+
+	// another format is another entry
+	_, err := s.GetMessageWithFormat("m1", "minimal")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 2)
+
+	_, err = s.MarkAs("m1", &gmail.ModifyMessageRequest{RemoveLabelIds: []string{"UNREAD"}})
+	c.Assert(err, qt.IsNil)
+	msg, err := s.GetMessage("m1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX"})
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 3)
+
+	c.Assert(s.BatchModify([]string{"m1"}, []string{"STARRED"}, nil), qt.IsNil)
+	_, err = s.GetMessage("m1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 4)
+
+	// the least recently used messages are evicted
+	_, err = s.GetMessage("m2")
+	c.Assert(err, qt.IsNil)
+	_, err = s.GetMessage("m3")
+	c.Assert(err, qt.IsNil)
+	_, err = s.GetMessage("m1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 5)
+	_, err = s.GetMessage("m3")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/m3"), qt.Equals, 1)
+
+	s.ForgetMessages("m3")
+	_, err = s.GetMessage("m3")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/m3"), qt.Equals, 2)
+}
+
+func TestMessageCacheForgetDuringGet(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "m1", LabelIds: []string{"INBOX", "UNREAD"}}}}
+	// the first Get is answered with the message as it was when it arrived,
+	// once released
+	arrived, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/messages/m1") {
+			blocked := false
+			once.Do(func() { blocked = true })
+			if blocked {
+				old := *fake.message("m1")
+				close(arrived)
+				<-release
+				writeJSON(w, &old)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	})
+	s := newTestService(t, h)
+	s.CacheSize = 10
+
+	got := make(chan *gmail.Message)
+	go func() {
+		msg, err := s.GetMessage("m1")
+		c.Check(err, qt.IsNil)
+		got <- msg
+	}()
+	<-arrived
+	_, err := s.MarkAs("m1", &gmail.ModifyMessageRequest{RemoveLabelIds: []string{"UNREAD"}})
+	c.Assert(err, qt.IsNil)
+	close(release)
+	c.Assert((<-got).LabelIds, qt.DeepEquals, []string{"INBOX", "UNREAD"})
+
+	// the stale message wasn't cached
+	msg, err := s.GetMessage("m1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX"})
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 1)
+	_, err = s.GetMessage("m1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/m1"), qt.Equals, 1)
+}
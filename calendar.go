@@ -0,0 +1,168 @@
+package inboxer
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// ErrNoCalendarInvite is returned by GetCalendarInvite for messages without a
+// text/calendar part.
+var ErrNoCalendarInvite = errors.New("no calendar invite")
+
+// CalendarInvite is the event of an iCalendar (RFC 5545) invitation.
+type CalendarInvite struct {
+	// Method is what the invite is about: REQUEST for an invitation or an
+	// update, CANCEL for a cancellation, REPLY for an answer...
+	Method      string
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	// Start and End are the time span of the event. For all day events they
+	// are dates at midnight UTC, End being excluded.
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+	// Organizer is nil when the event doesn't say.
+	Organizer *mail.Address
+}
+
+// GetCalendarInvite parses the first event of the text/calendar part of the
+// message, returning ErrNoCalendarInvite when there is none. Parts only
+// available as attachments have to be downloaded first (see GetAttachments)
+// and parsed with ParseCalendarInvite.
+func GetCalendarInvite(msg *gmail.Message) (*CalendarInvite, error) {
+	var part *gmail.MessagePart
+	walkParts(msg.Payload, func(p *gmail.MessagePart) {
+		if part == nil && matchesMimeType(p.MimeType, []string{"text/calendar"}) && hasBodyData(p) {
+			part = p
+		}
+	})
+	if part == nil {
+		return nil, ErrNoCalendarInvite
+	}
+
+	ics, err := decodeBody(part.Body, DefaultMaxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCalendarInvite(ics)
+}
+
+// ParseCalendarInvite parses the first event of an iCalendar document.
+func ParseCalendarInvite(ics string) (*CalendarInvite, error) {
+	invite := &CalendarInvite{}
+	inEvent, found := false, false
+	for _, line := range unfoldICS(ics) {
+		name, params, value := parseICSLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && !found:
+			inEvent, found = true, true
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+		case name == "METHOD":
+			invite.Method = strings.ToUpper(value)
+		case !inEvent:
+		case name == "UID":
+			invite.UID = value
+		case name == "SUMMARY":
+			invite.Summary = unescapeICS(value)
+		case name == "DESCRIPTION":
+			invite.Description = unescapeICS(value)
+		case name == "LOCATION":
+			invite.Location = unescapeICS(value)
+		case name == "DTSTART":
+			t, allDay, err := parseICSTime(value, params)
+			if err != nil {
+				return nil, err
+			}
+			invite.Start, invite.AllDay = t, allDay
+		case name == "DTEND":
+			t, _, err := parseICSTime(value, params)
+			if err != nil {
+				return nil, err
+			}
+			invite.End = t
+		case name == "ORGANIZER":
+			addr := value
+			if len(addr) > 7 && strings.EqualFold(addr[:7], "mailto:") {
+				addr = addr[7:]
+			}
+			invite.Organizer = &mail.Address{Name: strings.Trim(params["CN"], `"`), Address: addr}
+		}
+	}
+	if !found {
+		return nil, ErrNoCalendarInvite
+	}
+	if invite.End.IsZero() && invite.AllDay {
+		invite.End = invite.Start.AddDate(0, 0, 1)
+	}
+	return invite, nil
+}
+
+// unfoldICS splits an iCalendar document in lines, joining the lines folded
+// over several ones (continuation lines start with a space or a tab).
+func unfoldICS(ics string) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.ReplaceAll(ics, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// parseICSLine parses a content line such as
+// "DTSTART;TZID=Europe/Paris:20230101T100000" in its upper cased name,
+// parameters (by upper cased name) and value.
+func parseICSLine(line string) (string, map[string]string, string) {
+	head, value, _ := strings.Cut(line, ":")
+	// the head may hold quoted parameter values with colons
+	for strings.Count(head, `"`)%2 == 1 {
+		var rest string
+		rest, value, _ = strings.Cut(value, ":")
+		head += ":" + rest
+	}
+
+	fields := strings.Split(head, ";")
+	params := map[string]string{}
+	for _, p := range fields[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(k)] = v
+	}
+	return strings.ToUpper(fields[0]), params, value
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, reporting whether it is a
+// date. Times without a time zone are taken as UTC.
+func parseICSTime(value string, params map[string]string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := time.UTC
+	if tz := strings.Trim(params["TZID"], `"`); tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// unescapeICS unescapes an iCalendar text value.
+func unescapeICS(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,62 @@
+package inboxer
+
+import (
+	"net/mail"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+const invite = "BEGIN:VCALENDAR\r\n" +
+	"PRODID:-//Google Inc//Google Calendar 70.9054//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Paris\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=Europe/Paris:20230315T100000\r\n" +
+	"DTEND:20230315T100000Z\r\n" +
+	"ORGANIZER;CN=\"Alice: Team Lead\":mailto:alice@example.com\r\n" +
+	"UID:abc123@google.com\r\n" +
+	"SUMMARY:Quarterly planning\\, part 1\r\n" +
+	"DESCRIPTION:Agenda:\\n- numbers\\n- roadmap that is long enough to be fol\r\n" +
+	" ded\r\n" +
+	"LOCATION:Room 4\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestGetCalendarInvite(t *testing.T) {
+	c := qt.New(t)
+
+	msg := newMessage(newPart("text/plain", "You're invited"), newPart("text/calendar", invite))
+	got, err := GetCalendarInvite(msg)
+	c.Assert(err, qt.IsNil)
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Method, qt.Equals, "REQUEST")
+	c.Assert(got.UID, qt.Equals, "abc123@google.com")
+	c.Assert(got.Summary, qt.Equals, "Quarterly planning, part 1")
+	c.Assert(got.Description, qt.Equals, "Agenda:\n- numbers\n- roadmap that is long enough to be folded")
+	c.Assert(got.Location, qt.Equals, "Room 4")
+	c.Assert(got.Start.Equal(time.Date(2023, 3, 15, 10, 0, 0, 0, paris)), qt.IsTrue, qt.Commentf("%v", got.Start))
+	c.Assert(got.End.Equal(time.Date(2023, 3, 15, 10, 0, 0, 0, time.UTC)), qt.IsTrue, qt.Commentf("%v", got.End))
+	c.Assert(got.AllDay, qt.IsFalse)
+	c.Assert(got.Organizer, qt.DeepEquals, &mail.Address{Name: "Alice: Team Lead", Address: "alice@example.com"})
+
+	_, err = GetCalendarInvite(newMessage(newPart("text/plain", "no invite")))
+	c.Assert(err, qt.Equals, ErrNoCalendarInvite)
+}
+
+func TestParseCalendarInviteAllDay(t *testing.T) {
+	c := qt.New(t)
+
+	got, err := ParseCalendarInvite("BEGIN:VCALENDAR\nMETHOD:CANCEL\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:20230401\nSUMMARY:Offsite\nEND:VEVENT\nEND:VCALENDAR\n")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Method, qt.Equals, "CANCEL")
+	c.Assert(got.AllDay, qt.IsTrue)
+	c.Assert(got.Start, qt.Equals, time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(got.End, qt.Equals, time.Date(2023, 4, 2, 0, 0, 0, 0, time.UTC))
+}
@@ -13,5 +13,9 @@ func main() {
 	if len(args) == 0 {
 		log.Fatal("you must pass your service account credentials as argument like: `go run main.go /Users/your_user/service_account_file.json`")
 	}
-	inboxer.SetupGmailService(args[0], gmail.MailGoogleComScope)
+	res, err := inboxer.SetupGmailService(args[0], gmail.MailGoogleComScope)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("token saved to %s", res.TokenPath)
 }
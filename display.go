@@ -0,0 +1,115 @@
+package inboxer
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DisplayCorrespondent returns who to show for the message in an inbox list,
+// the way gmail does: the sender's name for received mail, or "to: " followed
+// by the recipients for mail sent from ownAddress.
+func DisplayCorrespondent(msg *gmail.Message, ownAddress string) string {
+	h := GetHeaders(msg)
+	if !sameAddress(h.Get("From"), ownAddress) {
+		return displayName(parseAddress(h.Get("From")))
+	}
+
+	var names []string
+	for _, a := range parseAddressList(h.Get("To")) {
+		names = append(names, displayName(a))
+	}
+	return "to: " + strings.Join(names, ", ")
+}
+
+// displayName returns the name of the address, or the address itself when
+// there is no name.
+func displayName(a *mail.Address) string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Address
+}
+
+// DisplaySubject returns the subject to show for the message: its Subject
+// header, or "(no subject)" when it is blank, as gmail does.
+func DisplaySubject(msg *gmail.Message) string {
+	subject := strings.TrimSpace(GetHeaders(msg).Get("Subject"))
+	if subject == "" {
+		return "(no subject)"
+	}
+	return subject
+}
+
+// subjectPrefix matches a reply or forward subject prefix: "Re:", "Fwd:",
+// "Fw:", and forms like "RE[2]:" or "Re :".
+var subjectPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?)(\[\d+\])?\s*:\s*`)
+
+// NormalizeSubject removes the leading reply and forward prefixes of a
+// subject ("Re: Fwd: Lunch" becomes "Lunch"), so the messages of a
+// conversation can be grouped under the same title. Prefixes in the middle
+// of the subject are kept.
+func NormalizeSubject(subject string) string {
+	for {
+		loc := subjectPrefix.FindStringIndex(subject)
+		if loc == nil {
+			return strings.TrimSpace(subject)
+		}
+		subject = subject[loc[1]:]
+	}
+}
+
+// now returns the current time. Tests replace it with a fixed clock.
+var now = time.Now
+
+// TimeAgo returns how long ago t was, the way gmail shows it in an inbox
+// list: "just now", "5m ago" and "3h ago" for today, then "Yesterday", then
+// the date ("Mar 4", with the year when it isn't the current one). Days are
+// counted in the local time zone. See ReceivedTime.
+func TimeAgo(t time.Time) string {
+	current := now()
+	t = t.In(current.Location())
+	d := current.Sub(t)
+
+	y, m, day := current.Date()
+	today := time.Date(y, m, day, 0, 0, 0, 0, current.Location())
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case !t.Before(today):
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	case !t.Before(today.AddDate(0, 0, -1)):
+		return "Yesterday"
+	case t.Year() == y:
+		return t.Format("Jan 2")
+	}
+	return t.Format("Jan 2, 2006")
+}
+
+// webURL is the address of the gmail web UI, for the first signed in account.
+const webURL = "https://mail.google.com/mail/u/0/"
+
+// MessageWebURL returns the link opening the message in the gmail web UI. The
+// web UI opens conversations, not messages: the link is the one of the
+// message's thread (see ThreadWebURL), which shows the message.
+func MessageWebURL(msg *gmail.Message) string {
+	id := msg.ThreadId
+	if id == "" {
+		// the first message of a thread has the thread's ID
+		id = msg.Id
+	}
+	return ThreadWebURL(id)
+}
+
+// ThreadWebURL returns the link opening the thread in the gmail web UI,
+// whatever its labels are.
+func ThreadWebURL(threadID string) string {
+	return webURL + "#all/" + url.PathEscape(threadID)
+}
@@ -0,0 +1,96 @@
+package inboxer
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestDisplayCorrespondent(t *testing.T) {
+	c := qt.New(t)
+
+	const me = "me@example.com"
+	tests := []struct {
+		name    string
+		headers []string
+		want    string
+	}{
+		{"received", []string{"From", `"Alice Smith" <alice@example.com>`, "To", me}, "Alice Smith"},
+		{"received without name", []string{"From", "alice@example.com", "To", me}, "alice@example.com"},
+		{"encoded name", []string{"From", "=?utf-8?q?Ren=C3=A9?= <rene@example.com>", "To", me}, "René"},
+		{"sent", []string{"From", "Me <ME@example.com>", "To", "Bob <bob@example.com>, carol@example.com"}, "to: Bob, carol@example.com"},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			msg := withHeaders(&gmail.Message{}, test.headers...)
+			c.Assert(DisplayCorrespondent(msg, me), qt.Equals, test.want)
+		})
+	}
+}
+
+func TestDisplaySubject(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(DisplaySubject(withHeaders(&gmail.Message{}, "Subject", "Lunch")), qt.Equals, "Lunch")
+	c.Assert(DisplaySubject(withHeaders(&gmail.Message{}, "Subject", "  ")), qt.Equals, "(no subject)")
+	c.Assert(DisplaySubject(withHeaders(&gmail.Message{}, "From", "alice@example.com")), qt.Equals, "(no subject)")
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	c := qt.New(t)
+
+	for subject, want := range map[string]string{
+		"Lunch":              "Lunch",
+		"Re: Lunch":          "Lunch",
+		"RE: Fwd: re: Lunch": "Lunch",
+		"Fw: Re[2]: Lunch":   "Lunch",
+		"Re : Lunch":         "Lunch",
+		"Lunch (Re: Friday)": "Lunch (Re: Friday)",
+		"Report: Q3":         "Report: Q3",
+		"Refund: order 12":   "Refund: order 12",
+		"":                   "",
+	} {
+		c.Assert(NormalizeSubject(subject), qt.Equals, want, qt.Commentf("%q", subject))
+	}
+}
+
+func TestTimeAgo(t *testing.T) {
+	c := qt.New(t)
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	c.Assert(err, qt.IsNil)
+	current := time.Date(2023, 3, 15, 9, 30, 0, 0, paris)
+	c.Patch(&now, func() time.Time { return current })
+
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{current.Add(-20 * time.Second), "just now"},
+		{current.Add(time.Minute), "just now"},
+		{current.Add(-2 * time.Minute), "2m ago"},
+		{current.Add(-59 * time.Minute), "59m ago"},
+		{current.Add(-3 * time.Hour), "3h ago"},
+		// the day boundary is midnight in the local time zone, 23:00 UTC
+		{time.Date(2023, 3, 14, 23, 10, 0, 0, time.UTC), "9h ago"},
+		{time.Date(2023, 3, 14, 22, 50, 0, 0, time.UTC), "Yesterday"},
+		{time.Date(2023, 3, 14, 0, 5, 0, 0, paris), "Yesterday"},
+		{time.Date(2023, 3, 13, 23, 55, 0, 0, paris), "Mar 13"},
+		{time.Date(2023, 1, 4, 12, 0, 0, 0, paris), "Jan 4"},
+		{time.Date(2022, 12, 31, 12, 0, 0, 0, paris), "Dec 31, 2022"},
+	}
+	for _, test := range tests {
+		c.Check(TimeAgo(test.t), qt.Equals, test.want, qt.Commentf("%v", test.t))
+	}
+}
+
+func TestWebURLs(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(ThreadWebURL("18a2b3c4d5e6f708"), qt.Equals, "https://mail.google.com/mail/u/0/#all/18a2b3c4d5e6f708")
+	msg := &gmail.Message{Id: "18a2b3c4d5e6f799", ThreadId: "18a2b3c4d5e6f708"}
+	c.Assert(MessageWebURL(msg), qt.Equals, "https://mail.google.com/mail/u/0/#all/18a2b3c4d5e6f708")
+	c.Assert(MessageWebURL(&gmail.Message{Id: "18a2b3c4d5e6f799"}), qt.Equals, "https://mail.google.com/mail/u/0/#all/18a2b3c4d5e6f799")
+}
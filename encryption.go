@@ -0,0 +1,59 @@
+package inboxer
+
+import (
+	"mime"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// IsEncrypted reports whether the message is encrypted with PGP (PGP/MIME or
+// inline) or S/MIME. Decryption is not supported, but this lets a client show
+// a lock instead of trying to display the garbled body.
+func IsEncrypted(msg *gmail.Message) bool {
+	found := false
+	walkParts(msg.Payload, func(p *gmail.MessagePart) {
+		switch strings.ToLower(p.MimeType) {
+		case "multipart/encrypted", "application/pgp-encrypted":
+			found = true
+		case "application/pkcs7-mime", "application/x-pkcs7-mime":
+			found = found || smimeType(p) != "signed-data"
+		case "text/plain":
+			found = found || partContains(p, "-----BEGIN PGP MESSAGE-----")
+		}
+	})
+	return found
+}
+
+// IsSigned reports whether the message is signed with PGP (PGP/MIME or
+// inline) or S/MIME. The signature is not verified.
+func IsSigned(msg *gmail.Message) bool {
+	found := false
+	walkParts(msg.Payload, func(p *gmail.MessagePart) {
+		switch strings.ToLower(p.MimeType) {
+		case "multipart/signed":
+			found = true
+		case "application/pkcs7-mime", "application/x-pkcs7-mime":
+			found = found || smimeType(p) == "signed-data"
+		case "text/plain":
+			found = found || partContains(p, "-----BEGIN PGP SIGNED MESSAGE-----")
+		}
+	})
+	return found
+}
+
+// smimeType returns the smime-type parameter of an S/MIME part
+// ("enveloped-data", "signed-data"...).
+func smimeType(p *gmail.MessagePart) string {
+	_, params, _ := mime.ParseMediaType(partHeader(p, "Content-Type"))
+	return strings.ToLower(params["smime-type"])
+}
+
+// partContains reports whether the decoded body of the part contains s.
+func partContains(p *gmail.MessagePart, s string) bool {
+	if p.Body == nil || p.Body.Data == "" {
+		return false
+	}
+	data, err := decodeBase64URL(p.Body.Data)
+	return err == nil && strings.Contains(string(data), s)
+}
@@ -0,0 +1,54 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestIsEncrypted(t *testing.T) {
+	c := qt.New(t)
+
+	pgpMIME := &gmail.Message{Payload: &gmail.MessagePart{
+		MimeType: "multipart/encrypted",
+		Parts: []*gmail.MessagePart{
+			newPart("application/pgp-encrypted", "Version: 1"),
+			newPart("application/octet-stream", "-----BEGIN PGP MESSAGE-----\n\nhQEMA...\n-----END PGP MESSAGE-----"),
+		},
+	}}
+	inline := newMessage(newPart("text/plain", "-----BEGIN PGP MESSAGE-----\n\nhQEMA...\n-----END PGP MESSAGE-----"))
+
+	smime := newMessage(newPart("application/pkcs7-mime", "MIAGCSqG"))
+	smime.Payload.Parts[0].Headers = []*gmail.MessagePartHeader{{Name: "Content-Type", Value: `application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"`}}
+	smimeSigned := newMessage(newPart("application/pkcs7-mime", "MIAGCSqG"))
+	smimeSigned.Payload.Parts[0].Headers = []*gmail.MessagePartHeader{{Name: "Content-Type", Value: `application/pkcs7-mime; smime-type=signed-data; name="smime.p7m"`}}
+
+	signed := &gmail.Message{Payload: &gmail.MessagePart{
+		MimeType: "multipart/signed",
+		Parts: []*gmail.MessagePart{
+			newPart("text/plain", "hello"),
+			newPart("application/pgp-signature", "-----BEGIN PGP SIGNATURE-----"),
+		},
+	}}
+	plain := newMessage(newPart("text/plain", "hello"), newPart("text/html", "<p>hello</p>"))
+
+	tests := []struct {
+		name              string
+		msg               *gmail.Message
+		encrypted, signed bool
+	}{
+		{"pgp/mime", pgpMIME, true, false},
+		{"inline pgp", inline, true, false},
+		{"s/mime", smime, true, false},
+		{"s/mime signed", smimeSigned, false, true},
+		{"signed", signed, false, true},
+		{"plain", plain, false, false},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(IsEncrypted(test.msg), qt.Equals, test.encrypted)
+			c.Assert(IsSigned(test.msg), qt.Equals, test.signed)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package inboxer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// GetRawMessage returns the full RFC 2822 source of the message.
+func (s *Service) GetRawMessage(msgId string) ([]byte, error) {
+	_, raw, err := s.getRaw(msgId)
+	return raw, err
+}
+
+// getRaw fetches the message in the raw format, returning it along with its
+// decoded source.
+func (s *Service) getRaw(msgId string) (*gmail.Message, []byte, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	msg, err := s.GmailSvc.Users.Messages.Get("me", msgId).Format("raw").Context(ctx).Do()
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := decodeBase64URL(msg.Raw)
+	return msg, raw, err
+}
+
+// ExportEML writes the message to w as a .eml file that can be opened by
+// regular mail clients (Outlook, Thunderbird, Apple Mail...).
+func (s *Service) ExportEML(msgId string, w io.Writer) error {
+	raw, err := s.GetRawMessage(msgId)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(toCRLF(raw))
+	return err
+}
+
+// toCRLF converts bare LF line endings to CRLF, as required by RFC 5322.
+func toCRLF(data []byte) []byte {
+	if bytes.Count(data, []byte("\n")) == bytes.Count(data, []byte("\r\n")) {
+		return data
+	}
+	out := make([]byte, 0, len(data)+bytes.Count(data, []byte("\n")))
+	for i, b := range data {
+		if b == '\n' && (i == 0 || data[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// ExportQueryToMbox writes every message matching the query to w in the mbox
+// format (mboxrd flavor), returning how many messages were exported. Messages
+// are written as they are fetched, so the whole export is never held in memory.
+func (s *Service) ExportQueryToMbox(query string, w io.Writer) (int, error) {
+	n, total := 0, 0
+	err := s.listPages(s.GmailSvc.Users.Messages.List("me").Q(query), func(res *gmail.ListMessagesResponse) error {
+		if total == 0 {
+			total = int(res.ResultSizeEstimate)
+		}
+		for _, m := range res.Messages {
+			msg, raw, err := s.getRaw(m.Id)
+			if err != nil {
+				return err
+			}
+			if err := writeMboxMessage(w, msg, raw); err != nil {
+				return err
+			}
+			n++
+			s.progress(n, total)
+		}
+		return nil
+	})
+	return n, err
+}
+
+// mboxFromLine matches the lines that have to be escaped in an mbox body.
+var mboxFromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+// writeMboxMessage writes the "From " separator line followed by the
+// message, escaping lines of the body that would look like a separator.
+func writeMboxMessage(w io.Writer, msg *gmail.Message, raw []byte) error {
+	sender := "MAILER-DAEMON"
+	if m, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		if from := parseAddress(m.Header.Get("From")).Address; from != "" && !strings.ContainsAny(from, " \t") {
+			sender = from
+		}
+	}
+	date := time.UnixMilli(msg.InternalDate).UTC().Format(time.ANSIC)
+
+	body := bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	body = mboxFromLine.ReplaceAll(body, []byte(">$1"))
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		body = append(body, '\n')
+	}
+
+	if _, err := fmt.Fprintf(w, "From %s %s\n", sender, date); err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,67 @@
+package inboxer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/mail"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestExportEML(t *testing.T) {
+	c := qt.New(t)
+
+	// mixed line endings, as sometimes returned by the API
+	raw := "From: alice@example.com\nTo: bob@example.com\r\nSubject: Hi\n\nline one\nline two\r\n"
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "m1", Raw: base64.URLEncoding.EncodeToString([]byte(raw))}}}
+
+	var buf bytes.Buffer
+	err := newFakeService(t, fake).ExportEML("m1", &buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Equals, "From: alice@example.com\r\nTo: bob@example.com\r\nSubject: Hi\r\n\r\nline one\r\nline two\r\n")
+
+	msg, err := mail.ReadMessage(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.Header.Get("Subject"), qt.Equals, "Hi")
+	body, err := io.ReadAll(msg.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "line one\r\nline two\r\n")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	c.Assert(fake.calls, qt.DeepEquals, []string{"GET /messages/m1"})
+}
+
+func TestExportQueryToMbox(t *testing.T) {
+	c := qt.New(t)
+
+	encode := func(s string) string { return base64.URLEncoding.EncodeToString([]byte(s)) }
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "m1", InternalDate: 1672574400000, Raw: encode("From: Alice <alice@example.com>\r\nSubject: One\r\n\r\nFrom now on\r\n>From the top\r\nbye\r\n")},
+		{Id: "m2", InternalDate: 1672660800000, Raw: encode("Subject: Two\r\n\r\nhello")},
+	}}
+	service := newFakeService(t, fake)
+
+	var buf bytes.Buffer
+	n, err := service.ExportQueryToMbox("label:backup", &buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 2)
+	c.Assert(buf.String(), qt.Equals, `From alice@example.com Sun Jan  1 12:00:00 2023
+From: Alice <alice@example.com>
+Subject: One
+
+>From now on
+>>From the top
+bye
+
+From MAILER-DAEMON Mon Jan  2 12:00:00 2023
+Subject: Two
+
+hello
+
+`)
+	c.Assert(fake.queries[0].Get("q"), qt.Equals, "label:backup")
+}
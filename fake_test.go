@@ -0,0 +1,437 @@
+package inboxer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// fakeGmail is an in-memory implementation of the parts of the Gmail API
+// used by the Service.
+type fakeGmail struct {
+	mu sync.Mutex
+
+	// messages is the mailbox, in the order it is listed.
+	messages []*gmail.Message
+	labels   []*gmail.Label
+	// attachments maps attachment IDs to their base64url encoded data.
+	attachments map[string]string
+	// settings holds single settings resources (vacation, imap...) as JSON,
+	// keyed by their path under /settings.
+	settings map[string]json.RawMessage
+	// collections holds settings collections (filters, sendAs...) as JSON,
+	// keyed by their path under /settings.
+	collections map[string][]json.RawMessage
+
+	// match filters listed messages for a query. Without it, queries match
+	// every message.
+	match func(q string, msg *gmail.Message) bool
+	// pageSize caps the number of messages per list page, when not 0.
+	pageSize int
+	// fail makes requests to the given path (relative to /gmail/v1/users/me)
+	// fail with the given status code.
+	fail map[string]int
+	// handle serves every request not handled by the fake.
+	handle http.HandlerFunc
+
+	// calls records every request as "METHOD path", and params their query
+	// parameters.
+	calls  []string
+	params []url.Values
+	// queries records the query parameters of every list request.
+	queries []url.Values
+	// batches records every BatchModify request.
+	batches []*gmail.BatchModifyMessagesRequest
+	// sent records every message sent, and imported every message imported.
+	sent     []*gmail.Message
+	imported []*gmail.Message
+}
+
+// newFakeService returns a Service backed by f.
+func newFakeService(t testing.TB, f *fakeGmail, opts ...Option) *Service {
+	return newTestService(t, f, opts...)
+}
+
+// callCount returns how many requests were made as "METHOD path".
+func (f *fakeGmail) callCount(call string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+// paramsOf returns the query parameters of the requests made as "METHOD path".
+func (f *fakeGmail) paramsOf(call string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var params []url.Values
+	for i, c := range f.calls {
+		if c == call {
+			params = append(params, f.params[i])
+		}
+	}
+	return params
+}
+
+func (f *fakeGmail) message(id string) *gmail.Message {
+	for _, m := range f.messages {
+		if m.Id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (f *fakeGmail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me")
+	f.calls = append(f.calls, r.Method+" "+path)
+	f.params = append(f.params, r.URL.Query())
+	if code, ok := f.fail[path]; ok {
+		writeError(w, code, "failed by fake")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == "GET" && path == "/messages":
+		f.list(w, r)
+	case r.Method == "POST" && path == "/messages/batchModify":
+		req := &gmail.BatchModifyMessagesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.batches = append(f.batches, req)
+		for _, id := range req.Ids {
+			if m := f.message(id); m != nil {
+				m.LabelIds = modifyLabels(m.LabelIds, req.AddLabelIds, req.RemoveLabelIds)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && path == "/messages/send":
+		msg := &gmail.Message{}
+		if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		msg.Id = fmt.Sprintf("sent-%d", len(f.sent)+1)
+		msg.LabelIds = []string{"SENT"}
+		f.sent = append(f.sent, msg)
+		writeJSON(w, msg)
+	case r.Method == "POST" && path == "/messages/import":
+		msg := &gmail.Message{}
+		if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		msg.Id = fmt.Sprintf("imported-%d", len(f.imported)+1)
+		if msg.ThreadId == "" {
+			msg.ThreadId = "thread-" + msg.Id
+		}
+		f.imported = append(f.imported, msg)
+		f.messages = append(f.messages, msg)
+		writeJSON(w, msg)
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "messages":
+		m := f.message(parts[1])
+		if m == nil {
+			writeError(w, http.StatusNotFound, "Requested entity was not found.")
+			return
+		}
+		writeJSON(w, m)
+	case r.Method == "GET" && len(parts) == 4 && parts[0] == "messages" && parts[2] == "attachments":
+		data, ok := f.attachments[parts[3]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Requested entity was not found.")
+			return
+		}
+		writeJSON(w, &gmail.MessagePartBody{AttachmentId: parts[3], Data: data, Size: int64(len(data))})
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "messages" && parts[2] == "modify":
+		m := f.message(parts[1])
+		if m == nil {
+			writeError(w, http.StatusNotFound, "Requested entity was not found.")
+			return
+		}
+		req := &gmail.ModifyMessageRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		m.LabelIds = modifyLabels(m.LabelIds, req.AddLabelIds, req.RemoveLabelIds)
+		writeJSON(w, m)
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "threads":
+		thread := &gmail.Thread{Id: parts[1]}
+		for _, m := range f.messages {
+			if m.ThreadId == parts[1] {
+				thread.Messages = append(thread.Messages, m)
+			}
+		}
+		if len(thread.Messages) == 0 {
+			writeError(w, http.StatusNotFound, "Requested entity was not found.")
+			return
+		}
+		writeJSON(w, thread)
+	case len(parts) >= 2 && parts[0] == "settings":
+		f.serveSettings(w, r, parts[1:])
+	case r.Method == "GET" && path == "/labels":
+		writeJSON(w, &gmail.ListLabelsResponse{Labels: f.labels})
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "labels":
+		for _, l := range f.labels {
+			if l.Id == parts[1] {
+				writeJSON(w, l)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+	case r.Method == "POST" && path == "/labels":
+		label := &gmail.Label{}
+		if err := json.NewDecoder(r.Body).Decode(label); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		label.Id = fmt.Sprintf("Label_%d", len(f.labels)+1)
+		label.Type = "user"
+		f.labels = append(f.labels, label)
+		writeJSON(w, label)
+	case (r.Method == "PUT" || r.Method == "PATCH") && len(parts) == 2 && parts[0] == "labels":
+		update := &gmail.Label{}
+		if err := json.NewDecoder(r.Body).Decode(update); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, l := range f.labels {
+			if l.Id != parts[1] {
+				continue
+			}
+			if r.Method == "PUT" {
+				*l = *update
+				l.Id = parts[1]
+			} else if update.Color != nil {
+				l.Color = update.Color
+			}
+			writeJSON(w, l)
+			return
+		}
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+	case r.Method == "DELETE" && len(parts) == 2 && parts[0] == "labels":
+		for i, l := range f.labels {
+			if l.Id == parts[1] {
+				f.labels = append(f.labels[:i], f.labels[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+	case f.handle != nil:
+		f.handle(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not implemented by fake: "+r.Method+" "+path)
+	}
+}
+
+// collectionFields are the JSON fields holding the items of list responses,
+// when they differ from the collection name.
+var collectionFields = map[string]string{"filters": "filter"}
+
+// collectionKeys are the fields identifying the items of a collection.
+var collectionKeys = map[string]string{
+	"filters":             "id",
+	"forwardingAddresses": "forwardingEmail",
+	"sendAs":              "sendAsEmail",
+	"delegates":           "delegateEmail",
+}
+
+// serveSettings serves the settings resources and collections.
+func (f *fakeGmail) serveSettings(w http.ResponseWriter, r *http.Request, parts []string) {
+	name := parts[0]
+	body := func() json.RawMessage {
+		var raw json.RawMessage
+		json.NewDecoder(r.Body).Decode(&raw)
+		return raw
+	}
+
+	key, isCollection := collectionKeys[name]
+	if !isCollection {
+		switch r.Method {
+		case "GET":
+			raw, ok := f.settings[name]
+			if !ok {
+				writeError(w, http.StatusNotFound, "Requested entity was not found.")
+				return
+			}
+			writeJSON(w, raw)
+		case "PUT":
+			if f.settings == nil {
+				f.settings = map[string]json.RawMessage{}
+			}
+			f.settings[name] = body()
+			writeJSON(w, f.settings[name])
+		}
+		return
+	}
+
+	find := func(id string) int {
+		for i, raw := range f.collections[name] {
+			item := map[string]interface{}{}
+			json.Unmarshal(raw, &item)
+			if item[key] == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	switch {
+	case r.Method == "GET" && len(parts) == 1:
+		field := collectionFields[name]
+		if field == "" {
+			field = name
+		}
+		writeJSON(w, map[string]interface{}{field: f.collections[name]})
+	case r.Method == "POST" && len(parts) == 1:
+		if f.collections == nil {
+			f.collections = map[string][]json.RawMessage{}
+		}
+		raw := body()
+		if name == "filters" {
+			item := map[string]interface{}{}
+			json.Unmarshal(raw, &item)
+			item["id"] = fmt.Sprintf("filter-%d", len(f.collections[name])+1)
+			raw, _ = json.Marshal(item)
+		}
+		f.collections[name] = append(f.collections[name], raw)
+		writeJSON(w, raw)
+	case len(parts) == 2 && find(parts[1]) < 0:
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+	case r.Method == "GET" && len(parts) == 2:
+		writeJSON(w, f.collections[name][find(parts[1])])
+	case (r.Method == "PUT" || r.Method == "PATCH") && len(parts) == 2:
+		i := find(parts[1])
+		f.collections[name][i] = body()
+		writeJSON(w, f.collections[name][i])
+	case r.Method == "DELETE" && len(parts) == 2:
+		i := find(parts[1])
+		f.collections[name] = append(f.collections[name][:i], f.collections[name][i+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "not implemented by fake: "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// setSetting stores v as the settings resource name.
+func (f *fakeGmail) setSetting(name string, v interface{}) {
+	if f.settings == nil {
+		f.settings = map[string]json.RawMessage{}
+	}
+	f.settings[name], _ = json.Marshal(v)
+}
+
+// addToCollection adds the items to the settings collection name.
+func (f *fakeGmail) addToCollection(name string, items ...interface{}) {
+	if f.collections == nil {
+		f.collections = map[string][]json.RawMessage{}
+	}
+	for _, item := range items {
+		raw, _ := json.Marshal(item)
+		f.collections[name] = append(f.collections[name], raw)
+	}
+}
+
+// list serves Messages.List, honouring labelIds, q (through f.match),
+// maxResults and pageToken.
+func (f *fakeGmail) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f.queries = append(f.queries, query)
+
+	var matched []*gmail.Message
+	for _, m := range f.messages {
+		if !hasLabels(m, query["labelIds"]) {
+			continue
+		}
+		if q := query.Get("q"); q != "" && f.match != nil && !f.match(q, m) {
+			continue
+		}
+		matched = append(matched, m)
+	}
+
+	start, _ := strconv.Atoi(query.Get("pageToken"))
+	size := 100
+	if n, err := strconv.Atoi(query.Get("maxResults")); err == nil && n > 0 {
+		size = n
+	}
+	if f.pageSize > 0 && size > f.pageSize {
+		size = f.pageSize
+	}
+	end := start + size
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	res := &gmail.ListMessagesResponse{ResultSizeEstimate: int64(len(matched))}
+	for _, m := range matched[start:end] {
+		res.Messages = append(res.Messages, &gmail.Message{Id: m.Id, ThreadId: m.ThreadId})
+	}
+	if end < len(matched) {
+		res.NextPageToken = strconv.Itoa(end)
+	}
+	writeJSON(w, res)
+}
+
+func hasLabels(m *gmail.Message, labels []string) bool {
+	for _, l := range labels {
+		found := false
+		for _, ml := range m.LabelIds {
+			found = found || ml == l
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func modifyLabels(labels, add, remove []string) []string {
+	var out []string
+	for _, l := range labels {
+		keep := true
+		for _, r := range remove {
+			keep = keep && l != r
+		}
+		if keep {
+			out = append(out, l)
+		}
+	}
+	for _, a := range add {
+		found := false
+		for _, l := range out {
+			found = found || l == a
+		}
+		if !found {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// writeError writes a Gmail API error response.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,86 @@
+package inboxer
+
+import (
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// ForwardOptions are the options of Forward.
+type ForwardOptions struct {
+	// IncludeHeaders puts the From, Date, Subject and To headers of the
+	// original message above its body, the way mail clients do.
+	IncludeHeaders bool
+	// IncludeAttachments attaches the attachments of the original message.
+	IncludeAttachments bool
+	// AllowManyRecipients forwards the message whatever Service.MaxRecipients
+	// is.
+	AllowManyRecipients bool
+}
+
+// forwardPrefix matches the subject prefix of forwards.
+var forwardPrefix = regexp.MustCompile(`(?i)^\s*(fwd?|tr)\s*:`)
+
+// Forward forwards the message, which must have been got in the full format,
+// to the recipients, as text/plain with note above the original text. nil
+// options use the defaults of mail clients: the headers and the attachments
+// of the message are included.
+func (s *Service) Forward(msg *gmail.Message, to []string, note string, opts *ForwardOptions) (*gmail.Message, error) {
+	if err := s.checkWritable("send messages"); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &ForwardOptions{IncludeHeaders: true, IncludeAttachments: true}
+	}
+	if err := s.checkRecipients(opts.AllowManyRecipients, to); err != nil {
+		return nil, err
+	}
+	text, err := ExtractText(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := GetHeaders(msg)
+	var body strings.Builder
+	if note != "" {
+		body.WriteString(note + "\n\n")
+	}
+	if opts.IncludeHeaders {
+		body.WriteString("---------- Forwarded message ---------\n")
+		for _, name := range []string{"From", "Date", "Subject", "To", "Cc"} {
+			if v := h.Get(name); v != "" {
+				body.WriteString(name + ": " + v + "\n")
+			}
+		}
+		body.WriteString("\n")
+	}
+	body.WriteString(text)
+
+	subject := h.Get("Subject")
+	if !forwardPrefix.MatchString(subject) {
+		subject = "Fwd: " + subject
+	}
+	b := NewMessageBuilder().
+		SetHeader("To", strings.Join(to, ", ")).
+		SetHeader("Subject", subject).
+		SetHeader("Message-ID", newMessageID("")).
+		SetBody("text/plain", body.String())
+	if opts.IncludeAttachments {
+		attachments, err := s.GetAttachments(msg)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range attachments {
+			b.AddAttachment(a.Filename, a.MimeType, a.Data)
+		}
+	}
+	raw, err := b.BuildBase64()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Messages.Send("me", &gmail.Message{Raw: raw}).Context(ctx).Do()
+}
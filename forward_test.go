@@ -0,0 +1,75 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestForward(t *testing.T) {
+	c := qt.New(t)
+
+	msg := withHeaders(newMessage(
+		newPart("text/plain", "The report is attached."),
+		newAttachmentPart("report.pdf", "application/pdf", "a1", 4),
+	), "From", "Alice <alice@example.com>", "To", "me@example.com", "Subject", "Q3 report", "Date", "Mon, 2 Jan 2023 12:00:00 +0000")
+	msg.Id = "m1"
+
+	tests := []struct {
+		opts        *ForwardOptions
+		headers     bool
+		attachments []string
+	}{
+		{nil, true, []string{"report.pdf"}},
+		{&ForwardOptions{IncludeHeaders: true, IncludeAttachments: true}, true, []string{"report.pdf"}},
+		{&ForwardOptions{IncludeHeaders: true}, true, nil},
+		{&ForwardOptions{IncludeAttachments: true}, false, []string{"report.pdf"}},
+		{&ForwardOptions{}, false, nil},
+	}
+	for _, test := range tests {
+		c.Run("", func(c *qt.C) {
+			fake := &fakeGmail{attachments: map[string]string{"a1": base64.URLEncoding.EncodeToString([]byte("%PDF"))}}
+			_, err := newFakeService(c.TB, fake).Forward(msg, []string{"bob@example.com"}, "FYI", test.opts)
+			c.Assert(err, qt.IsNil)
+			c.Assert(fake.sent, qt.HasLen, 1)
+
+			m := sentMessage(c, fake.sent[0])
+			c.Assert(m.Header.Get("Subject"), qt.Equals, "Fwd: Q3 report")
+			c.Assert(m.Header.Get("To"), qt.Equals, "bob@example.com")
+
+			var body string
+			var attachments []string
+			mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+			c.Assert(err, qt.IsNil)
+			if mediaType == "multipart/mixed" {
+				r := multipart.NewReader(m.Body, params["boundary"])
+				for p, err := r.NextPart(); err == nil; p, err = r.NextPart() {
+					if p.FileName() != "" {
+						attachments = append(attachments, p.FileName())
+						continue
+					}
+					b, err := io.ReadAll(quotedprintable.NewReader(p))
+					c.Assert(err, qt.IsNil)
+					body = string(b)
+				}
+			} else {
+				b, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+				c.Assert(err, qt.IsNil)
+				body = string(b)
+			}
+
+			c.Assert(strings.HasPrefix(body, "FYI\r\n\r\n"), qt.IsTrue, qt.Commentf("%q", body))
+			c.Assert(strings.HasSuffix(body, "The report is attached."), qt.IsTrue, qt.Commentf("%q", body))
+			c.Assert(strings.Contains(body, "From: Alice <alice@example.com>\r\n"), qt.Equals, test.headers)
+			c.Assert(strings.Contains(body, "Forwarded message"), qt.Equals, test.headers)
+			c.Assert(attachments, qt.DeepEquals, test.attachments)
+			c.Assert(fake.callCount("GET /messages/m1/attachments/a1"), qt.Equals, len(test.attachments))
+		})
+	}
+}
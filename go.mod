@@ -5,7 +5,10 @@ go 1.20
 require (
 	github.com/frankban/quicktest v1.14.4
 	github.com/zeebo/assert v1.3.1
+	golang.org/x/net v0.8.0
 	golang.org/x/oauth2 v0.6.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/text v0.8.0
 	google.golang.org/api v0.114.0
 )
 
@@ -22,9 +25,7 @@ require (
 	github.com/kr/text v0.2.0 // indirect
 	github.com/rogpeppe/go-internal v1.9.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
-	golang.org/x/net v0.8.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/text v0.8.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20230306155012-7f2fa6fef1f4 // indirect
 	google.golang.org/grpc v1.53.0 // indirect
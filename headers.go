@@ -0,0 +1,376 @@
+package inboxer
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// GetHeaders returns the headers of the message. Lookups through the returned
+// map's Get and Values methods are case-insensitive, and repeated headers
+// (e.g. Received) keep all of their values in order.
+func GetHeaders(msg *gmail.Message) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	if msg.Payload == nil {
+		return h
+	}
+	for _, v := range msg.Payload.Headers {
+		h.Add(v.Name, v.Value)
+	}
+	return h
+}
+
+// IsAutomated reports whether the message was sent by an automated system
+// (auto-replies, vacation responders, bounces, bulk mail). Automations should
+// not reply to these, to avoid mail loops.
+func IsAutomated(msg *gmail.Message) bool {
+	h := GetHeaders(msg)
+
+	// RFC 3834: anything but "no" means the message was generated automatically.
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "list", "junk", "auto_reply":
+		return true
+	}
+
+	// Set by Exchange/Outlook on auto-generated messages.
+	return strings.TrimSpace(h.Get("X-Auto-Response-Suppress")) != ""
+}
+
+// WantsReadReceipt reports whether the sender of the message asked for a read
+// receipt (RFC 8098), returning the address to send it to from the
+// Disposition-Notification-To header. The receipt is never sent: sending it,
+// or not, is up to the user, as notifications disclose when the message was
+// read.
+func WantsReadReceipt(msg *gmail.Message) (address string, ok bool) {
+	list := parseAddressList(GetHeaders(msg).Get("Disposition-Notification-To"))
+	if len(list) == 0 || list[0].Address == "" {
+		return "", false
+	}
+	return list[0].Address, true
+}
+
+// replyPrefix matches the subject prefixes of replies: "Re:", "RE:", and the
+// "Re[2]:" form used by some clients.
+var replyPrefix = regexp.MustCompile(`(?i)^\s*re(\[\d+\])?\s*:`)
+
+// IsReply reports whether the message continues a conversation, as opposed to
+// starting a new one: it references earlier messages (In-Reply-To or
+// References headers), or its subject starts with "Re:". Forwards are not
+// replies.
+func IsReply(msg *gmail.Message) bool {
+	h := GetHeaders(msg)
+	if strings.TrimSpace(h.Get("In-Reply-To")) != "" || strings.TrimSpace(h.Get("References")) != "" {
+		return true
+	}
+	return replyPrefix.MatchString(h.Get("Subject"))
+}
+
+// parseAddress parses a single address header value such as
+// `"Bob" <bob@example.com>`. Values net/mail can't parse are kept as the bare
+// address, since real world headers are not always RFC compliant.
+func parseAddress(value string) *mail.Address {
+	if a, err := mail.ParseAddress(value); err == nil {
+		return a
+	}
+	return &mail.Address{Address: strings.Trim(strings.TrimSpace(value), "<>")}
+}
+
+// parseAddressList parses an address list header value such as To or Cc.
+func parseAddressList(value string) []*mail.Address {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	if list, err := mail.ParseAddressList(value); err == nil {
+		return list
+	}
+	var list []*mail.Address
+	for _, v := range strings.Split(value, ",") {
+		if strings.TrimSpace(v) != "" {
+			list = append(list, parseAddress(v))
+		}
+	}
+	return list
+}
+
+// sameAddress reports whether the address header value a and the address b
+// refer to the same mailbox.
+func sameAddress(a, b string) bool {
+	return strings.EqualFold(parseAddress(a).Address, parseAddress(b).Address)
+}
+
+// ReceivedHop is a server the message went through, as recorded by a
+// Received header.
+type ReceivedHop struct {
+	// From is the host the server received the message from.
+	From string
+	// By is the host that received the message.
+	By string
+	// With is the protocol used (SMTP, ESMTPS...).
+	With string
+	// Timestamp is when the message was received.
+	Timestamp time.Time
+}
+
+// GetDeliveryPath parses the Received headers of the message, returning the
+// hops in the order the message went through them (the sender's server first).
+func GetDeliveryPath(msg *gmail.Message) ([]ReceivedHop, error) {
+	received := GetHeaders(msg).Values("Received")
+	hops := make([]ReceivedHop, 0, len(received))
+	// each server prepends its header, so the newest hop comes first
+	for i := len(received) - 1; i >= 0; i-- {
+		hop, err := parseReceived(received[i])
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// parseReceived parses a Received header value (RFC 5321 4.4) such as
+// "from a.example.com (a.example.com [10.0.0.1]) by b.example.com with ESMTPS id 42; Mon, 2 Jan 2023 15:04:05 +0000".
+func parseReceived(value string) (ReceivedHop, error) {
+	hop := ReceivedHop{}
+
+	clauses := value
+	if i := strings.LastIndex(value, ";"); i >= 0 {
+		clauses = value[:i]
+		date := strings.TrimSpace(value[i+1:])
+		t, err := mail.ParseDate(date)
+		if err != nil {
+			return hop, fmt.Errorf("invalid Received date %q: %w", date, err)
+		}
+		hop.Timestamp = t
+	}
+
+	words := strings.Fields(stripComments(clauses))
+	for i := 0; i+1 < len(words); i++ {
+		switch strings.ToLower(words[i]) {
+		case "from":
+			hop.From = words[i+1]
+		case "by":
+			hop.By = words[i+1]
+		case "with":
+			hop.With = words[i+1]
+		default:
+			continue
+		}
+		i++
+	}
+	return hop, nil
+}
+
+// stripComments removes the (possibly nested) parenthesized comments of a
+// header value.
+func stripComments(value string) string {
+	var out strings.Builder
+	depth := 0
+	for _, r := range value {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// ErrNoAuthResults is returned by GetAuthResults for messages without an
+// Authentication-Results header.
+var ErrNoAuthResults = errors.New("message has no Authentication-Results header")
+
+// AuthResult is the outcome of an authentication method.
+type AuthResult struct {
+	// Result is the result of the check, e.g. "pass", "fail", "softfail",
+	// "neutral" or "none". It is empty when the method was not evaluated.
+	Result string
+	// Domain is the domain the check was evaluated for.
+	Domain string
+}
+
+// Passed reports whether the check passed.
+func (r AuthResult) Passed() bool {
+	return r.Result == "pass"
+}
+
+// AuthResults holds the SPF, DKIM and DMARC results computed by the receiving
+// server (gmail) for the message.
+type AuthResults struct {
+	// Server is the server that evaluated the message (e.g. mx.google.com).
+	Server string
+	SPF    AuthResult
+	DKIM   AuthResult
+	DMARC  AuthResult
+}
+
+// GetAuthResults parses the Authentication-Results header (RFC 8601) added by
+// gmail when it received the message. When the message has several DKIM
+// signatures, a passing one is reported if any.
+func GetAuthResults(msg *gmail.Message) (*AuthResults, error) {
+	// the receiving server adds its header on top of the others
+	value := GetHeaders(msg).Get("Authentication-Results")
+	if value == "" {
+		return nil, ErrNoAuthResults
+	}
+
+	statements := strings.Split(stripComments(value), ";")
+	res := &AuthResults{}
+	if f := strings.Fields(statements[0]); len(f) > 0 {
+		res.Server = f[0]
+	}
+	for _, st := range statements[1:] {
+		fields := strings.Fields(st)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, _ := strings.Cut(fields[0], "=")
+		props := map[string]string{}
+		for _, f := range fields[1:] {
+			if k, v, ok := strings.Cut(f, "="); ok {
+				props[strings.ToLower(k)] = strings.Trim(v, `"`)
+			}
+		}
+		r := AuthResult{Result: strings.ToLower(result)}
+
+		switch strings.ToLower(method) {
+		case "spf":
+			r.Domain = domainOf(firstNonEmpty(props["smtp.mailfrom"], props["smtp.helo"]))
+			res.SPF = r
+		case "dkim":
+			r.Domain = domainOf(firstNonEmpty(props["header.d"], props["header.i"]))
+			if res.DKIM.Result == "" || !res.DKIM.Passed() && r.Passed() {
+				res.DKIM = r
+			}
+		case "dmarc":
+			r.Domain = domainOf(props["header.from"])
+			res.DMARC = r
+		}
+	}
+	return res, nil
+}
+
+// domainOf returns the domain of an address, or s itself when it is not an
+// address.
+func domainOf(s string) string {
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.ToLower(strings.Trim(s, "<>"))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// searchOperators are the gmail search operators matching header values.
+var searchOperators = map[string]string{
+	"Message-Id": "rfc822msgid:",
+	"List-Id":    "list:",
+}
+
+// FindByHeader returns the messages having a header with the given value,
+// e.g. an X-Tracking-Id set with OutgoingMessage.Headers.
+//
+// Gmail search only knows about a few headers (Message-ID, List-Id), which are
+// searched directly. Other headers, like X- ones, can't be searched: every
+// message of the mailbox is then fetched and matched here, which is slow on
+// large mailboxes. FindByHeaderInQuery narrows the search down.
+func (s *Service) FindByHeader(name, value string) ([]*gmail.Message, error) {
+	query := ""
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	if op, ok := searchOperators[key]; ok {
+		term := value
+		if key == "List-Id" {
+			// list: only knows the identifier, not the display name
+			term = listIdentifier(value)
+		}
+		query = op + EscapeQueryTerm(term)
+	}
+	return s.FindByHeaderInQuery(query, name, value)
+}
+
+var (
+	// ErrMessageNotFound is returned (wrapped) by GetByRFC822ID when no
+	// message has the Message-ID.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrAmbiguousMessageID is returned (wrapped) by GetByRFC822ID when
+	// several messages have the Message-ID.
+	ErrAmbiguousMessageID = errors.New("several messages have the Message-ID")
+)
+
+// GetByRFC822ID returns the message whose Message-ID header is messageID,
+// with or without its angle brackets (e.g. "<abc@example.com>"), to find the
+// gmail message an external system refers to. Messages in the spam and the
+// trash are searched too.
+func (s *Service) GetByRFC822ID(messageID string) (*gmail.Message, error) {
+	id := strings.Trim(strings.TrimSpace(messageID), "<>")
+	if id == "" {
+		return nil, errors.New("empty Message-ID")
+	}
+	// rfc822msgid: doesn't match IDs written with their angle brackets
+	call := s.GmailSvc.Users.Messages.List("me").Q("rfc822msgid:" + EscapeQueryTerm(id)).IncludeSpamTrash(true)
+	ids, err := s.listIDs(call)
+	if err != nil {
+		return nil, err
+	}
+	switch len(ids) {
+	case 0:
+		return nil, fmt.Errorf("%w: <%s>", ErrMessageNotFound, id)
+	case 1:
+		return s.GetMessage(ids[0])
+	}
+	return nil, fmt.Errorf("%w: %d messages have <%s>", ErrAmbiguousMessageID, len(ids), id)
+}
+
+// FindByHeaderInQuery returns the messages matching the query that have a
+// header with the given value. Only the metadata of the messages is fetched.
+func (s *Service) FindByHeaderInQuery(query, name, value string) ([]*gmail.Message, error) {
+	var msgs []*gmail.Message
+	err := s.listPages(s.GmailSvc.Users.Messages.List("me").Q(query), func(res *gmail.ListMessagesResponse) error {
+		return s.forEachByID(res.Messages, "metadata", nil, func(msg *gmail.Message) error {
+			for _, v := range GetHeaders(msg).Values(name) {
+				if sameHeaderValue(name, v, value) {
+					msgs = append(msgs, msg)
+					break
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// sameHeaderValue reports whether two values of the header are the same.
+// Message IDs are compared with or without their angle brackets, and list
+// IDs with or without their display name.
+func sameHeaderValue(name, a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	switch textproto.CanonicalMIMEHeaderKey(name) {
+	case "Message-Id", "In-Reply-To":
+		return strings.Trim(a, "<>") == strings.Trim(b, "<>")
+	case "List-Id":
+		return listIdentifier(a) == listIdentifier(b)
+	}
+	return a == b
+}
@@ -0,0 +1,238 @@
+package inboxer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestGetHeaders(t *testing.T) {
+	c := qt.New(t)
+
+	msg := withHeaders(&gmail.Message{},
+		"received", "from a",
+		"Received", "from b",
+		"SUBJECT", "Hi",
+	)
+	h := GetHeaders(msg)
+	c.Assert(h.Get("Subject"), qt.Equals, "Hi")
+	c.Assert(h.Values("Received"), qt.DeepEquals, []string{"from a", "from b"})
+	c.Assert(GetHeaders(&gmail.Message{}), qt.HasLen, 0)
+}
+
+func TestIsAutomated(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    bool
+	}{
+		{"auto-reply", []string{"Subject", "Out of office", "auto-submitted", "auto-replied"}, true},
+		{"bulk newsletter", []string{"Subject", "Weekly digest", "Precedence", "Bulk"}, true},
+		{"outlook", []string{"Subject", "Automatic reply", "X-Auto-Response-Suppress", "All"}, true},
+		{"personal", []string{"Subject", "Lunch?", "From", "alice@example.com", "Auto-Submitted", "no"}, false},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(IsAutomated(withHeaders(&gmail.Message{}, test.headers...)), qt.Equals, test.want)
+		})
+	}
+}
+
+func TestIsReply(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    bool
+	}{
+		{"reply", []string{"Subject", "Re: Lunch?", "In-Reply-To", "<1@example.com>", "References", "<1@example.com>"}, true},
+		{"reply subject only", []string{"Subject", "RE[2]: Lunch?"}, true},
+		{"references only", []string{"Subject", "Lunch?", "References", "<1@example.com>"}, true},
+		{"forward", []string{"Subject", "Fwd: Lunch?"}, false},
+		{"fresh", []string{"Subject", "Report: Q3 numbers"}, false},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(IsReply(withHeaders(&gmail.Message{}, test.headers...)), qt.Equals, test.want)
+		})
+	}
+}
+
+func TestWantsReadReceipt(t *testing.T) {
+	c := qt.New(t)
+
+	addr, ok := WantsReadReceipt(withHeaders(&gmail.Message{}, "Disposition-Notification-To", `"Bob" <bob@example.com>`))
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(addr, qt.Equals, "bob@example.com")
+
+	_, ok = WantsReadReceipt(withHeaders(&gmail.Message{}, "Subject", "Hi"))
+	c.Assert(ok, qt.IsFalse)
+	_, ok = WantsReadReceipt(withHeaders(&gmail.Message{}, "Disposition-Notification-To", " "))
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestGetDeliveryPath(t *testing.T) {
+	c := qt.New(t)
+
+	msg := withHeaders(&gmail.Message{},
+		"Received", "by 2002:a05:6a10:ab0e:b0:3e1:4f1b:1a3a with SMTP id c14csp1234;\r\n        Mon, 2 Jan 2023 04:00:03 -0800 (PST)",
+		"Received", "from mail.example.com (mail.example.com. [203.0.113.7])\r\n        by mx.google.com with ESMTPS id x5si123.2023.01.02.04.00.02\r\n        for <bob@gmail.com>; Mon, 02 Jan 2023 04:00:02 -0800 (PST)",
+		"Subject", "Hi",
+		"Received", "from [192.168.1.20] (unknown [198.51.100.4]) by mail.example.com (Postfix) with ESMTPSA id 4F1B2; Mon,  2 Jan 2023 12:00:00 +0000",
+	)
+
+	hops, err := GetDeliveryPath(msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(hops, qt.HasLen, 3)
+
+	c.Assert(hops[0].From, qt.Equals, "[192.168.1.20]")
+	c.Assert(hops[0].By, qt.Equals, "mail.example.com")
+	c.Assert(hops[0].With, qt.Equals, "ESMTPSA")
+	c.Assert(hops[0].Timestamp.UTC(), qt.Equals, time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC))
+
+	c.Assert(hops[1].From, qt.Equals, "mail.example.com")
+	c.Assert(hops[1].By, qt.Equals, "mx.google.com")
+	c.Assert(hops[1].With, qt.Equals, "ESMTPS")
+	c.Assert(hops[1].Timestamp.UTC(), qt.Equals, time.Date(2023, 1, 2, 12, 0, 2, 0, time.UTC))
+
+	c.Assert(hops[2].From, qt.Equals, "")
+	c.Assert(hops[2].By, qt.Equals, "2002:a05:6a10:ab0e:b0:3e1:4f1b:1a3a")
+	c.Assert(hops[2].With, qt.Equals, "SMTP")
+	c.Assert(hops[2].Timestamp.Sub(hops[1].Timestamp), qt.Equals, time.Second)
+
+	_, err = GetDeliveryPath(withHeaders(&gmail.Message{}, "Received", "from a by b; yesterday"))
+	c.Assert(err, qt.ErrorMatches, `invalid Received date "yesterday".*`)
+}
+
+func TestGetAuthResults(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("pass", func(c *qt.C) {
+		msg := withHeaders(&gmail.Message{}, "Authentication-Results", `mx.google.com;
+       dkim=pass header.i=@example.com header.s=s1 header.b=Zx1y2z3;
+       spf=pass (google.com: domain of bounce@mail.example.com designates 203.0.113.7 as permitted sender) smtp.mailfrom=bounce@mail.example.com;
+       dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com`)
+		res, err := GetAuthResults(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.DeepEquals, &AuthResults{
+			Server: "mx.google.com",
+			SPF:    AuthResult{Result: "pass", Domain: "mail.example.com"},
+			DKIM:   AuthResult{Result: "pass", Domain: "example.com"},
+			DMARC:  AuthResult{Result: "pass", Domain: "example.com"},
+		})
+		c.Assert(res.DMARC.Passed(), qt.IsTrue)
+	})
+
+	c.Run("dmarc fail", func(c *qt.C) {
+		msg := withHeaders(&gmail.Message{}, "Authentication-Results", `mx.google.com;
+       dkim=fail header.d=paypa1.example header.s=k1;
+       dkim=neutral (no key) header.d=other.example;
+       spf=softfail (google.com: domain of transitioning x@paypa1.example does not designate 198.51.100.9 as permitted sender) smtp.mailfrom=x@paypa1.example;
+       dmarc=fail (p=QUARANTINE sp=QUARANTINE dis=QUARANTINE) header.from=paypal.com`)
+		res, err := GetAuthResults(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(res.SPF, qt.Equals, AuthResult{Result: "softfail", Domain: "paypa1.example"})
+		c.Assert(res.DKIM, qt.Equals, AuthResult{Result: "fail", Domain: "paypa1.example"})
+		c.Assert(res.DMARC, qt.Equals, AuthResult{Result: "fail", Domain: "paypal.com"})
+		c.Assert(res.DMARC.Passed(), qt.IsFalse)
+	})
+
+	c.Run("missing", func(c *qt.C) {
+		_, err := GetAuthResults(withHeaders(&gmail.Message{}, "Subject", "hi"))
+		c.Assert(err, qt.Equals, ErrNoAuthResults)
+	})
+}
+
+func TestFindByHeader(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	s := newFakeService(t, fake)
+	_, err := s.SendMessage(OutgoingMessage{
+		From:    "me@example.com",
+		To:      []string{"bob@example.com"},
+		Subject: "Your order",
+		Body:    "Shipped!",
+		Headers: map[string]string{"X-Tracking-Id": "order-42", "Subject": "overridden"},
+	})
+	c.Assert(err, qt.IsNil)
+	sent := sentMessage(c, fake.sent[0])
+	c.Assert(sent.Header.Get("X-Tracking-Id"), qt.Equals, "order-42")
+	c.Assert(sent.Header.Get("Subject"), qt.Equals, "Your order")
+
+	fake.messages = []*gmail.Message{
+		withHeaders(&gmail.Message{Id: "m1"}, "X-Tracking-Id", "order-41", "Message-ID", "<a@example.com>"),
+		withHeaders(&gmail.Message{Id: "m2"}, "X-Tracking-Id", "order-42", "Message-ID", "<b@example.com>"),
+		withHeaders(&gmail.Message{Id: "m3"}, "Subject", "order-42"),
+	}
+
+	c.Run("client side", func(c *qt.C) {
+		msgs, err := s.FindByHeader("x-tracking-id", "order-42")
+		c.Assert(err, qt.IsNil)
+		c.Assert(ids(msgs), qt.DeepEquals, []string{"m2"})
+		c.Assert(fake.paramsOf("GET /messages/m1")[0].Get("format"), qt.Equals, "metadata")
+	})
+
+	c.Run("searched", func(c *qt.C) {
+		fake.match = func(q string, msg *gmail.Message) bool {
+			return q == "rfc822msgid:b@example.com" && msg.Id == "m2"
+		}
+		msgs, err := s.FindByHeader("Message-ID", "b@example.com")
+		c.Assert(err, qt.IsNil)
+		c.Assert(ids(msgs), qt.DeepEquals, []string{"m2"})
+	})
+
+	c.Run("list id with a display name", func(c *qt.C) {
+		fake.messages = append(fake.messages,
+			withHeaders(&gmail.Message{Id: "m4"}, "List-Id", "Dev List <dev.example.com>"),
+			withHeaders(&gmail.Message{Id: "m5"}, "List-Id", "<ops.example.com>"),
+		)
+		fake.match = func(q string, msg *gmail.Message) bool {
+			return q == "list:dev.example.com" && msg.Id == "m4"
+		}
+		msgs, err := s.FindByHeader("List-Id", "Dev List <dev.example.com>")
+		c.Assert(err, qt.IsNil)
+		c.Assert(ids(msgs), qt.DeepEquals, []string{"m4"})
+		msgs, err = s.FindByHeader("list-id", "dev.example.com")
+		c.Assert(err, qt.IsNil)
+		c.Assert(ids(msgs), qt.DeepEquals, []string{"m4"})
+	})
+}
+
+func TestGetByRFC822ID(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{match: func(q string, msg *gmail.Message) bool {
+		return q == "rfc822msgid:"+strings.Trim(GetHeaders(msg).Get("Message-ID"), "<>")
+	}}
+	for i, id := range []string{"<CA+abc=1@mail.example.com>", "<dup@example.com>", "<dup@example.com>"} {
+		msg := withHeaders(newMessage(), "Message-ID", id)
+		msg.Id = fmt.Sprint(i)
+		fake.messages = append(fake.messages, msg)
+	}
+	s := newFakeService(t, fake)
+
+	msg, err := s.GetByRFC822ID("<CA+abc=1@mail.example.com>")
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.Id, qt.Equals, "0")
+	c.Assert(fake.queries[0].Get("q"), qt.Equals, "rfc822msgid:CA+abc=1@mail.example.com")
+	c.Assert(fake.queries[0].Get("includeSpamTrash"), qt.Equals, "true")
+
+	msg, err = s.GetByRFC822ID("CA+abc=1@mail.example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.Id, qt.Equals, "0")
+
+	_, err = s.GetByRFC822ID("<missing@example.com>")
+	c.Assert(err, qt.ErrorIs, ErrMessageNotFound)
+	c.Assert(err, qt.ErrorMatches, "message not found: <missing@example.com>")
+
+	_, err = s.GetByRFC822ID("<dup@example.com>")
+	c.Assert(err, qt.ErrorIs, ErrAmbiguousMessageID)
+}
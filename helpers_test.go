@@ -0,0 +1,91 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// newTestService returns a Service whose requests are served by h.
+func newTestService(t testing.TB, h http.Handler, opts ...Option) *Service {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	opts = append([]Option{WithEndpoint(srv.URL + "/")}, opts...)
+	s, err := newService(srv.Client(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// writeCredentials writes an OAuth client credentials file, as downloaded
+// from the Google Cloud console, and returns its path.
+func writeCredentials(c *qt.C) string {
+	path := filepath.Join(c.TempDir(), "credentials.json")
+	err := os.WriteFile(path, []byte(`{"installed": {
+	"client_id": "client-id",
+	"client_secret": "secret",
+	"auth_uri": "https://accounts.example.com/auth",
+	"token_uri": "https://accounts.example.com/token",
+	"redirect_uris": ["urn:ietf:wg:oauth:2.0:oob"]
+}}`), 0600)
+	c.Assert(err, qt.IsNil)
+	return path
+}
+
+// writeJSON writes v as the JSON body of a successful API response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// newPart returns a message part of the given mime type holding body.
+func newPart(mimeType, body string) *gmail.MessagePart {
+	return &gmail.MessagePart{
+		MimeType: mimeType,
+		Body: &gmail.MessagePartBody{
+			Data: base64.URLEncoding.EncodeToString([]byte(body)),
+			Size: int64(len(body)),
+		},
+	}
+}
+
+// newMessage returns a multipart message made of parts.
+func newMessage(parts ...*gmail.MessagePart) *gmail.Message {
+	return &gmail.Message{
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/mixed",
+			Parts:    parts,
+		},
+	}
+}
+
+// withHeaders sets the message headers from name/value pairs.
+func withHeaders(msg *gmail.Message, pairs ...string) *gmail.Message {
+	if msg.Payload == nil {
+		msg.Payload = &gmail.MessagePart{}
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		msg.Payload.Headers = append(msg.Payload.Headers, &gmail.MessagePartHeader{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return msg
+}
+
+// newAttachmentPart returns an attachment part whose data has to be fetched
+// with attachmentID.
+func newAttachmentPart(filename, mimeType, attachmentID string, size int64) *gmail.MessagePart {
+	return &gmail.MessagePart{
+		Filename: filename,
+		MimeType: mimeType,
+		Body:     &gmail.MessagePartBody{AttachmentId: attachmentID, Size: size},
+	}
+}
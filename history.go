@@ -0,0 +1,178 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// PollInbox checks the mailbox history every interval and emits each message
+// added to the INBOX since startHistoryID (e.g. gmail.Message.HistoryId or
+// gmail.Profile.HistoryId). A startHistoryID of 0 starts from the current
+// state of the mailbox. The channel is closed when ctx is cancelled.
+//
+// History records are only kept for a limited time. When startHistoryID is
+// too old the poller resyncs from the current history ID, so messages that
+// arrived during the gap are not emitted. Failed polls, including failing to
+// get one of the messages, are retried on the next tick: the messages emitted
+// before the failure may then be emitted again.
+func (s *Service) PollInbox(ctx context.Context, interval time.Duration, startHistoryID uint64) (<-chan *gmail.Message, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	if startHistoryID == 0 {
+		id, err := s.currentHistoryID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		startHistoryID = id
+	}
+
+	ch := make(chan *gmail.Message)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		historyID := startHistoryID
+		for {
+			historyID = s.pollInbox(ctx, historyID, ch)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// pollInbox emits the messages added to the INBOX since historyID and returns
+// the history ID to poll from next.
+func (s *Service) pollInbox(ctx context.Context, historyID uint64, ch chan<- *gmail.Message) uint64 {
+	ids, latest, err := s.inboxAdditions(ctx, historyID)
+	if isNotFound(err) {
+		// startHistoryId is too old, start over from now.
+		if id, err := s.currentHistoryID(ctx); err == nil {
+			return id
+		}
+		return historyID
+	}
+	if err != nil {
+		return historyID
+	}
+
+	for _, id := range ids {
+		callCtx, cancel := s.contextFrom(ctx)
+		msg, err := s.GmailSvc.Users.Messages.Get("me", id).Context(callCtx).Do()
+		cancel()
+		if isNotFound(err) {
+			// deleted since it was added
+			continue
+		}
+		if err != nil {
+			return historyID
+		}
+		select {
+		case ch <- msg:
+		case <-ctx.Done():
+			return latest
+		}
+	}
+	return latest
+}
+
+// MessagesLabeledSince returns the messages that were given the label (by
+// ID, see GetLabels) since startHistoryID, either when they arrived or later
+// on, along with the history ID to pass to the next call. Messages deleted in
+// the meantime are skipped. The API returns a 404 error (see isNotFound) when
+// startHistoryID is too old.
+func (s *Service) MessagesLabeledSince(labelID string, startHistoryID uint64) ([]*gmail.Message, uint64, error) {
+	ids, latest, err := s.labelAdditions(context.Background(), startHistoryID, labelID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var msgs []*gmail.Message
+	for _, id := range ids {
+		msg, err := s.GetMessage(id)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, latest, nil
+}
+
+// inboxAdditions returns the IDs of the messages added to the INBOX since
+// historyID, in order, along with the latest history ID.
+func (s *Service) inboxAdditions(ctx context.Context, historyID uint64) ([]string, uint64, error) {
+	return s.labelAdditions(ctx, historyID, "INBOX")
+}
+
+// labelAdditions returns the IDs of the messages given the label since
+// historyID, in order, along with the latest history ID.
+func (s *Service) labelAdditions(ctx context.Context, historyID uint64, labelID string) ([]string, uint64, error) {
+	callCtx, cancel := s.contextFrom(ctx)
+	defer cancel()
+
+	var ids []string
+	seen := map[string]bool{}
+	add := func(msg *gmail.Message, labels []string) {
+		if !seen[msg.Id] && contains(labels, labelID) {
+			seen[msg.Id] = true
+			ids = append(ids, msg.Id)
+		}
+	}
+
+	latest := historyID
+	call := s.GmailSvc.Users.History.List("me").StartHistoryId(historyID).LabelId(labelID).HistoryTypes("messageAdded", "labelAdded")
+	err := call.Pages(callCtx, func(res *gmail.ListHistoryResponse) error {
+		for _, h := range res.History {
+			for _, m := range h.MessagesAdded {
+				add(m.Message, m.Message.LabelIds)
+			}
+			for _, l := range h.LabelsAdded {
+				add(l.Message, l.LabelIds)
+			}
+		}
+		if res.HistoryId > latest {
+			latest = res.HistoryId
+		}
+		return nil
+	})
+	return ids, latest, err
+}
+
+// currentHistoryID returns the latest history ID of the mailbox.
+func (s *Service) currentHistoryID(ctx context.Context) (uint64, error) {
+	callCtx, cancel := s.contextFrom(ctx)
+	defer cancel()
+	profile, err := s.GmailSvc.Users.GetProfile("me").Context(callCtx).Do()
+	if err != nil {
+		return 0, err
+	}
+	return profile.HistoryId, nil
+}
+
+// isNotFound reports whether err is a 404 returned by the API.
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package inboxer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestPollInbox(t *testing.T) {
+	c := qt.New(t)
+
+	var starts []string
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "m1", LabelIds: []string{"INBOX", "UNREAD"}},
+		{Id: "m2", LabelIds: []string{"INBOX"}},
+		{Id: "m3", LabelIds: []string{"SENT"}},
+	}}
+	fake.handle = func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		starts = append(starts, q.Get("startHistoryId"))
+		switch {
+		case q.Get("startHistoryId") == "100" && q.Get("pageToken") == "":
+			writeJSON(w, &gmail.ListHistoryResponse{
+				History: []*gmail.History{{
+					MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "m1", LabelIds: []string{"INBOX", "UNREAD"}}}},
+				}},
+				NextPageToken: "p2",
+				HistoryId:     105,
+			})
+		case q.Get("startHistoryId") == "100":
+			writeJSON(w, &gmail.ListHistoryResponse{
+				History: []*gmail.History{{
+					LabelsAdded:   []*gmail.HistoryLabelAdded{{Message: &gmail.Message{Id: "m2"}, LabelIds: []string{"INBOX"}}},
+					MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "m3", LabelIds: []string{"SENT"}}}},
+				}},
+				HistoryId: 110,
+			})
+		default:
+			writeJSON(w, &gmail.ListHistoryResponse{HistoryId: 110})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := newFakeService(t, fake).PollInbox(ctx, 10*time.Millisecond, 100)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert((<-ch).Id, qt.Equals, "m1")
+	c.Assert((<-ch).Id, qt.Equals, "m2")
+
+	// wait for the next poll to start from the latest history ID
+	deadline := time.Now().Add(time.Second)
+	for fake.callCount("GET /history") < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	for range ch {
+		c.Fatal("unexpected message")
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	c.Assert(starts[:3], qt.DeepEquals, []string{"100", "100", "110"})
+}
+
+func TestPollInboxGetFailure(t *testing.T) {
+	c := qt.New(t)
+
+	var starts []string
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "m1", LabelIds: []string{"INBOX"}}}}
+	fake.handle = func(w http.ResponseWriter, r *http.Request) {
+		starts = append(starts, r.URL.Query().Get("startHistoryId"))
+		if r.URL.Query().Get("startHistoryId") != "100" {
+			writeJSON(w, &gmail.ListHistoryResponse{HistoryId: 110})
+			return
+		}
+		writeJSON(w, &gmail.ListHistoryResponse{
+			History: []*gmail.History{{
+				MessagesAdded: []*gmail.HistoryMessageAdded{
+					// deleted since
+					{Message: &gmail.Message{Id: "gone", LabelIds: []string{"INBOX"}}},
+					{Message: &gmail.Message{Id: "m1", LabelIds: []string{"INBOX"}}},
+				},
+			}},
+			HistoryId: 110,
+		})
+	}
+	var mu sync.Mutex
+	failed := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := !failed && strings.HasSuffix(r.URL.Path, "/messages/m1")
+		failed = failed || fail
+		mu.Unlock()
+		if fail {
+			writeError(w, http.StatusInternalServerError, "backend error")
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := newTestService(t, h).PollInbox(ctx, 10*time.Millisecond, 100)
+	c.Assert(err, qt.IsNil)
+
+	// the next poll gets the message again, from the same history ID
+	c.Assert((<-ch).Id, qt.Equals, "m1")
+	cancel()
+	for range ch {
+		c.Fatal("unexpected message")
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	c.Assert(starts[:2], qt.DeepEquals, []string{"100", "100"})
+}
+
+func TestPollInboxResync(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "m1", LabelIds: []string{"INBOX"}}}}
+	fake.handle = func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gmail/v1/users/me/profile":
+			writeJSON(w, &gmail.Profile{HistoryId: 200})
+		case r.URL.Query().Get("startHistoryId") == "1":
+			writeError(w, http.StatusNotFound, "Requested entity was not found.")
+		case r.URL.Query().Get("startHistoryId") == "200":
+			writeJSON(w, &gmail.ListHistoryResponse{
+				History: []*gmail.History{{
+					MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "m1", LabelIds: []string{"INBOX"}}}},
+				}},
+				HistoryId: 201,
+			})
+		default:
+			writeJSON(w, &gmail.ListHistoryResponse{HistoryId: 201})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := newFakeService(t, fake).PollInbox(ctx, 10*time.Millisecond, 1)
+	c.Assert(err, qt.IsNil)
+	c.Assert((<-ch).Id, qt.Equals, "m1")
+	c.Assert(fake.callCount("GET /profile"), qt.Equals, 1)
+}
+
+func TestMessagesLabeledSince(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "m1", LabelIds: []string{"INBOX", "Label_urgent"}},
+		{Id: "m2", LabelIds: []string{"INBOX", "Label_urgent"}},
+	}}
+	var query url.Values
+	fake.handle = func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		writeJSON(w, &gmail.ListHistoryResponse{
+			History: []*gmail.History{{
+				LabelsAdded: []*gmail.HistoryLabelAdded{
+					{Message: &gmail.Message{Id: "m1"}, LabelIds: []string{"Label_urgent"}},
+					{Message: &gmail.Message{Id: "m3"}, LabelIds: []string{"STARRED"}},
+				},
+			}, {
+				LabelsAdded: []*gmail.HistoryLabelAdded{
+					// deleted since
+					{Message: &gmail.Message{Id: "gone"}, LabelIds: []string{"Label_urgent"}},
+					{Message: &gmail.Message{Id: "m2"}, LabelIds: []string{"Label_urgent"}},
+				},
+			}},
+			HistoryId: 120,
+		})
+	}
+
+	msgs, next, err := newFakeService(t, fake).MessagesLabeledSince("Label_urgent", 100)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"m1", "m2"})
+	c.Assert(next, qt.Equals, uint64(120))
+	c.Assert(query.Get("labelId"), qt.Equals, "Label_urgent")
+	c.Assert(query.Get("startHistoryId"), qt.Equals, "100")
+}
@@ -0,0 +1,156 @@
+package inboxer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// imapSystemLabels maps the IMAP names of gmail's system labels, as found in
+// X-GM-LABELS, to their label IDs.
+var imapSystemLabels = map[string]string{
+	`\inbox`:     "INBOX",
+	`\sent`:      "SENT",
+	`\starred`:   "STARRED",
+	`\important`: "IMPORTANT",
+	`\draft`:     "DRAFT",
+	`\trash`:     "TRASH",
+	`\spam`:      "SPAM",
+}
+
+// IMAPMetadata is the gmail metadata kept in messages fetched through IMAP
+// (X-GM-THRID and X-GM-LABELS headers).
+type IMAPMetadata struct {
+	// ThreadID is the ID of the thread of the message in the mailbox it was
+	// fetched from, in the form used by the API.
+	ThreadID string
+	// Labels are the label names of the message. System labels are given by
+	// ID (INBOX, STARRED...).
+	Labels []string
+}
+
+// ParseIMAPMetadata reads the X-GM-THRID and X-GM-LABELS headers of a raw
+// message. Messages without them have empty metadata.
+func ParseIMAPMetadata(raw []byte) (*IMAPMetadata, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	md := &IMAPMetadata{}
+	if v := strings.TrimSpace(msg.Header.Get("X-GM-THRID")); v != "" {
+		// IMAP has the decimal form of the ID, the API the hexadecimal one
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid X-GM-THRID %q", v)
+		}
+		md.ThreadID = strconv.FormatUint(id, 16)
+	}
+
+	labels, err := ParseIMAPLabels(msg.Header.Get("X-GM-LABELS"))
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range labels {
+		if id, ok := imapSystemLabels[strings.ToLower(l)]; ok {
+			l = id
+		}
+		md.Labels = append(md.Labels, l)
+	}
+	return md, nil
+}
+
+// ParseIMAPLabels parses an X-GM-LABELS value: a space separated list of
+// labels, where labels containing spaces or quotes are quoted and escaped,
+// e.g. `\Inbox Work "Side Projects" "say \"hi\""`.
+func ParseIMAPLabels(value string) ([]string, error) {
+	var labels []string
+	for i := 0; i < len(value); {
+		switch {
+		case value[i] == ' ' || value[i] == '\t':
+			i++
+		case value[i] == '"':
+			var label strings.Builder
+			i++
+			for {
+				if i >= len(value) {
+					return nil, fmt.Errorf("unterminated label in %q", value)
+				}
+				c := value[i]
+				i++
+				if c == '"' {
+					break
+				}
+				if c == '\\' && i < len(value) {
+					c = value[i]
+					i++
+				}
+				label.WriteByte(c)
+			}
+			labels = append(labels, label.String())
+		default:
+			end := strings.IndexAny(value[i:], " \t")
+			if end < 0 {
+				end = len(value) - i
+			}
+			labels = append(labels, value[i:i+end])
+			i += end
+		}
+	}
+	return labels, nil
+}
+
+// ImportMessage imports a raw RFC 2822 message into the mailbox with the
+// given label IDs, as if it had been received at the date of its Date header.
+// Unlike sending, importing applies gmail's spam classification and filters.
+func (s *Service) ImportMessage(raw []byte, labelIDs []string) (*gmail.Message, error) {
+	return s.importMessage(&gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw), LabelIds: labelIDs})
+}
+
+func (s *Service) importMessage(msg *gmail.Message) (*gmail.Message, error) {
+	if err := s.checkWritable("import messages"); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Messages.Import("me", msg).InternalDateSource("dateHeader").Context(ctx).Do()
+}
+
+// ImportIMAPMessage imports a raw message fetched from gmail through IMAP,
+// applying the labels of its X-GM-LABELS header. The labels must exist.
+//
+// Gmail picks the thread of imported messages itself, and thread IDs from
+// another mailbox mean nothing in this one. To keep the messages of a thread
+// of the source mailbox together, pass the same threads map to every import:
+// it maps the source thread IDs (X-GM-THRID) to the threads they were
+// imported in. It can be nil.
+func (s *Service) ImportIMAPMessage(raw []byte, threads map[string]string) (*gmail.Message, error) {
+	md, err := ParseIMAPMetadata(raw)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if len(md.Labels) > 0 {
+		if ids, err = s.labelIDs(md.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	msg := &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw), LabelIds: ids}
+	if md.ThreadID != "" && threads != nil {
+		msg.ThreadId = threads[md.ThreadID]
+	}
+	imported, err := s.importMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	if md.ThreadID != "" && threads != nil {
+		threads[md.ThreadID] = imported.ThreadId
+	}
+	return imported, nil
+}
@@ -0,0 +1,77 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestParseIMAPLabels(t *testing.T) {
+	c := qt.New(t)
+
+	labels, err := ParseIMAPLabels(`\Inbox Work "Side Projects" "say \"hi\"" \Starred`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(labels, qt.DeepEquals, []string{`\Inbox`, "Work", "Side Projects", `say "hi"`, `\Starred`})
+
+	labels, err = ParseIMAPLabels("")
+	c.Assert(err, qt.IsNil)
+	c.Assert(labels, qt.HasLen, 0)
+
+	_, err = ParseIMAPLabels(`Work "Side Projects`)
+	c.Assert(err, qt.ErrorMatches, `unterminated label in .*`)
+}
+
+// imapMessage is a message as fetched from gmail through IMAP.
+const imapMessage = "X-GM-THRID: 1278455344230334865\r\n" +
+	"X-GM-LABELS: \\Inbox \\Important \"Side Projects\"\r\n" +
+	"From: alice@example.com\r\n" +
+	"Subject: hello\r\n" +
+	"\r\n" +
+	"hi!\r\n"
+
+func TestParseIMAPMetadata(t *testing.T) {
+	c := qt.New(t)
+
+	md, err := ParseIMAPMetadata([]byte(imapMessage))
+	c.Assert(err, qt.IsNil)
+	c.Assert(md, qt.DeepEquals, &IMAPMetadata{
+		ThreadID: "11bdfc5cae0c8191",
+		Labels:   []string{"INBOX", "IMPORTANT", "Side Projects"},
+	})
+
+	md, err = ParseIMAPMetadata([]byte("Subject: plain\r\n\r\nhi\r\n"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(md, qt.DeepEquals, &IMAPMetadata{})
+}
+
+func TestImportIMAPMessage(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{labels: []*gmail.Label{
+		{Id: "INBOX", Name: "INBOX"},
+		{Id: "IMPORTANT", Name: "IMPORTANT"},
+		{Id: "Label_7", Name: "Side Projects"},
+	}}
+	s := newFakeService(t, fake)
+
+	threads := map[string]string{}
+	first, err := s.ImportIMAPMessage([]byte(imapMessage), threads)
+	c.Assert(err, qt.IsNil)
+	c.Assert(first.LabelIds, qt.DeepEquals, []string{"INBOX", "IMPORTANT", "Label_7"})
+	c.Assert(threads, qt.DeepEquals, map[string]string{"11bdfc5cae0c8191": first.ThreadId})
+
+	raw, err := base64.URLEncoding.DecodeString(fake.imported[0].Raw)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(raw), qt.Equals, imapMessage)
+	c.Assert(fake.paramsOf("POST /messages/import")[0].Get("internalDateSource"), qt.Equals, "dateHeader")
+
+	// messages of the same source thread are imported in the same thread
+	second, err := s.ImportIMAPMessage([]byte(imapMessage), threads)
+	c.Assert(err, qt.IsNil)
+	c.Assert(second.ThreadId, qt.Equals, first.ThreadId)
+
+	_, err = s.ImportIMAPMessage([]byte("X-GM-LABELS: Unknown\r\n\r\nhi\r\n"), nil)
+	c.Assert(err, qt.ErrorMatches, `unknown label "Unknown"`)
+}
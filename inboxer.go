@@ -23,55 +23,301 @@
 package inboxer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
 )
 
 type Service struct {
 	GmailSvc *gmail.Service
+
+	// Timeout bounds each API call made by the Service. Zero means no timeout.
+	Timeout time.Duration
+
+	// Progress, when set, is called as long running operations (MarkAllAsRead,
+	// ForEachMessage, ExportQueryToMbox) advance. total is the API's estimate
+	// of how many messages will be processed, and may change as it goes.
+	Progress func(done, total int)
+
+	// DedupeWindow is how long SendMessage remembers the Message-IDs it sent,
+	// to skip retried sends of the same message. Zero disables it.
+	DedupeWindow time.Duration
+
+	// MaxRecipients, when set, makes SendMessage and Forward refuse messages
+	// with more recipients (To, Cc and Bcc) with ErrTooManyRecipients, to
+	// catch mass mailings made by mistake. Messages can override it, see
+	// OutgoingMessage.AllowManyRecipients.
+	MaxRecipients int
+
+	// MaxBodyBytes is the size above which attachments are neither downloaded
+	// nor decoded, ErrBodyTooLarge being returned instead, so huge parts of
+	// untrusted mail can't exhaust the memory. Zero means DefaultMaxBodyBytes,
+	// and a negative value disables the limit.
+	MaxBodyBytes int64
+
+	// Limiter, when set, rate limits the requests made by the Service.
+	Limiter Limiter
+
+	// ReadOnly makes every request changing the mailbox or its settings
+	// (modifying, sending or deleting messages, editing labels or settings,
+	// revoking the token...) fail with ErrReadOnlyMode without reaching the
+	// API, whatever the token allows, to safely run automation against a
+	// production mailbox. The requests made directly through GmailSvc are
+	// only refused when the Service was built by NewGmailService or
+	// NewGmailServiceWithOptions, not for a Service{GmailSvc: ...} literal.
+	ReadOnly bool
+
+	// DryRun makes the bulk operations (ApplyToQuery, ArchiveOlderThan,
+	// RemoveLabelEverywhere) report how many messages they would change
+	// without changing anything.
+	DryRun bool
+
+	// DefaultFormat is the format messages are fetched in ("full", "metadata"
+	// or "minimal") by the methods that don't need a specific one, such as
+	// GetMessage, GetMessages or ForEachMessage. Empty means the API default,
+	// full. List heavy applications can set it to metadata to get smaller
+	// responses; GetMessageWithFormat overrides it.
+	DefaultFormat string
+
+	// CacheSize, when set, keeps up to that many messages got by GetMessage
+	// (and the methods using it, like MessagesByID) in memory, the least
+	// recently used ones being evicted first, so getting them again doesn't
+	// call the API. The methods of the Service changing messages remove them
+	// from the cache; see ForgetMessages for changes made otherwise.
+	CacheSize int
+
+	sent       sendCache
+	scheduled  scheduledSends
+	signatures signatureCache
+	cache      messageCache
+	tokens     *tokenSource
+	gets       singleflight.Group
+	quota      atomic.Int64
 }
 
 // NewGmailService retrieves a service based on the configuration files and permission scopes.
 func NewGmailService(credentialsFilePath string, scopes ...string) (*Service, error) {
-	srv, err := GetGmailServiceFromFile(credentialsFilePath, scopes...)
+	return NewGmailServiceWithOptions(credentialsFilePath, scopes)
+}
+
+// NewGmailServiceWithOptions works like NewGmailService, but lets you customize
+// the underlying gmail.Service (e.g. WithUserAgent).
+func NewGmailServiceWithOptions(credentialsFilePath string, scopes []string, opts ...Option) (*Service, error) {
+	o := &serviceOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	ts, err := tokenSourceFromFile(credentialsFilePath, o.tokenStore, scopes...)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newService(oauth2.NewClient(context.Background(), ts), opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.tokens = ts
+	return s, nil
+}
+
+// newService builds a Service on top of an already authorized http.Client.
+func newService(client *http.Client, opts ...Option) (*Service, error) {
+	o := &serviceOptions{userAgent: DefaultUserAgent}
+	for _, opt := range opts {
+		opt(o)
+	}
 
+	s := &Service{}
+	client = s.instrument(client)
+	srv, err := gmail.NewService(context.Background(), append([]option.ClientOption{option.WithHTTPClient(client)}, o.client...)...)
 	if err != nil {
 		return nil, err
 	}
-	return &Service{srv}, nil
+	// option.WithUserAgent is ignored when a custom http.Client is supplied,
+	// so the fragment is set on the service itself.
+	srv.UserAgent = o.userAgent
+	s.GmailSvc = srv
+	return s, nil
+}
+
+// context returns the context an API call is made with, bounded by s.Timeout
+// when set. The returned cancel function must always be called.
+func (s *Service) context() (context.Context, context.CancelFunc) {
+	return s.contextFrom(context.Background())
+}
+
+// maxBodyBytes returns the size limit of the parts decoded by the Service, or
+// 0 for no limit.
+func (s *Service) maxBodyBytes() int64 {
+	switch {
+	case s.MaxBodyBytes == 0:
+		return DefaultMaxBodyBytes
+	case s.MaxBodyBytes < 0:
+		return 0
+	}
+	return s.MaxBodyBytes
+}
+
+// format returns the format to fetch messages in: the given one, or else
+// DefaultFormat.
+func (s *Service) format(format string) string {
+	if format == "" {
+		return s.DefaultFormat
+	}
+	return format
+}
+
+// progress reports the progress of a long running operation.
+func (s *Service) progress(done, total int) {
+	if s.Progress == nil {
+		return
+	}
+	if total < done {
+		total = done
+	}
+	s.Progress(done, total)
+}
+
+// call runs fn with a context bounded by s.Timeout, for operations made of
+// several API calls.
+func (s *Service) call(fn func(ctx context.Context) error) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return fn(ctx)
+}
+
+// contextFrom works like context, but derives the context from parent.
+func (s *Service) contextFrom(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.Timeout > 0 {
+		return context.WithTimeout(parent, s.Timeout)
+	}
+	return context.WithCancel(parent)
 }
 
 // MarkAs allows you to mark an email with a specific label using the gmail.ModifyMessageRequest struct.
+// It fails with ErrInsufficientScope when the token doesn't allow modifying
+// messages (e.g. a gmail.readonly one).
 func (s *Service) MarkAs(msgId string, req *gmail.ModifyMessageRequest) (*gmail.Message, error) {
-	return s.GmailSvc.Users.Messages.Modify("me", msgId, req).Do()
+	if err := s.checkWritable("modify messages"); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	msg, err := s.GmailSvc.Users.Messages.Modify("me", msgId, req).Context(ctx).Do()
+	s.cache.forget(msgId)
+	if isInsufficientScope(err) {
+		return nil, fmt.Errorf("%w: %w", ErrInsufficientScope, err)
+	}
+	return msg, err
 }
 
-// MarkAllAsRead removes the UNREAD label from all emails.
+// MarkAllAsRead removes the UNREAD label from all emails. The messages are
+// changed by batches of up to 1000, batchConcurrency of them at once (within
+// the limits of s.Limiter). A failed batch doesn't stop the others: the
+// errors of every failed batch are returned together, the other messages
+// being marked as read.
 func (s *Service) MarkAllAsRead() error {
-	// Request to remove the label ID "UNREAD"
-	req := &gmail.ModifyMessageRequest{
-		RemoveLabelIds: []string{"UNREAD"},
+	ids, err := s.listIDs(s.GmailSvc.Users.Messages.List("me").Q("label:UNREAD"))
+	if err != nil {
+		return err
 	}
 
-	// Get the messages labeled "UNREAD"
-	msgs, err := s.Query("label:UNREAD")
+	var mu sync.Mutex
+	var errs []error
+	done := 0
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for _, chunk := range chunks(ids, batchModifyLimit) {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(chunk []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := s.modifyChunk(chunk, nil, []string{"UNREAD"})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("marking %d messages as read: %w", len(chunk), err))
+				return
+			}
+			done += len(chunk)
+			s.progress(done, len(ids))
+		}(chunk)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// MarkAllAsReadResumable works like MarkAllAsRead, one page of messages at a
+// time, so that an interrupted run can carry on where it stopped. Start with an
+// empty cursor, then pass the returned cursor to the next call until done is
+// true. On error the returned cursor is the one given, so retrying the call
+// resumes at the page that failed.
+//
+// Marked messages leave the unread ones, so every call marks the first page of
+// what is still unread rather than following page tokens, which would skip
+// messages. The cursor only counts the messages marked so far.
+func (s *Service) MarkAllAsReadResumable(cursor string) (newCursor string, done bool, err error) {
+	marked, _ := strconv.Atoi(cursor)
+	ctx, cancel := s.context()
+	res, err := s.GmailSvc.Users.Messages.List("me").Q("label:UNREAD").Context(ctx).Do()
+	cancel()
 	if err != nil {
-		return err
+		return cursor, false, err
 	}
 
-	// For each UNREAD message, request to remove the "UNREAD" label (thus marking it as "READ").
-	for _, msg := range msgs {
-		if _, err = s.MarkAs(msg.Id, req); err != nil {
-			return err
+	if len(res.Messages) > 0 {
+		ids := make([]string, len(res.Messages))
+		for i, msg := range res.Messages {
+			ids[i] = msg.Id
 		}
+		if err := s.modifyChunk(ids, nil, []string{"UNREAD"}); err != nil {
+			return cursor, false, err
+		}
+		marked += len(ids)
+		s.progress(marked, marked+int(res.ResultSizeEstimate)-len(ids))
 	}
+	return strconv.Itoa(marked), res.NextPageToken == "", nil
+}
 
-	return nil
+// MarkThreadAsRead removes the UNREAD label from every message of the thread
+// in a single request, which is what a mail client does when a conversation
+// is opened.
+func (s *Service) MarkThreadAsRead(threadID string) error {
+	if err := s.checkWritable("modify messages"); err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	thread, err := s.GmailSvc.Users.Threads.Get("me", threadID).Format("minimal").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	req := &gmail.BatchModifyMessagesRequest{RemoveLabelIds: []string{"UNREAD"}}
+	for _, msg := range thread.Messages {
+		req.Ids = append(req.Ids, msg.Id)
+	}
+	defer s.cache.forget(req.Ids...)
+	return s.GmailSvc.Users.Messages.BatchModify("me", req).Context(ctx).Do()
 }
 
 // Query queries the inbox for a string following the search style of the gmail online mailbox.
 // example: "in:sent after:2017/01/01 before:2017/01/30"
 func (s *Service) Query(query string) ([]*gmail.Message, error) {
-	inbox, err := s.GmailSvc.Users.Messages.List("me").Q(query).Do()
+	ctx, cancel := s.context()
+	defer cancel()
+	inbox, err := s.GmailSvc.Users.Messages.List("me").Q(query).Context(ctx).Do()
 	if err != nil {
 		return []*gmail.Message{}, err
 	}
@@ -82,13 +328,177 @@ func (s *Service) Query(query string) ([]*gmail.Message, error) {
 	return msgs, nil
 }
 
+// MessagesNewerThan returns the howMany messages received right after the
+// anchor message, newest first like every list, to load what came after the
+// messages already shown. A howMany of 0 returns every newer message. The
+// anchor must have been retrieved (see GetMessage) for its internal date to be
+// known.
+func (s *Service) MessagesNewerThan(anchor *gmail.Message, howMany uint) ([]*gmail.Message, error) {
+	if anchor.InternalDate == 0 {
+		return nil, errors.New("anchor message has no internal date")
+	}
+	received, err := ReceivedTime(anchor.InternalDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// after: only has a precision of a second, messages are filtered on their
+	// internal date below.
+	ids, err := s.listIDs(s.GmailSvc.Users.Messages.List("me").Q(fmt.Sprintf("after:%d", received.Unix())))
+	if err != nil {
+		return nil, err
+	}
+
+	// the oldest messages come last
+	var msgs []*gmail.Message
+	for i := len(ids) - 1; i >= 0 && (howMany == 0 || uint(len(msgs)) < howMany); i-- {
+		msg, err := s.GetMessage(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		if msg.Id != anchor.Id && msg.InternalDate > anchor.InternalDate {
+			msgs = append(msgs, msg)
+		}
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// fetchConcurrency is how many messages are fetched at once by ForEachMessage.
+const fetchConcurrency = 10
+
+// ForEachMessage calls fn with every message matching the query, in order, as
+// soon as it is retrieved, so the first messages can be used before the last
+// ones are loaded. Messages are fetched concurrently. It stops at the first
+// error, including errors returned by fn.
+func (s *Service) ForEachMessage(query string, fn func(*gmail.Message) error) error {
+	return s.forEachMessage(s.GmailSvc.Users.Messages.List("me").Q(query), "", 0, fn)
+}
+
+// forEachMessage calls fn with every message listed by the call, or the first
+// max ones when max isn't 0, fetched in the given format (see forEachByID).
+func (s *Service) forEachMessage(call *gmail.UsersMessagesListCall, format string, max uint, fn func(*gmail.Message) error) error {
+	done, total := 0, 0
+	return s.listPages(call, func(res *gmail.ListMessagesResponse) error {
+		if total == 0 {
+			total = int(res.ResultSizeEstimate)
+		}
+		msgs := res.Messages
+		if max > 0 && uint(done+len(msgs)) >= max {
+			msgs = msgs[:max-uint(done)]
+			res.NextPageToken = ""
+			total = int(max)
+		}
+		return s.forEachByID(msgs, format, nil, func(msg *gmail.Message) error {
+			if err := fn(msg); err != nil {
+				return err
+			}
+			done++
+			s.progress(done, total)
+			return nil
+		})
+	})
+}
+
+// forEachByID fetches the messages concurrently, in the given format (empty
+// means DefaultFormat) and with only the given headers in the metadata
+// format, and calls fn with each of them in order.
+func (s *Service) forEachByID(msgs []*gmail.Message, format string, headers []string, fn func(*gmail.Message) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	format = s.format(format)
+
+	type result struct {
+		msg *gmail.Message
+		err error
+	}
+	results := make([]chan result, len(msgs))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	go func() {
+		sem := make(chan struct{}, fetchConcurrency)
+		for i, m := range msgs {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int, id string) {
+				defer func() { <-sem }()
+				callCtx, callCancel := s.contextFrom(ctx)
+				defer callCancel()
+				call := s.GmailSvc.Users.Messages.Get("me", id)
+				if format != "" {
+					call.Format(format)
+				}
+				if len(headers) > 0 {
+					call.MetadataHeaders(headers...)
+				}
+				msg, err := call.Context(callCtx).Do()
+				results[i] <- result{msg, err}
+			}(i, m.Id)
+		}
+	}()
+
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil {
+			return r.err
+		}
+		if err := fn(r.msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listPages calls fn with every page of results of the list call, each page
+// being fetched with its own context.
+func (s *Service) listPages(call *gmail.UsersMessagesListCall, fn func(*gmail.ListMessagesResponse) error) error {
+	token := ""
+	for {
+		ctx, cancel := s.context()
+		res, err := call.PageToken(token).Context(ctx).Do()
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+		if res.NextPageToken == "" {
+			return nil
+		}
+		token = res.NextPageToken
+	}
+}
+
 // MessagesByID gets a group of messages by their ids ID. This is necessary because this is how the gmail API is set [0][1] up apparently (but why?).
 // [0] https://developers.google.com/gmail/api/v1/reference/users/messages/get
 // [1] https://stackoverflow.com/questions/36365172/message-payload-is-always-null-for-all-messages-how-do-i-get-this-data
 func (s *Service) MessagesByID(msgs *gmail.ListMessagesResponse) ([]*gmail.Message, error) {
+	return s.messagesByID(msgs, "")
+}
+
+// MessagesByIDMetadata works like MessagesByID, but only gets the metadata of
+// the messages (labels, snippet) along with the given headers, e.g. "From" for
+// an operation that only looks at senders. Responses are much smaller than
+// full messages. Without headers, every header is returned.
+func (s *Service) MessagesByIDMetadata(msgs *gmail.ListMessagesResponse, headers ...string) ([]*gmail.Message, error) {
+	return s.messagesByID(msgs, "metadata", headers...)
+}
+
+// messagesByID works like MessagesByID, getting the messages in the given
+// format ("full", "metadata", "minimal" or "raw"; empty means DefaultFormat).
+// headers restricts the headers returned in the metadata format.
+func (s *Service) messagesByID(msgs *gmail.ListMessagesResponse, format string, headers ...string) ([]*gmail.Message, error) {
 	var msgSlice []*gmail.Message
 	for _, v := range msgs.Messages {
-		msg, err := s.GmailSvc.Users.Messages.Get("me", v.Id).Do()
+		msg, err := s.getMessage(v.Id, format, headers...)
 		if err != nil {
 			return msgSlice, err
 		}
@@ -97,22 +507,94 @@ func (s *Service) MessagesByID(msgs *gmail.ListMessagesResponse) ([]*gmail.Messa
 	return msgSlice, nil
 }
 
-// GetMessage retrieves a message by its ID
+// MessagesByIDPartial works like MessagesByID, but doesn't stop at the first
+// message it fails to get (e.g. one deleted since it was listed): it returns
+// the messages it could get, in order, along with the errors of the others by
+// message ID. The map is nil when every message was retrieved.
+func (s *Service) MessagesByIDPartial(msgs *gmail.ListMessagesResponse) ([]*gmail.Message, map[string]error) {
+	var msgSlice []*gmail.Message
+	var errs map[string]error
+	for _, v := range msgs.Messages {
+		msg, err := s.GetMessage(v.Id)
+		if err != nil {
+			if errs == nil {
+				errs = map[string]error{}
+			}
+			errs[v.Id] = err
+			continue
+		}
+		msgSlice = append(msgSlice, msg)
+	}
+	return msgSlice, errs
+}
+
+// GetMessage retrieves a message by its ID, in DefaultFormat. Goroutines
+// getting the same message at the same time get it through a single API call,
+// and share the returned message: it must not be modified.
 func (s *Service) GetMessage(msgId string) (*gmail.Message, error) {
-	return s.GmailSvc.Users.Messages.Get("me", msgId).Do()
+	return s.getMessage(msgId, "")
+}
+
+// GetMessageWithFormat works like GetMessage, but gets the message in the
+// given format ("full", "metadata", "minimal" or "raw") whatever
+// DefaultFormat is.
+func (s *Service) GetMessageWithFormat(msgId, format string) (*gmail.Message, error) {
+	return s.getMessage(msgId, format)
+}
+
+// getMessage retrieves a message in the given format (empty means
+// DefaultFormat), with only the given headers in the metadata format.
+// Concurrent requests for the same message share a single API call, and the
+// same *gmail.Message.
+func (s *Service) getMessage(msgId, format string, headers ...string) (*gmail.Message, error) {
+	format = s.format(format)
+	variant := format + "/" + strings.Join(headers, ",")
+	if s.CacheSize > 0 {
+		if msg, ok := s.cache.get(msgId, variant); ok {
+			return msg, nil
+		}
+	}
+	msg, err, _ := s.gets.Do(variant+"/"+msgId, func() (interface{}, error) {
+		ctx, cancel := s.context()
+		defer cancel()
+		call := s.GmailSvc.Users.Messages.Get("me", msgId)
+		if format != "" {
+			call.Format(format)
+		}
+		if len(headers) > 0 {
+			call.MetadataHeaders(headers...)
+		}
+		if s.CacheSize == 0 {
+			return call.Context(ctx).Do()
+		}
+		generation := s.cache.fetching(msgId)
+		msg, err := call.Context(ctx).Do()
+		s.cache.fetched(msgId, generation, variant, msg, s.CacheSize)
+		return msg, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*gmail.Message), nil
 }
 
 // GetAttachment returns and attachment by its ID
 func (s *Service) GetAttachment(msgId, attachmentId string) (*gmail.MessagePartBody, error) {
-	return s.GmailSvc.Users.Messages.Attachments.Get("me", msgId, attachmentId).Do()
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Messages.Attachments.Get("me", msgId, attachmentId).Context(ctx).Do()
 }
 
-// GetMessages gets and returns gmail messages
+// maxPageSize is the largest page the API returns for a list call.
+const maxPageSize = 500
+
+// GetMessages gets and returns gmail messages. The API returns at most 500
+// messages per call, so larger requests are fetched over several pages.
 func (s *Service) GetMessages(howMany uint) ([]*gmail.Message, error) {
 	var msgSlice []*gmail.Message
 
 	// Get the messages
-	inbox, err := s.GmailSvc.Users.Messages.List("me").MaxResults(int64(howMany)).Do()
+	inbox, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me"), howMany)
 	if err != nil {
 		return msgSlice, err
 	}
@@ -124,6 +606,60 @@ func (s *Service) GetMessages(howMany uint) ([]*gmail.Message, error) {
 	return msgs, nil
 }
 
+// GetMessagesMetadata works like GetMessages, but only gets the metadata of
+// the messages along with the given headers (see MessagesByIDMetadata).
+func (s *Service) GetMessagesMetadata(howMany uint, headers ...string) ([]*gmail.Message, error) {
+	inbox, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me"), howMany)
+	if err != nil {
+		return nil, err
+	}
+	return s.MessagesByIDMetadata(inbox, headers...)
+}
+
+// GetUnreadMessages gets up to howMany unread messages. Only their metadata
+// (labels, headers, snippet) is fetched, which is faster than getting full
+// messages; use GetMessage to get the body of one of them.
+func (s *Service) GetUnreadMessages(howMany uint) ([]*gmail.Message, error) {
+	inbox, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me").LabelIds("UNREAD"), howMany)
+	if err != nil {
+		return nil, err
+	}
+	return s.messagesByID(inbox, "metadata")
+}
+
+// listUpTo lists up to howMany messages, requesting as many pages as needed.
+// A howMany of 0 lists a single page of the API's default size.
+func (s *Service) listUpTo(call *gmail.UsersMessagesListCall, howMany uint) (*gmail.ListMessagesResponse, error) {
+	if howMany == 0 {
+		ctx, cancel := s.context()
+		defer cancel()
+		return call.Context(ctx).Do()
+	}
+
+	all := &gmail.ListMessagesResponse{}
+	token := ""
+	for uint(len(all.Messages)) < howMany {
+		size := howMany - uint(len(all.Messages))
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+
+		ctx, cancel := s.context()
+		res, err := call.MaxResults(int64(size)).PageToken(token).Context(ctx).Do()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		all.Messages = append(all.Messages, res.Messages...)
+		all.ResultSizeEstimate = res.ResultSizeEstimate
+		if res.NextPageToken == "" {
+			break
+		}
+		token = res.NextPageToken
+	}
+	return all, nil
+}
+
 // CheckForUnread checks for mail labeled "UNREAD".
 // NOTE: When checking your inbox for unread messages, it's not uncommon for
 // it to return thousands of unread messages that you don't know about. To see
@@ -131,7 +667,9 @@ func (s *Service) GetMessages(howMany uint) ([]*gmail.Message, error) {
 // work properly you need to mark all mail as read either through gmail or
 // through the MarkAllAsRead() function found in this library.
 func (s *Service) CheckForUnread() (int64, error) {
-	inbox, err := s.GmailSvc.Users.Labels.Get("me", "UNREAD").Do()
+	ctx, cancel := s.context()
+	defer cancel()
+	inbox, err := s.GmailSvc.Users.Labels.Get("me", "UNREAD").Context(ctx).Do()
 	if err != nil {
 		return -1, err
 	}
@@ -145,5 +683,7 @@ func (s *Service) CheckForUnread() (int64, error) {
 
 // GetLabels gets a list of the labels used in the users inbox.
 func (s *Service) GetLabels() (*gmail.ListLabelsResponse, error) {
-	return s.GmailSvc.Users.Labels.List("me").Do()
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Labels.List("me").Context(ctx).Do()
 }
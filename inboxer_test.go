@@ -1,10 +1,18 @@
 package inboxer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
 	qt "github.com/frankban/quicktest"
 	"github.com/zeebo/assert"
 	"google.golang.org/api/gmail/v1"
-	"testing"
 )
 
 // run cmd/main.go first, because we need to go to browser
@@ -21,3 +29,468 @@ func TestInboxer(t *testing.T) {
 	})
 
 }
+
+func TestTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		writeJSON(w, &gmail.Message{Id: "1"})
+	})
+
+	c.Run("exceeded", func(c *qt.C) {
+		service := newTestService(t, slow)
+		service.Timeout = 20 * time.Millisecond
+		_, err := service.GetMessage("1")
+		c.Assert(errors.Is(err, context.DeadlineExceeded), qt.IsTrue, qt.Commentf("got %v", err))
+	})
+
+	c.Run("no timeout", func(c *qt.C) {
+		service := newTestService(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, &gmail.Message{Id: "1"})
+		}))
+		msg, err := service.GetMessage("1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(msg.Id, qt.Equals, "1")
+	})
+}
+
+func TestMarkThreadAsRead(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "1", ThreadId: "t1", LabelIds: []string{"INBOX", "UNREAD"}},
+		{Id: "2", ThreadId: "t2", LabelIds: []string{"INBOX", "UNREAD"}},
+		{Id: "3", ThreadId: "t1", LabelIds: []string{"UNREAD"}},
+		{Id: "4", ThreadId: "t1", LabelIds: []string{"SENT"}},
+	}}
+	err := newFakeService(t, fake).MarkThreadAsRead("t1")
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(fake.batches, qt.HasLen, 1)
+	c.Assert(fake.batches[0].Ids, qt.DeepEquals, []string{"1", "3", "4"})
+	c.Assert(fake.batches[0].RemoveLabelIds, qt.DeepEquals, []string{"UNREAD"})
+	c.Assert(fake.messages[1].LabelIds, qt.DeepEquals, []string{"INBOX", "UNREAD"})
+}
+
+func TestForEachMessage(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	for i := 0; i < 25; i++ {
+		fake.messages = append(fake.messages, &gmail.Message{Id: fmt.Sprint(i)})
+	}
+
+	c.Run("all", func(c *qt.C) {
+		var ids []string
+		err := newFakeService(t, fake).ForEachMessage("in:inbox", func(msg *gmail.Message) error {
+			ids = append(ids, msg.Id)
+			return nil
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(ids, qt.HasLen, 25)
+		for i, id := range ids {
+			c.Assert(id, qt.Equals, fmt.Sprint(i))
+		}
+	})
+
+	c.Run("early exit", func(c *qt.C) {
+		stop := errors.New("stop")
+		var ids []string
+		err := newFakeService(t, fake).ForEachMessage("in:inbox", func(msg *gmail.Message) error {
+			ids = append(ids, msg.Id)
+			if len(ids) == 3 {
+				return stop
+			}
+			return nil
+		})
+		c.Assert(err, qt.Equals, stop)
+		c.Assert(ids, qt.DeepEquals, []string{"0", "1", "2"})
+	})
+
+	c.Run("fetch error", func(c *qt.C) {
+		f := &fakeGmail{messages: fake.messages, fail: map[string]int{"/messages/1": http.StatusInternalServerError}}
+		var ids []string
+		err := newFakeService(t, f).ForEachMessage("in:inbox", func(msg *gmail.Message) error {
+			ids = append(ids, msg.Id)
+			return nil
+		})
+		c.Assert(err, qt.ErrorMatches, ".*failed by fake.*")
+		c.Assert(ids, qt.DeepEquals, []string{"0"})
+	})
+}
+
+func TestProgress(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{match: func(q string, msg *gmail.Message) bool {
+		return q == "label:UNREAD" && hasLabels(msg, []string{"UNREAD"})
+	}}
+	for i := 0; i < 5; i++ {
+		labels := []string{"INBOX"}
+		if i != 2 {
+			labels = append(labels, "UNREAD")
+		}
+		fake.messages = append(fake.messages, &gmail.Message{Id: fmt.Sprint(i), LabelIds: labels})
+	}
+
+	// one message per batch, for the progress to be reported per message
+	c.Patch(&batchModifyLimit, 1)
+	var calls [][2]int
+	service := newFakeService(t, fake)
+	service.Progress = func(done, total int) {
+		calls = append(calls, [2]int{done, total})
+	}
+
+	err := service.MarkAllAsRead()
+	c.Assert(err, qt.IsNil)
+	c.Assert(calls, qt.DeepEquals, [][2]int{{1, 4}, {2, 4}, {3, 4}, {4, 4}})
+	for _, msg := range fake.messages {
+		c.Assert(hasLabels(msg, []string{"UNREAD"}), qt.IsFalse)
+	}
+
+	// nil callbacks are fine
+	service.Progress = nil
+	calls = nil
+	err = service.ForEachMessage("", func(*gmail.Message) error { return nil })
+	c.Assert(err, qt.IsNil)
+	c.Assert(calls, qt.HasLen, 0)
+}
+
+// unreadMailbox returns a mailbox of n unread messages, served with a delay
+// to make concurrent requests overlap.
+func unreadMailbox(n int) *fakeGmail {
+	fake := &fakeGmail{match: func(q string, msg *gmail.Message) bool {
+		return q == "label:UNREAD" && hasLabels(msg, []string{"UNREAD"})
+	}}
+	for i := 0; i < n; i++ {
+		fake.messages = append(fake.messages, &gmail.Message{Id: fmt.Sprint(i), LabelIds: []string{"INBOX", "UNREAD"}})
+	}
+	return fake
+}
+
+// slowBatches serves requests with f, BatchModify ones taking delay, the
+// nth of them failing. It records how many were in flight at most.
+type slowBatches struct {
+	f     *fakeGmail
+	delay time.Duration
+	fail  int
+
+	mu                   sync.Mutex
+	count, inFlight, max int
+}
+
+func (h *slowBatches) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/messages/batchModify") {
+		h.f.ServeHTTP(w, r)
+		return
+	}
+	h.mu.Lock()
+	h.count++
+	n := h.count
+	h.inFlight++
+	if h.inFlight > h.max {
+		h.max = h.inFlight
+	}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.inFlight--
+		h.mu.Unlock()
+	}()
+
+	time.Sleep(h.delay)
+	if n == h.fail {
+		writeError(w, http.StatusInternalServerError, "backend error")
+		return
+	}
+	h.f.ServeHTTP(w, r)
+}
+
+func TestMarkAllAsReadConcurrency(t *testing.T) {
+	c := qt.New(t)
+	c.Patch(&batchModifyLimit, 2)
+	c.Patch(&batchConcurrency, 3)
+
+	c.Run("bounded", func(c *qt.C) {
+		fake := unreadMailbox(20)
+		h := &slowBatches{f: fake, delay: 20 * time.Millisecond}
+		c.Assert(newTestService(c.TB, h).MarkAllAsRead(), qt.IsNil)
+		c.Assert(fake.batches, qt.HasLen, 10)
+		c.Assert(h.max, qt.Equals, 3)
+		for _, msg := range fake.messages {
+			c.Assert(hasLabels(msg, []string{"UNREAD"}), qt.IsFalse)
+		}
+	})
+
+	c.Run("failures are aggregated", func(c *qt.C) {
+		fake := unreadMailbox(20)
+		h := &slowBatches{f: fake, delay: time.Millisecond, fail: 2}
+		err := newTestService(c.TB, h).MarkAllAsRead()
+		c.Assert(err, qt.ErrorMatches, `(?s)marking 2 messages as read: .*backend error.*`)
+		c.Assert(fake.batches, qt.HasLen, 9)
+		unread := 0
+		for _, msg := range fake.messages {
+			if hasLabels(msg, []string{"UNREAD"}) {
+				unread++
+			}
+		}
+		c.Assert(unread, qt.Equals, 2)
+	})
+}
+
+func BenchmarkMarkAllAsRead(b *testing.B) {
+	defer func(limit, concurrency int) {
+		batchModifyLimit, batchConcurrency = limit, concurrency
+	}(batchModifyLimit, batchConcurrency)
+	batchModifyLimit = 50
+
+	for _, concurrency := range []int{1, 4} {
+		b.Run(fmt.Sprintf("concurrency %d", concurrency), func(b *testing.B) {
+			batchConcurrency = concurrency
+			fake := unreadMailbox(500)
+			s := newTestService(b, &slowBatches{f: fake, delay: 2 * time.Millisecond})
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for _, msg := range fake.messages {
+					msg.LabelIds = []string{"INBOX", "UNREAD"}
+				}
+				b.StartTimer()
+				if err := s.MarkAllAsRead(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkAllAsReadResumable(t *testing.T) {
+	c := qt.New(t)
+
+	fake := unreadMailbox(5)
+	fake.pageSize = 2
+	unread := func() (n int) {
+		for _, msg := range fake.messages {
+			if hasLabels(msg, []string{"UNREAD"}) {
+				n++
+			}
+		}
+		return n
+	}
+
+	cursor, done, err := newFakeService(t, fake).MarkAllAsReadResumable("")
+	c.Assert(err, qt.IsNil)
+	c.Assert(done, qt.IsFalse)
+	c.Assert(unread(), qt.Equals, 3)
+
+	// a new Service, as after a crash, resumes from the cursor
+	s := newFakeService(t, fake)
+	cursor, done, err = s.MarkAllAsReadResumable(cursor)
+	c.Assert(err, qt.IsNil)
+	c.Assert(done, qt.IsFalse)
+	c.Assert(unread(), qt.Equals, 1)
+
+	// a failure leaves the cursor as it was
+	fake.fail = map[string]int{"/messages/batchModify": http.StatusInternalServerError}
+	retry, done, err := s.MarkAllAsReadResumable(cursor)
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(retry, qt.Equals, cursor)
+	c.Assert(done, qt.IsFalse)
+	c.Assert(unread(), qt.Equals, 1)
+
+	fake.fail = nil
+	cursor, done, err = s.MarkAllAsReadResumable(retry)
+	c.Assert(err, qt.IsNil)
+	c.Assert(done, qt.IsTrue)
+	c.Assert(cursor, qt.Equals, "5")
+	for _, msg := range fake.messages {
+		c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX"}, qt.Commentf("message %s", msg.Id))
+	}
+	// one BatchModify per page, no message being listed twice
+	c.Assert(fake.batches, qt.HasLen, 3)
+	c.Assert(fake.callCount("POST /messages/4/modify"), qt.Equals, 0)
+
+	cursor, done, err = s.MarkAllAsReadResumable(cursor)
+	c.Assert(err, qt.IsNil)
+	c.Assert(done, qt.IsTrue)
+	c.Assert(cursor, qt.Equals, "5")
+}
+
+func TestMessagesNewerThan(t *testing.T) {
+	c := qt.New(t)
+
+	// newest first, like the API; after: includes the anchor's second
+	fake := &fakeGmail{match: func(q string, msg *gmail.Message) bool {
+		var after int64
+		fmt.Sscanf(q, "after:%d", &after)
+		return msg.InternalDate/1000 >= after
+	}}
+	for i, date := range []int64{1672574500000, 1672574450000, 1672574420000, 1672574400900, 1672574400500, 1672574400200, 1672574300000} {
+		fake.messages = append(fake.messages, &gmail.Message{Id: fmt.Sprint(i), InternalDate: date})
+	}
+	anchor := fake.messages[4]
+	s := newFakeService(t, fake)
+
+	msgs, err := s.MessagesNewerThan(anchor, 2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"2", "3"})
+	c.Assert(fake.queries[0].Get("q"), qt.Equals, "after:1672574400")
+
+	msgs, err = s.MessagesNewerThan(anchor, 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"0", "1", "2", "3"})
+
+	_, err = s.MessagesNewerThan(&gmail.Message{Id: "4"}, 2)
+	c.Assert(err, qt.ErrorMatches, "anchor message has no internal date")
+}
+
+func TestGetMessagesPagination(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	for i := 0; i < 1000; i++ {
+		fake.messages = append(fake.messages, &gmail.Message{Id: fmt.Sprint(i)})
+	}
+
+	msgs, err := newFakeService(t, fake).GetMessages(750)
+	c.Assert(err, qt.IsNil)
+	c.Assert(msgs, qt.HasLen, 750)
+	c.Assert(msgs[749].Id, qt.Equals, "749")
+
+	c.Assert(fake.queries, qt.HasLen, 2)
+	c.Assert(fake.queries[0].Get("maxResults"), qt.Equals, "500")
+	c.Assert(fake.queries[1].Get("maxResults"), qt.Equals, "250")
+	c.Assert(fake.queries[1].Get("pageToken"), qt.Equals, "500")
+
+	// fewer messages than requested
+	fake = &fakeGmail{messages: fake.messages[:3]}
+	msgs, err = newFakeService(t, fake).GetMessages(750)
+	c.Assert(err, qt.IsNil)
+	c.Assert(msgs, qt.HasLen, 3)
+	c.Assert(fake.queries, qt.HasLen, 1)
+}
+
+func TestGetUnreadMessages(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	msgs, err := newFakeService(t, fake).GetUnreadMessages(2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"1", "2"})
+
+	c.Assert(fake.queries[0]["labelIds"], qt.DeepEquals, []string{"UNREAD"})
+	c.Assert(fake.queries[0].Get("maxResults"), qt.Equals, "2")
+	for _, p := range append(fake.paramsOf("GET /messages/1"), fake.paramsOf("GET /messages/2")...) {
+		c.Assert(p.Get("format"), qt.Equals, "metadata")
+	}
+}
+
+func TestDefaultFormat(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	s := newFakeService(t, fake)
+	s.DefaultFormat = "metadata"
+
+	msgs, err := s.GetMessages(2)
+	c.Assert(err, qt.IsNil)
+	for _, m := range msgs {
+		c.Assert(fake.paramsOf("GET /messages/" + m.Id)[0].Get("format"), qt.Equals, "metadata")
+	}
+
+	// overridden per call
+	_, err = s.GetMessageWithFormat(msgs[0].Id, "full")
+	c.Assert(err, qt.IsNil)
+	params := fake.paramsOf("GET /messages/" + msgs[0].Id)
+	c.Assert(params, qt.HasLen, 2)
+	c.Assert(params[1].Get("format"), qt.Equals, "full")
+
+	_, err = s.AttachmentStats("")
+	c.Assert(err, qt.IsNil)
+	params = fake.paramsOf("GET /messages/" + msgs[0].Id)
+	c.Assert(params[2].Get("format"), qt.Equals, "full")
+
+	// the API default otherwise
+	s.DefaultFormat = ""
+	_, err = s.GetMessage(msgs[0].Id)
+	c.Assert(err, qt.IsNil)
+	params = fake.paramsOf("GET /messages/" + msgs[0].Id)
+	c.Assert(params[3].Has("format"), qt.IsFalse)
+}
+
+func TestGetMessagesMetadata(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	msgs, err := newFakeService(t, fake).GetMessagesMetadata(2, "From", "Subject")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.HasLen, 2)
+	for _, m := range msgs {
+		p := fake.paramsOf("GET /messages/" + m.Id)[0]
+		c.Assert(p.Get("format"), qt.Equals, "metadata")
+		c.Assert(p["metadataHeaders"], qt.DeepEquals, []string{"From", "Subject"})
+	}
+}
+
+func TestMessagesByIDPartial(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "1"}, {Id: "3"}}}
+	list := &gmail.ListMessagesResponse{Messages: []*gmail.Message{{Id: "1"}, {Id: "2"}, {Id: "3"}}}
+	s := newFakeService(t, fake)
+
+	msgs, errs := s.MessagesByIDPartial(list)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"1", "3"})
+	c.Assert(errs, qt.HasLen, 1)
+	c.Assert(isNotFound(errs["2"]), qt.IsTrue)
+
+	// MessagesByID still fails fast
+	msgs, err := s.MessagesByID(list)
+	c.Assert(isNotFound(err), qt.IsTrue)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"1"})
+
+	msgs, errs = s.MessagesByIDPartial(&gmail.ListMessagesResponse{Messages: []*gmail.Message{{Id: "3"}}})
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"3"})
+	c.Assert(errs, qt.IsNil)
+}
+
+func TestGetMessageSingleflight(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "1", Snippet: "hello"}}}
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fake.ServeHTTP(w, r)
+	})
+	s := newTestService(t, h)
+
+	var wg sync.WaitGroup
+	msgs := make([]*gmail.Message, 50)
+	errs := make([]error, 50)
+	for i := range msgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msgs[i], errs[i] = s.GetMessage("1")
+		}(i)
+	}
+	// let every goroutine join the first call
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range msgs {
+		c.Assert(errs[i], qt.IsNil)
+		c.Assert(msgs[i].Snippet, qt.Equals, "hello")
+	}
+	c.Assert(fake.callCount("GET /messages/1"), qt.Equals, 1)
+
+	// later calls are not cached
+	_, err := s.GetMessage("1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /messages/1"), qt.Equals, 2)
+}
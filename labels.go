@@ -0,0 +1,412 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// labelIDs resolves label names (or IDs) to label IDs. Names are matched
+// case-insensitively.
+func (s *Service) labelIDs(names []string) ([]string, error) {
+	labels, err := s.GetLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		id := ""
+		for _, l := range labels.Labels {
+			if l.Id == name || strings.EqualFold(l.Name, name) {
+				id = l.Id
+				break
+			}
+		}
+		if id == "" {
+			return nil, fmt.Errorf("unknown label %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetMessagesWithAllLabels gets up to howMany messages that have every one of
+// the labels (AND semantics: asking for UNREAD and IMPORTANT returns the
+// messages that are both). Labels are given by name or ID. See
+// GetMessagesWithAnyLabel for OR semantics.
+func (s *Service) GetMessagesWithAllLabels(labelNames []string, howMany uint) ([]*gmail.Message, error) {
+	ids, err := s.labelIDs(labelNames)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me").LabelIds(ids...), howMany)
+	if err != nil {
+		return nil, err
+	}
+	return s.MessagesByID(inbox)
+}
+
+// QueryWithLabels gets up to howMany messages matching the search query that
+// have every one of the labels (by name or ID). Filtering on labels this way
+// is faster and more precise than adding label: terms to the query.
+func (s *Service) QueryWithLabels(query string, labelNames []string, howMany uint) ([]*gmail.Message, error) {
+	ids, err := s.labelIDs(labelNames)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me").Q(query).LabelIds(ids...), howMany)
+	if err != nil {
+		return nil, err
+	}
+	return s.MessagesByID(inbox)
+}
+
+// GetMessagesWithAnyLabel gets up to howMany messages that have at least one
+// of the labels (OR semantics), e.g. messages in folder X or folder Y. At
+// least one label must be given.
+func (s *Service) GetMessagesWithAnyLabel(labelNames []string, howMany uint) ([]*gmail.Message, error) {
+	if len(labelNames) == 0 {
+		return nil, errors.New("no labels given")
+	}
+	inbox, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me").Q(anyLabelQuery(labelNames)), howMany)
+	if err != nil {
+		return nil, err
+	}
+	return s.MessagesByID(inbox)
+}
+
+// RecentPerLabel gets the perLabel newest messages of each label (by name or
+// ID), keyed by the given label names, e.g. for a dashboard showing the latest
+// messages of several folders. The labels are listed concurrently, and only
+// the metadata of the messages is fetched.
+func (s *Service) RecentPerLabel(labelNames []string, perLabel uint) (map[string][]*gmail.Message, error) {
+	ids, err := s.labelIDs(labelNames)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	recent := make(map[string][]*gmail.Message, len(labelNames))
+	var wg sync.WaitGroup
+	for i, name := range labelNames {
+		wg.Add(1)
+		go func(name, id string) {
+			defer wg.Done()
+			res, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me").LabelIds(id), perLabel)
+			var msgs []*gmail.Message
+			if err == nil {
+				msgs, err = s.messagesByID(res, "metadata")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("label %q: %w", name, err))
+				return
+			}
+			recent[name] = msgs
+		}(name, ids[i])
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return recent, nil
+}
+
+// RemoveLabelEverywhere removes the label (by name) from every message having
+// it, then deletes the label, returning how many messages had it. System
+// labels can't be removed. With s.DryRun set, the messages are counted but
+// nothing is changed.
+func (s *Service) RemoveLabelEverywhere(labelName string) (int, error) {
+	labels, err := s.GetLabels()
+	if err != nil {
+		return 0, err
+	}
+	var label *gmail.Label
+	for _, l := range labels.Labels {
+		if strings.EqualFold(l.Name, labelName) {
+			label = l
+			break
+		}
+	}
+	if label == nil {
+		return 0, fmt.Errorf("unknown label %q", labelName)
+	}
+	if label.Type == "system" {
+		return 0, fmt.Errorf("can't remove system label %q", label.Name)
+	}
+
+	ids, err := s.listIDs(s.GmailSvc.Users.Messages.List("me").LabelIds(label.Id))
+	if err != nil {
+		return 0, err
+	}
+	if s.DryRun {
+		return len(ids), nil
+	}
+	if err := s.checkWritable("delete labels"); err != nil {
+		return 0, err
+	}
+	if err := s.BatchModify(ids, nil, []string{label.Id}); err != nil {
+		return 0, err
+	}
+	err = s.call(func(ctx context.Context) error {
+		return s.GmailSvc.Users.Labels.Delete("me", label.Id).Context(ctx).Do()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// anyLabelQuery builds a query matching messages having any of the labels,
+// e.g. {label:work label:"side projects"}.
+func anyLabelQuery(labelNames []string) string {
+	terms := make([]string, len(labelNames))
+	for i, name := range labelNames {
+		terms[i] = "label:" + EscapeQueryTerm(name)
+	}
+	return "{" + strings.Join(terms, " ") + "}"
+}
+
+// systemLabelNames are the names gmail shows for its system labels.
+var systemLabelNames = map[string]string{
+	"INBOX":               "Inbox",
+	"SENT":                "Sent",
+	"DRAFT":               "Drafts",
+	"SPAM":                "Spam",
+	"TRASH":               "Trash",
+	"STARRED":             "Starred",
+	"IMPORTANT":           "Important",
+	"UNREAD":              "Unread",
+	"CHAT":                "Chats",
+	"CATEGORY_PERSONAL":   "Primary",
+	"CATEGORY_SOCIAL":     "Social",
+	"CATEGORY_PROMOTIONS": "Promotions",
+	"CATEGORY_UPDATES":    "Updates",
+	"CATEGORY_FORUMS":     "Forums",
+}
+
+// SystemLabelDisplayName returns the name gmail shows for a system label ID
+// (e.g. "Promotions" for CATEGORY_PROMOTIONS). Unknown IDs are returned as is.
+func SystemLabelDisplayName(labelID string) string {
+	if name, ok := systemLabelNames[labelID]; ok {
+		return name
+	}
+	return labelID
+}
+
+// Category is one of the tabs gmail sorts the inbox into.
+type Category string
+
+// The categories, as their label IDs.
+const (
+	CategoryPrimary    Category = "CATEGORY_PERSONAL"
+	CategorySocial     Category = "CATEGORY_SOCIAL"
+	CategoryPromotions Category = "CATEGORY_PROMOTIONS"
+	CategoryUpdates    Category = "CATEGORY_UPDATES"
+	CategoryForums     Category = "CATEGORY_FORUMS"
+)
+
+// categories are all the categories.
+var categories = []Category{CategoryPrimary, CategorySocial, CategoryPromotions, CategoryUpdates, CategoryForums}
+
+// SetCategory moves the message to the category, in a single request: a
+// message is in a single category, so its other CATEGORY_ labels are removed.
+// It returns the updated message.
+func (s *Service) SetCategory(msg *gmail.Message, cat Category) (*gmail.Message, error) {
+	var known bool
+	req := &gmail.ModifyMessageRequest{AddLabelIds: []string{string(cat)}}
+	for _, c := range categories {
+		if c == cat {
+			known = true
+			continue
+		}
+		req.RemoveLabelIds = append(req.RemoveLabelIds, string(c))
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown category %q", cat)
+	}
+	for _, id := range msg.LabelIds {
+		if strings.HasPrefix(id, "CATEGORY_") && id != string(cat) && !contains(req.RemoveLabelIds, id) {
+			req.RemoveLabelIds = append(req.RemoveLabelIds, id)
+		}
+	}
+	return s.MarkAs(msg.Id, req)
+}
+
+// Location is where a message is in the mailbox, which tells what can be done
+// with it (archiving, restoring from the trash...).
+type Location string
+
+// The locations of a message.
+const (
+	LocationInbox    Location = "inbox"
+	LocationArchived Location = "archived"
+	LocationTrash    Location = "trash"
+	LocationSpam     Location = "spam"
+	LocationSent     Location = "sent"
+	LocationDraft    Location = "draft"
+)
+
+// MessageLocation returns where the message is, from its system labels. A
+// message can have several of them: the trash comes first, then spam, then
+// drafts, then the inbox (which a message sent to oneself is in), then sent
+// mail. A message with none of them is archived.
+func MessageLocation(msg *gmail.Message) Location {
+	for _, l := range []struct {
+		label    string
+		location Location
+	}{
+		{"TRASH", LocationTrash},
+		{"SPAM", LocationSpam},
+		{"DRAFT", LocationDraft},
+		{"INBOX", LocationInbox},
+		{"SENT", LocationSent},
+	} {
+		if contains(msg.LabelIds, l.label) {
+			return l.location
+		}
+	}
+	return LocationArchived
+}
+
+// labelColors is the palette label colors have to be picked from, for both
+// the background and the text.
+var labelColors = strings.Fields(`
+	#000000 #434343 #666666 #999999 #cccccc #efefef #f3f3f3 #ffffff
+	#fb4c2f #ffad47 #fad165 #16a766 #43d692 #4a86e8 #a479e2 #f691b3
+	#f6c5be #ffe6c7 #fef1d1 #b9e4d0 #c6f3de #c9daf8 #e4d7f5 #fcdee8
+	#efa093 #ffd6a2 #fce8b3 #89d3b2 #a0eac9 #a4c2f4 #d0bcf1 #fbc8d9
+	#e66550 #ffbc6b #fcda83 #44b984 #68dfa9 #6d9eeb #b694e8 #f7a7c0
+	#cc3a21 #eaa041 #f2c960 #149e60 #3dc789 #3c78d8 #8e63ce #e07798
+	#ac2b16 #cf8933 #d5ae49 #0b804b #2a9c68 #285bac #653e9b #b65775
+	#822111 #a46a21 #aa8831 #076239 #1a764d #1c4587 #41236d #83334c
+	#464646 #e7e7e7 #0d3472 #b6cff5 #0d3b44 #98d7e4 #3d188e #e3d7ff
+	#711a36 #fbd3e0 #8a1c0a #f2b2a8 #7a2e0b #ffc8af #7a4706 #ffdeb5
+	#594c05 #fbe983 #684e07 #fdedc1 #0b4f30 #b3efd3 #04502e #a2dcc1
+	#c2c2c2 #4986e7 #2da2bb #b99aff #994a64 #f691b2 #ff7537 #ffad46
+	#662e37 #ebdbde #cca6ac #094228 #42d692 #16a765
+`)
+
+// checkLabelColor returns an error listing the palette when color is not one
+// of labelColors. A nil color is valid.
+func checkLabelColor(color *gmail.LabelColor) error {
+	if color == nil {
+		return nil
+	}
+	for _, c := range []string{color.BackgroundColor, color.TextColor} {
+		if !contains(labelColors, strings.ToLower(c)) {
+			return fmt.Errorf("label color %q is not in the palette, use one of: %s", c, strings.Join(labelColors, ", "))
+		}
+	}
+	return nil
+}
+
+// CreateLabel creates a user label. Its Color, when set, must use colors of
+// the gmail palette.
+func (s *Service) CreateLabel(label *gmail.Label) (*gmail.Label, error) {
+	if err := s.checkWritable("create labels"); err != nil {
+		return nil, err
+	}
+	if err := checkLabelColor(label.Color); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Labels.Create("me", label).Context(ctx).Do()
+}
+
+// UpdateLabel replaces the label with the given ID. Its Color, when set, must
+// use colors of the gmail palette.
+func (s *Service) UpdateLabel(label *gmail.Label) (*gmail.Label, error) {
+	if err := s.checkWritable("update labels"); err != nil {
+		return nil, err
+	}
+	if err := checkLabelColor(label.Color); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Labels.Update("me", label.Id, label).Context(ctx).Do()
+}
+
+// SetLabelColor sets the background and text colors of a label, given as
+// "#rrggbb" hex values. Gmail only accepts the colors of its palette: others
+// are rejected with an error listing the allowed ones.
+func (s *Service) SetLabelColor(labelID, bgHex, textHex string) error {
+	if err := s.checkWritable("update labels"); err != nil {
+		return err
+	}
+	color := &gmail.LabelColor{BackgroundColor: strings.ToLower(bgHex), TextColor: strings.ToLower(textHex)}
+	if err := checkLabelColor(color); err != nil {
+		return err
+	}
+	return s.call(func(ctx context.Context) error {
+		_, err := s.GmailSvc.Users.Labels.Patch("me", labelID, &gmail.Label{Color: color}).Context(ctx).Do()
+		return err
+	})
+}
+
+// LabelNode is a node of the label tree returned by LabelTree.
+type LabelNode struct {
+	// Name is the last part of the label name ("Alpha" for
+	// "Work/Projects/Alpha"), and Path the full name. Both are empty for the
+	// root.
+	Name, Path string
+	// Label is nil for the root, and for the parents that are not labels on
+	// their own (e.g. "Work/Projects" when only "Work/Projects/Alpha"
+	// exists).
+	Label    *gmail.Label
+	Children []*LabelNode
+}
+
+// LabelTree returns the labels as the tree gmail shows in its sidebar, nested
+// labels being the children of the labels their names start with
+// ("Work/Projects" is the parent of "Work/Projects/Alpha"). Children are
+// sorted by name, system labels being at the top level.
+func (s *Service) LabelTree() (*LabelNode, error) {
+	labels, err := s.GetLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	root := &LabelNode{}
+	nodes := map[string]*LabelNode{"": root}
+	var node func(path string) *LabelNode
+	node = func(path string) *LabelNode {
+		if n, ok := nodes[path]; ok {
+			return n
+		}
+		parent, name := "", path
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			parent, name = path[:i], path[i+1:]
+		}
+		n := &LabelNode{Name: name, Path: path}
+		p := node(parent)
+		p.Children = append(p.Children, n)
+		nodes[path] = n
+		return n
+	}
+	for _, l := range labels.Labels {
+		node(l.Name).Label = l
+	}
+
+	var sortChildren func(n *LabelNode)
+	sortChildren = func(n *LabelNode) {
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+		for _, c := range n.Children {
+			sortChildren(c)
+		}
+	}
+	sortChildren(root)
+	return root, nil
+}
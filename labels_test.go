@@ -0,0 +1,265 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// labelledMailbox returns a fake mailbox with a few user labels.
+func labelledMailbox() *fakeGmail {
+	return &fakeGmail{
+		labels: []*gmail.Label{
+			{Id: "INBOX", Name: "INBOX", Type: "system"},
+			{Id: "UNREAD", Name: "UNREAD", Type: "system"},
+			{Id: "IMPORTANT", Name: "IMPORTANT", Type: "system"},
+			{Id: "Label_1", Name: "Work", Type: "user"},
+			{Id: "Label_2", Name: "Side Projects", Type: "user"},
+		},
+		messages: []*gmail.Message{
+			{Id: "1", LabelIds: []string{"INBOX", "UNREAD", "IMPORTANT"}},
+			{Id: "2", LabelIds: []string{"INBOX", "UNREAD"}},
+			{Id: "3", LabelIds: []string{"IMPORTANT", "Label_1"}},
+			{Id: "4", LabelIds: []string{"UNREAD", "IMPORTANT", "Label_2"}},
+		},
+	}
+}
+
+func ids(msgs []*gmail.Message) []string {
+	out := []string{}
+	for _, m := range msgs {
+		out = append(out, m.Id)
+	}
+	return out
+}
+
+func TestGetMessagesWithAllLabels(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	service := newFakeService(t, fake)
+	msgs, err := service.GetMessagesWithAllLabels([]string{"unread", "IMPORTANT"}, 10)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"1", "4"})
+	c.Assert(fake.queries[0]["labelIds"], qt.DeepEquals, []string{"UNREAD", "IMPORTANT"})
+	c.Assert(fake.queries[0].Get("maxResults"), qt.Equals, "10")
+
+	msgs, err = service.GetMessagesWithAllLabels([]string{"work"}, 10)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"3"})
+
+	_, err = service.GetMessagesWithAllLabels([]string{"Work", "Nope"}, 10)
+	c.Assert(err, qt.ErrorMatches, `unknown label "Nope"`)
+}
+
+func TestRecentPerLabel(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	recent, err := newFakeService(t, fake).RecentPerLabel([]string{"UNREAD", "important"}, 2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(recent, qt.HasLen, 2)
+	c.Assert(ids(recent["UNREAD"]), qt.DeepEquals, []string{"1", "2"})
+	c.Assert(ids(recent["important"]), qt.DeepEquals, []string{"1", "3"})
+	c.Assert(fake.callCount("GET /labels"), qt.Equals, 1)
+	for _, p := range fake.paramsOf("GET /messages/1") {
+		c.Assert(p.Get("format"), qt.Equals, "metadata")
+	}
+	for _, q := range fake.queries {
+		c.Assert(q.Get("maxResults"), qt.Equals, "2")
+	}
+
+	_, err = newFakeService(t, fake).RecentPerLabel([]string{"Work", "Nope"}, 2)
+	c.Assert(err, qt.ErrorMatches, `unknown label "Nope"`)
+}
+
+func TestGetMessagesWithAnyLabel(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(anyLabelQuery([]string{"Work"}), qt.Equals, "{label:Work}")
+	c.Assert(anyLabelQuery([]string{"Work", "Side Projects", "Work/Alpha"}), qt.Equals, `{label:Work label:"Side Projects" label:Work/Alpha}`)
+
+	fake := labelledMailbox()
+	msgs, err := newFakeService(t, fake).GetMessagesWithAnyLabel([]string{"Work", "Side Projects"}, 5)
+	c.Assert(err, qt.IsNil)
+	c.Assert(msgs, qt.HasLen, 4)
+	c.Assert(fake.queries[0].Get("q"), qt.Equals, `{label:Work label:"Side Projects"}`)
+	c.Assert(fake.queries[0].Get("maxResults"), qt.Equals, "5")
+
+	_, err = newFakeService(t, fake).GetMessagesWithAnyLabel(nil, 5)
+	c.Assert(err, qt.ErrorMatches, "no labels given")
+	c.Assert(fake.queries, qt.HasLen, 1)
+}
+
+func TestSystemLabelDisplayName(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(SystemLabelDisplayName("CATEGORY_PERSONAL"), qt.Equals, "Primary")
+	c.Assert(SystemLabelDisplayName("CATEGORY_PROMOTIONS"), qt.Equals, "Promotions")
+	c.Assert(SystemLabelDisplayName("SENT"), qt.Equals, "Sent")
+	c.Assert(SystemLabelDisplayName("DRAFT"), qt.Equals, "Drafts")
+	c.Assert(SystemLabelDisplayName("Label_42"), qt.Equals, "Label_42")
+}
+
+func TestRemoveLabelEverywhere(t *testing.T) {
+	c := qt.New(t)
+
+	mailbox := func() *fakeGmail {
+		fake := labelledMailbox()
+		fake.message("1").LabelIds = append(fake.message("1").LabelIds, "Label_1")
+		return fake
+	}
+
+	c.Run("removes", func(c *qt.C) {
+		fake := mailbox()
+		n, err := newFakeService(t, fake).RemoveLabelEverywhere("work")
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, 2)
+		c.Assert(fake.batches, qt.HasLen, 1)
+		c.Assert(fake.batches[0].Ids, qt.DeepEquals, []string{"1", "3"})
+		c.Assert(fake.batches[0].RemoveLabelIds, qt.DeepEquals, []string{"Label_1"})
+		c.Assert(fake.message("3").LabelIds, qt.DeepEquals, []string{"IMPORTANT"})
+		c.Assert(fake.callCount("DELETE /labels/Label_1"), qt.Equals, 1)
+	})
+
+	c.Run("dry run", func(c *qt.C) {
+		fake := mailbox()
+		s := newFakeService(t, fake)
+		s.DryRun = true
+		n, err := s.RemoveLabelEverywhere("Work")
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, 2)
+		c.Assert(fake.batches, qt.HasLen, 0)
+		c.Assert(fake.callCount("DELETE /labels/Label_1"), qt.Equals, 0)
+	})
+
+	c.Run("system label", func(c *qt.C) {
+		fake := mailbox()
+		_, err := newFakeService(t, fake).RemoveLabelEverywhere("INBOX")
+		c.Assert(err, qt.ErrorMatches, `can't remove system label "INBOX"`)
+		c.Assert(fake.batches, qt.HasLen, 0)
+	})
+
+	c.Run("unknown label", func(c *qt.C) {
+		_, err := newFakeService(t, mailbox()).RemoveLabelEverywhere("Nope")
+		c.Assert(err, qt.ErrorMatches, `unknown label "Nope"`)
+	})
+}
+
+func TestQueryWithLabels(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	fake.match = func(q string, msg *gmail.Message) bool {
+		return q == "from:boss@example.com" && msg.Id != "2"
+	}
+	msgs, err := newFakeService(t, fake).QueryWithLabels("from:boss@example.com", []string{"unread"}, 10)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"1", "4"})
+	c.Assert(fake.queries[0].Get("q"), qt.Equals, "from:boss@example.com")
+	c.Assert(fake.queries[0]["labelIds"], qt.DeepEquals, []string{"UNREAD"})
+}
+
+func TestSetLabelColor(t *testing.T) {
+	c := qt.New(t)
+
+	fake := labelledMailbox()
+	s := newFakeService(t, fake)
+
+	err := s.SetLabelColor("Label_1", "#FB4C2F", "#ffffff")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.labels[3].Color, qt.DeepEquals, &gmail.LabelColor{BackgroundColor: "#fb4c2f", TextColor: "#ffffff"})
+	c.Assert(fake.labels[3].Name, qt.Equals, "Work")
+
+	err = s.SetLabelColor("Label_1", "#123456", "#ffffff")
+	c.Assert(err, qt.ErrorMatches, `label color "#123456" is not in the palette, use one of: #000000, #434343, .*`)
+	c.Assert(fake.callCount("PATCH /labels/Label_1"), qt.Equals, 1)
+
+	_, err = s.CreateLabel(&gmail.Label{Name: "Red", Color: &gmail.LabelColor{BackgroundColor: "red", TextColor: "#000000"}})
+	c.Assert(err, qt.ErrorMatches, `label color "red" is not in the palette.*`)
+	label, err := s.CreateLabel(&gmail.Label{Name: "Red", Color: &gmail.LabelColor{BackgroundColor: "#cc3a21", TextColor: "#000000"}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(label.Id, qt.Not(qt.Equals), "")
+}
+
+func TestSetCategory(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "m1", LabelIds: []string{"INBOX", "CATEGORY_PROMOTIONS", "UNREAD"}},
+		{Id: "m2", LabelIds: []string{"INBOX", "CATEGORY_UPDATES", "CATEGORY_PERSONAL", "CATEGORY_RESERVATIONS"}},
+	}}
+	s := newFakeService(t, fake)
+
+	msg, err := s.SetCategory(fake.messages[0], CategoryPrimary)
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX", "UNREAD", "CATEGORY_PERSONAL"})
+
+	msg, err = s.SetCategory(&gmail.Message{Id: "m2", LabelIds: fake.messages[1].LabelIds}, CategoryForums)
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX", "CATEGORY_FORUMS"})
+	c.Assert(fake.callCount("POST /messages/m2/modify"), qt.Equals, 1)
+
+	_, err = s.SetCategory(fake.messages[0], "INBOX")
+	c.Assert(err, qt.ErrorMatches, `unknown category "INBOX"`)
+}
+
+func TestMessageLocation(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		labels []string
+		want   Location
+	}{
+		{[]string{"INBOX", "UNREAD", "CATEGORY_PERSONAL"}, LocationInbox},
+		{[]string{"CATEGORY_PERSONAL", "Label_1"}, LocationArchived},
+		{nil, LocationArchived},
+		{[]string{"TRASH", "INBOX"}, LocationTrash},
+		{[]string{"TRASH", "SPAM"}, LocationTrash},
+		{[]string{"SPAM", "UNREAD"}, LocationSpam},
+		{[]string{"SENT"}, LocationSent},
+		{[]string{"SENT", "INBOX"}, LocationInbox},
+		{[]string{"DRAFT"}, LocationDraft},
+		{[]string{"TRASH", "DRAFT"}, LocationTrash},
+	} {
+		c.Assert(MessageLocation(&gmail.Message{LabelIds: test.labels}), qt.Equals, test.want, qt.Commentf("%v", test.labels))
+	}
+}
+
+func TestLabelTree(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{labels: []*gmail.Label{
+		{Id: "Label_3", Name: "Work/Projects/Beta"},
+		{Id: "INBOX", Name: "INBOX", Type: "system"},
+		{Id: "Label_1", Name: "Work"},
+		{Id: "Label_2", Name: "Work/Projects/Alpha"},
+		{Id: "Label_4", Name: "Personal"},
+	}}
+	root, err := newFakeService(t, fake).LabelTree()
+	c.Assert(err, qt.IsNil)
+
+	var lines []string
+	var walk func(n *LabelNode, indent string)
+	walk = func(n *LabelNode, indent string) {
+		for _, child := range n.Children {
+			id := "-"
+			if child.Label != nil {
+				id = child.Label.Id
+			}
+			lines = append(lines, indent+child.Name+" "+child.Path+" "+id)
+			walk(child, indent+"  ")
+		}
+	}
+	walk(root, "")
+	c.Assert(lines, qt.DeepEquals, []string{
+		"INBOX INBOX INBOX",
+		"Personal Personal Label_4",
+		"Work Work Label_1",
+		// a parent without a label of its own
+		"  Projects Work/Projects -",
+		"    Alpha Work/Projects/Alpha Label_2",
+		"    Beta Work/Projects/Beta Label_3",
+	})
+	c.Assert(root.Label, qt.IsNil)
+}
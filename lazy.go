@@ -0,0 +1,80 @@
+package inboxer
+
+import (
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// LazyMessage is a message whose metadata (labels, headers, snippet) has been
+// fetched, its body only being fetched the first time it is needed. List views
+// showing many messages but opening few of them save the bodies of the others.
+type LazyMessage struct {
+	// Message holds the metadata of the message.
+	*gmail.Message
+
+	s    *Service
+	mu   sync.Mutex
+	full *gmail.Message
+}
+
+// Full returns the full message, getting it on the first call only. With
+// Service.CacheSize set, it comes from the cache when the message was got
+// before.
+func (m *LazyMessage) Full() (*gmail.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.full == nil {
+		full, err := m.s.getMessage(m.Id, "full")
+		if err != nil {
+			return nil, err
+		}
+		m.full = full
+	}
+	return m.full, nil
+}
+
+// Body returns the body of the message of the given mime type, like GetBody,
+// getting the full message if needed (see Full).
+func (m *LazyMessage) Body(mimeType string) (string, error) {
+	full, err := m.Full()
+	if err != nil {
+		return "", err
+	}
+	return GetBody(full, mimeType)
+}
+
+// QueryLazy works like Query, returning messages whose bodies are only
+// fetched when needed.
+func (s *Service) QueryLazy(query string) ([]*LazyMessage, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	res, err := s.GmailSvc.Users.Messages.List("me").Q(query).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return s.lazyMessages(res)
+}
+
+// GetMessagesLazy works like GetMessages, returning messages whose bodies are
+// only fetched when needed.
+func (s *Service) GetMessagesLazy(howMany uint) ([]*LazyMessage, error) {
+	res, err := s.listUpTo(s.GmailSvc.Users.Messages.List("me"), howMany)
+	if err != nil {
+		return nil, err
+	}
+	return s.lazyMessages(res)
+}
+
+// lazyMessages gets the metadata of the listed messages.
+func (s *Service) lazyMessages(res *gmail.ListMessagesResponse) ([]*LazyMessage, error) {
+	msgs, err := s.messagesByID(res, "metadata")
+	if err != nil {
+		return nil, err
+	}
+	lazy := make([]*LazyMessage, len(msgs))
+	for i, msg := range msgs {
+		lazy[i] = &LazyMessage{Message: msg, s: s}
+	}
+	return lazy, nil
+}
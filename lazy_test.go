@@ -0,0 +1,48 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestLazyMessages(t *testing.T) {
+	c := qt.New(t)
+
+	mailbox := func() *fakeGmail {
+		fake := &fakeGmail{}
+		for _, id := range []string{"m1", "m2"} {
+			msg := withHeaders(newMessage(newPart("text/plain", "body of "+id)), "Subject", "about "+id)
+			msg.Id = id
+			fake.messages = append(fake.messages, msg)
+		}
+		return fake
+	}
+	tests := []struct {
+		name string
+		get  func(*Service) ([]*LazyMessage, error)
+	}{
+		{"query", func(s *Service) ([]*LazyMessage, error) { return s.QueryLazy("") }},
+		{"get messages", func(s *Service) ([]*LazyMessage, error) { return s.GetMessagesLazy(10) }},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			fake := mailbox()
+			msgs, err := test.get(newFakeService(c.TB, fake))
+			c.Assert(err, qt.IsNil)
+			c.Assert(msgs, qt.HasLen, 2)
+			c.Assert(GetHeaders(msgs[0].Message).Get("Subject"), qt.Equals, "about m1")
+			c.Assert(fake.paramsOf("GET /messages/m1"), qt.HasLen, 1)
+			c.Assert(fake.paramsOf("GET /messages/m1")[0].Get("format"), qt.Equals, "metadata")
+
+			for i := 0; i < 2; i++ {
+				body, err := msgs[0].Body("text/plain")
+				c.Assert(err, qt.IsNil)
+				c.Assert(body, qt.Equals, "body of m1")
+			}
+			c.Assert(fake.paramsOf("GET /messages/m1"), qt.HasLen, 2)
+			c.Assert(fake.paramsOf("GET /messages/m1")[1].Get("format"), qt.Equals, "full")
+			c.Assert(fake.callCount("GET /messages/m2"), qt.Equals, 1)
+		})
+	}
+}
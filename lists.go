@@ -0,0 +1,82 @@
+package inboxer
+
+import (
+	"errors"
+	"mime"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// ErrNotMailingList is returned by GetMailingList for messages that were not
+// sent through a mailing list.
+var ErrNotMailingList = errors.New("not a mailing list message")
+
+// MailingListInfo identifies the mailing list a message was sent through
+// (RFC 2919 and RFC 2369 headers).
+type MailingListInfo struct {
+	// ID is the identifier of the list, e.g. "dev.example.com".
+	ID string
+	// Name is the description of the list, e.g. "Dev List". It may be empty.
+	Name string
+	// Domain is the domain the ID belongs to, e.g. "example.com".
+	Domain string
+	// Post are the URLs (usually mailto:) to post to the list. Empty for
+	// announcement lists where posting is not allowed.
+	Post []string
+	// Archive are the URLs of the list archives.
+	Archive []string
+	// Unsubscribe are the URLs to unsubscribe from the list.
+	Unsubscribe []string
+}
+
+// listURL matches the bracketed URLs of List-* headers.
+var listURL = regexp.MustCompile(`<([^>]*)>`)
+
+// GetMailingList returns the mailing list the message was sent through, from
+// its List-Id header, or ErrNotMailingList when there is none.
+func GetMailingList(msg *gmail.Message) (*MailingListInfo, error) {
+	h := GetHeaders(msg)
+	listID := strings.TrimSpace(h.Get("List-Id"))
+	if listID == "" {
+		return nil, ErrNotMailingList
+	}
+
+	info := &MailingListInfo{ID: listIdentifier(listID)}
+	if i := strings.LastIndex(listID, "<"); i >= 0 && strings.HasSuffix(listID, ">") {
+		name := strings.Trim(strings.TrimSpace(listID[:i]), `"`)
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+			name = decoded
+		}
+		info.Name = name
+	}
+	if _, domain, ok := strings.Cut(info.ID, "."); ok {
+		info.Domain = domain
+	}
+
+	urls := func(name string) []string {
+		var out []string
+		for _, m := range listURL.FindAllStringSubmatch(h.Get(name), -1) {
+			if u := strings.Join(strings.Fields(m[1]), ""); u != "" {
+				out = append(out, u)
+			}
+		}
+		return out
+	}
+	info.Post = urls("List-Post")
+	info.Archive = urls("List-Archive")
+	info.Unsubscribe = urls("List-Unsubscribe")
+	return info, nil
+}
+
+// listIdentifier returns the identifier of a List-Id header value, without
+// the display name and angle brackets around it (RFC 2919), e.g.
+// "dev.example.com" for "Dev List <dev.example.com>".
+func listIdentifier(value string) string {
+	value = strings.TrimSpace(value)
+	if i := strings.LastIndex(value, "<"); i >= 0 && strings.HasSuffix(value, ">") {
+		return value[i+1 : len(value)-1]
+	}
+	return value
+}
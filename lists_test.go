@@ -0,0 +1,40 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestGetMailingList(t *testing.T) {
+	c := qt.New(t)
+
+	msg := withHeaders(&gmail.Message{},
+		"Mailing-list", "list dev@example.com; contact dev-owner@example.com",
+		"List-Id", "Dev List <dev.example.com>",
+		"List-Post", "<mailto:dev@example.com>",
+		"List-Archive", "<https://lists.example.com/archive/dev>",
+		"List-Unsubscribe", "<mailto:dev-unsubscribe@example.com>, <https://lists.example.com/u/dev>",
+	)
+	info, err := GetMailingList(msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info, qt.DeepEquals, &MailingListInfo{
+		ID:          "dev.example.com",
+		Name:        "Dev List",
+		Domain:      "example.com",
+		Post:        []string{"mailto:dev@example.com"},
+		Archive:     []string{"https://lists.example.com/archive/dev"},
+		Unsubscribe: []string{"mailto:dev-unsubscribe@example.com", "https://lists.example.com/u/dev"},
+	})
+	c.Assert(GetPartialMetadata(msg).MailingList, qt.Equals, "Dev List <dev.example.com>")
+
+	info, err = GetMailingList(withHeaders(&gmail.Message{}, "List-Id", "<announce.example.org>", "List-Post", "NO"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.ID, qt.Equals, "announce.example.org")
+	c.Assert(info.Name, qt.Equals, "")
+	c.Assert(info.Post, qt.IsNil)
+
+	_, err = GetMailingList(withHeaders(&gmail.Message{}, "Subject", "hi"))
+	c.Assert(err, qt.Equals, ErrNotMailingList)
+}
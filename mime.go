@@ -0,0 +1,82 @@
+package inboxer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// MessageToMIME returns the message as a net/mail Message, so it can be used
+// with the standard library MIME tooling. Messages fetched in the raw format
+// are parsed as is. Otherwise the message is rebuilt from its payload: bodies
+// are written decoded (with a binary Content-Transfer-Encoding), and
+// attachments whose data was not fetched are left empty.
+func MessageToMIME(msg *gmail.Message) (*mail.Message, error) {
+	if msg.Raw != "" {
+		raw, err := decodeBase64URL(msg.Raw)
+		if err != nil {
+			return nil, err
+		}
+		return mail.ReadMessage(bytes.NewReader(raw))
+	}
+
+	if msg.Payload == nil {
+		return nil, errors.New("message has no payload")
+	}
+	var buf bytes.Buffer
+	if err := writeMIMEPart(&buf, msg.Payload); err != nil {
+		return nil, err
+	}
+	return mail.ReadMessage(&buf)
+}
+
+// writeMIMEPart writes the headers and content of the part.
+func writeMIMEPart(buf *bytes.Buffer, part *gmail.MessagePart) error {
+	for _, h := range part.Headers {
+		if strings.EqualFold(h.Name, "Content-Transfer-Encoding") {
+			continue
+		}
+		fmt.Fprintf(buf, "%s: %s\r\n", h.Name, h.Value)
+	}
+	if len(part.Parts) == 0 {
+		buf.WriteString("Content-Transfer-Encoding: binary\r\n\r\n")
+		if part.Body != nil && part.Body.Data != "" {
+			data, err := decodeBase64URL(part.Body.Data)
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+		}
+		return nil
+	}
+
+	buf.WriteString("\r\n")
+	_, params, err := mime.ParseMediaType(partHeader(part, "Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return fmt.Errorf("multipart part %q has no boundary", part.PartId)
+	}
+	for _, p := range part.Parts {
+		fmt.Fprintf(buf, "--%s\r\n", params["boundary"])
+		if err := writeMIMEPart(buf, p); err != nil {
+			return err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(buf, "--%s--\r\n", params["boundary"])
+	return nil
+}
+
+// partHeader returns the value of the first header of the part named name.
+func partHeader(part *gmail.MessagePart, name string) string {
+	for _, h := range part.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
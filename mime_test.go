@@ -0,0 +1,65 @@
+package inboxer
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestMessageToMIME(t *testing.T) {
+	c := qt.New(t)
+
+	plain := newPart("text/plain", "Hello Bob")
+	plain.Headers = []*gmail.MessagePartHeader{
+		{Name: "Content-Type", Value: `text/plain; charset="UTF-8"`},
+		{Name: "Content-Transfer-Encoding", Value: "quoted-printable"},
+	}
+	html := newPart("text/html", "<p>Hello Bob</p>")
+	html.Headers = []*gmail.MessagePartHeader{{Name: "Content-Type", Value: `text/html; charset="UTF-8"`}}
+	msg := newMessage(plain, html)
+	msg.Payload.MimeType = "multipart/alternative"
+	withHeaders(msg,
+		"From", "Alice <alice@example.com>",
+		"Sender", "list@example.com",
+		"To", "bob@example.com",
+		"Subject", "Hi there",
+		"Content-Type", `multipart/alternative; boundary="000000000000abc"`,
+	)
+
+	m, err := MessageToMIME(msg)
+	c.Assert(err, qt.IsNil)
+
+	meta := GetPartialMetadata(msg)
+	c.Assert(m.Header.Get("From"), qt.Equals, meta.From)
+	c.Assert(m.Header.Get("Sender"), qt.Equals, meta.Sender)
+	c.Assert(m.Header.Get("Subject"), qt.Equals, meta.Subject)
+	c.Assert([]string{m.Header.Get("To")}, qt.DeepEquals, meta.To)
+
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	c.Assert(err, qt.IsNil)
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for _, want := range []string{"Hello Bob", "<p>Hello Bob</p>"} {
+		p, err := mr.NextPart()
+		c.Assert(err, qt.IsNil)
+		body, err := io.ReadAll(p)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Equals, want)
+	}
+	_, err = mr.NextPart()
+	c.Assert(err, qt.Equals, io.EOF)
+
+	c.Run("raw", func(c *qt.C) {
+		raw := &gmail.Message{Raw: base64.URLEncoding.EncodeToString([]byte("Subject: Raw\r\n\r\nbody"))}
+		m, err := MessageToMIME(raw)
+		c.Assert(err, qt.IsNil)
+		c.Assert(m.Header.Get("Subject"), qt.Equals, "Raw")
+		body, err := io.ReadAll(m.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Equals, "body")
+	})
+}
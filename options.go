@@ -0,0 +1,52 @@
+package inboxer
+
+import (
+	"google.golang.org/api/option"
+)
+
+// Version is the current version of the library.
+const Version = "0.1.0"
+
+// DefaultUserAgent is the User-Agent fragment sent with every request unless
+// WithUserAgent is used.
+const DefaultUserAgent = "inboxer/" + Version
+
+// Option customizes the Service built by NewGmailServiceWithOptions.
+type Option func(*serviceOptions)
+
+type serviceOptions struct {
+	userAgent  string
+	client     []option.ClientOption
+	tokenStore TokenStore
+}
+
+// WithUserAgent sets the application name reported to the Gmail API in the
+// User-Agent header. This is what identifies your traffic in the GCP console.
+func WithUserAgent(userAgent string) Option {
+	return func(o *serviceOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithTokenStore loads the OAuth token from the store, and saves it there
+// when it is refreshed, instead of using the token file written by
+// SetupGmailService.
+func WithTokenStore(store TokenStore) Option {
+	return func(o *serviceOptions) {
+		o.tokenStore = store
+	}
+}
+
+// WithEndpoint sends the API requests to url rather than to Google's
+// servers, e.g. to a fake gmail server in tests or through a proxy. The URL
+// is the base path of the API, "https://gmail.googleapis.com/" by default.
+func WithEndpoint(url string) Option {
+	return withClientOption(option.WithEndpoint(url))
+}
+
+// withClientOption passes a raw option.ClientOption to gmail.NewService.
+func withClientOption(opt option.ClientOption) Option {
+	return func(o *serviceOptions) {
+		o.client = append(o.client, opt)
+	}
+}
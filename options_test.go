@@ -0,0 +1,55 @@
+package inboxer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestUserAgent(t *testing.T) {
+	c := qt.New(t)
+
+	var got string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+		writeJSON(w, &gmail.ListLabelsResponse{})
+	})
+
+	c.Run("default", func(c *qt.C) {
+		_, err := newTestService(t, h).GetLabels()
+		c.Assert(err, qt.IsNil)
+		c.Assert(strings.HasSuffix(got, " "+DefaultUserAgent), qt.IsTrue, qt.Commentf("User-Agent: %q", got))
+	})
+
+	c.Run("custom", func(c *qt.C) {
+		_, err := newTestService(t, h, WithUserAgent("my-app/2.0")).GetLabels()
+		c.Assert(err, qt.IsNil)
+		c.Assert(strings.HasSuffix(got, " my-app/2.0"), qt.IsTrue, qt.Commentf("User-Agent: %q", got))
+	})
+}
+
+func TestWithEndpoint(t *testing.T) {
+	c := qt.New(t)
+
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/gmail/v1/users/me/labels")
+		auth = r.Header.Get("Authorization")
+		writeJSON(w, &gmail.ListLabelsResponse{Labels: []*gmail.Label{{Id: "INBOX"}}})
+	}))
+	defer srv.Close()
+
+	store := &memoryStore{token: &oauth2.Token{AccessToken: "access", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}}
+	s, err := NewGmailServiceWithOptions(writeCredentials(c), []string{gmail.GmailReadonlyScope}, WithTokenStore(store), WithEndpoint(srv.URL+"/"))
+	c.Assert(err, qt.IsNil)
+	labels, err := s.GetLabels()
+	c.Assert(err, qt.IsNil)
+	c.Assert(labels.Labels, qt.HasLen, 1)
+	c.Assert(auth, qt.Equals, "Bearer access")
+}
@@ -0,0 +1,46 @@
+package inboxer
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultOTPPatterns are the patterns used by ExtractOTP when none are given.
+// Each pattern must capture the code in its first group. Patterns are tried in
+// order, so the more specific ones come first.
+var DefaultOTPPatterns = []*regexp.Regexp{
+	// "123456 is your verification code"
+	regexp.MustCompile(`(?i)\b(\d{4,8})\b\s+is\s+your\b[^.\n]{0,40}?\b(?:code|otp|pin|passcode|password)`),
+	// "Your verification code is: 123456", "OTP - 1234"
+	regexp.MustCompile(`(?i)\b(?:code|verification|otp|passcode|pin)\b[^0-9\n]{0,30}?\b(\d{4,8})\b`),
+}
+
+var htmlTags = regexp.MustCompile(`(?s)<(?:script|style)[^>]*>.*?</(?:script|style)>|<[^>]*>`)
+
+// ExtractOTP looks for a one-time code (4 to 8 digits next to a keyword like
+// "code", "verification" or "OTP") in the body of the email. The plain text
+// body is preferred, falling back to the html one. patterns replace
+// DefaultOTPPatterns when given; each must capture the code in its first group.
+func ExtractOTP(msg *gmail.Message, patterns ...*regexp.Regexp) (string, bool) {
+	if len(patterns) == 0 {
+		patterns = DefaultOTPPatterns
+	}
+
+	body, mimeType, err := GetBodyPreferred(msg, "text/plain", "text/html")
+	if err != nil {
+		return "", false
+	}
+	if mimeType == "text/html" {
+		body = html.UnescapeString(htmlTags.ReplaceAllString(body, " "))
+	}
+
+	for _, p := range patterns {
+		if m := p.FindStringSubmatch(body); len(m) > 1 {
+			return strings.TrimSpace(m[1]), true
+		}
+	}
+	return "", false
+}
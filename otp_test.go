@@ -0,0 +1,41 @@
+package inboxer
+
+import (
+	"regexp"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestExtractOTP(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name string
+		mime string
+		body string
+		want string
+		ok   bool
+	}{
+		{"colon", "text/plain", "Hi Bob,\nYour verification code is: 482913\nIt expires in 10 minutes.", "482913", true},
+		{"code first", "text/plain", "G-123456 is your Google verification code.", "123456", true},
+		{"otp dash", "text/plain", "Your OTP - 9921. Do not share it with anyone.", "9921", true},
+		{"expiry before code", "text/plain", "This code expires in 15 minutes. Code: 55120873", "55120873", true},
+		{"html", "text/html", "<p>Enter this code to sign in:</p><h1>&nbsp;730104</h1>", "730104", true},
+		{"no code", "text/plain", "Thanks for your order #1234567, it will ship soon.", "", false},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			got, ok := ExtractOTP(newMessage(newPart(test.mime, test.body)))
+			c.Assert(ok, qt.Equals, test.ok)
+			c.Assert(got, qt.Equals, test.want)
+		})
+	}
+
+	c.Run("custom patterns", func(c *qt.C) {
+		msg := newMessage(newPart("text/plain", "Thanks for your order #1234567, it will ship soon."))
+		got, ok := ExtractOTP(msg, regexp.MustCompile(`order #(\d+)`))
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(got, qt.Equals, "1234567")
+	})
+}
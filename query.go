@@ -0,0 +1,24 @@
+package inboxer
+
+import (
+	"strings"
+)
+
+// queryOperators are the words gmail search gives a meaning to.
+var queryOperators = map[string]bool{"OR": true, "AND": true, "AROUND": true}
+
+// EscapeQueryTerm makes term safe to use in a gmail search query, as a
+// single term or after an operator (e.g. "subject:" + EscapeQueryTerm(s)).
+// Terms that contain spaces or characters gmail interprets (quotes,
+// parentheses, braces, colons, leading minus signs...) are quoted. Gmail has
+// no way to escape quotes within a quoted term, so they are replaced by
+// spaces, which gmail ignores like any punctuation when matching words.
+func EscapeQueryTerm(term string) string {
+	if term == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(term, " \t\r\n\"(){}[]:") && !strings.HasPrefix(term, "-") && !strings.HasPrefix(term, "+") && !queryOperators[term] {
+		return term
+	}
+	return `"` + strings.Join(strings.Fields(strings.ReplaceAll(term, `"`, " ")), " ") + `"`
+}
@@ -0,0 +1,30 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestEscapeQueryTerm(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		term, want string
+	}{
+		{"invoice", "invoice"},
+		{"bob@example.com", "bob@example.com"},
+		{"Side Projects", `"Side Projects"`},
+		{`say "hi" now`, `"say hi now"`},
+		{`"quoted"`, `"quoted"`},
+		{"(draft)", `"(draft)"`},
+		{"from:bob", `"from:bob"`},
+		{"-urgent", `"-urgent"`},
+		{"OR", `"OR"`},
+		{"or", "or"},
+		{"{a b}", `"{a b}"`},
+		{"", `""`},
+	} {
+		c.Check(EscapeQueryTerm(test.term), qt.Equals, test.want, qt.Commentf("%q", test.term))
+	}
+}
@@ -0,0 +1,54 @@
+package inboxer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// quotaCosts are the quota units charged by the Gmail API for each method
+// (https://developers.google.com/gmail/api/reference/quota), matched against
+// the request method and its path under users/{userId}. An empty method
+// matches any method; the first matching rule applies.
+var quotaCosts = []struct {
+	method string
+	path   *regexp.Regexp
+	cost   int64
+}{
+	{"POST", regexp.MustCompile(`^(messages|drafts)/send$`), 100},
+	{"POST", regexp.MustCompile(`^watch$`), 100},
+	{"POST", regexp.MustCompile(`^(stop|messages/(batchModify|batchDelete))$`), 50},
+	{"POST", regexp.MustCompile(`^messages/(import|insert)$`), 25},
+	{"GET", regexp.MustCompile(`^messages(/[^/]+(/attachments/[^/]+)?)?$`), 5},
+	{"POST", regexp.MustCompile(`^messages/[^/]+/(modify|trash|untrash)$`), 5},
+	{"DELETE", regexp.MustCompile(`^messages/[^/]+$`), 10},
+	{"GET", regexp.MustCompile(`^threads(/[^/]+)?$`), 10},
+	{"POST", regexp.MustCompile(`^threads/[^/]+/(modify|trash|untrash)$`), 10},
+	{"DELETE", regexp.MustCompile(`^threads/[^/]+$`), 20},
+	{"GET", regexp.MustCompile(`^history$`), 2},
+	{"GET", regexp.MustCompile(`^(profile|labels(/[^/]+)?|settings/.*)$`), 1},
+	{"", regexp.MustCompile(`^(labels|settings)(/.*)?$`), 5},
+}
+
+// defaultQuotaCost is charged for the methods missing from quotaCosts.
+const defaultQuotaCost = 5
+
+// quotaCost returns the quota units charged for the request.
+func quotaCost(method, path string) int64 {
+	// keep the path under users/{userId}, which also handles /upload paths
+	if _, rest, ok := strings.Cut(path, "/users/"); ok {
+		_, path, _ = strings.Cut(rest, "/")
+	}
+	for _, c := range quotaCosts {
+		if (c.method == "" || c.method == method) && c.path.MatchString(path) {
+			return c.cost
+		}
+	}
+	return defaultQuotaCost
+}
+
+// QuotaUnitsUsed returns the Gmail API quota units consumed by the requests
+// made by the Service so far, to help staying under the daily limits. Failed
+// requests are counted too, since they are charged as well.
+func (s *Service) QuotaUnitsUsed() int64 {
+	return s.quota.Load()
+}
@@ -0,0 +1,54 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestQuotaUnitsUsed(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "1"}}}
+	s := newFakeService(t, fake)
+	c.Assert(s.QuotaUnitsUsed(), qt.Equals, int64(0))
+
+	_, err := s.SendMessage(OutgoingMessage{From: "me@example.com", To: []string{"you@example.com"}, Subject: "hi", Body: "hello"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.QuotaUnitsUsed(), qt.Equals, int64(100))
+
+	_, err = s.GetMessage("1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.QuotaUnitsUsed(), qt.Equals, int64(105))
+
+	// failed calls are charged too
+	_, err = s.GetMessage("missing")
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(s.QuotaUnitsUsed(), qt.Equals, int64(110))
+}
+
+func TestQuotaCost(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		method, path string
+		cost         int64
+	}{
+		{"GET", "/gmail/v1/users/me/messages", 5},
+		{"GET", "/gmail/v1/users/me/messages/abc", 5},
+		{"GET", "/gmail/v1/users/me/messages/abc/attachments/def", 5},
+		{"POST", "/gmail/v1/users/me/messages/abc/modify", 5},
+		{"POST", "/gmail/v1/users/me/messages/send", 100},
+		{"POST", "/upload/gmail/v1/users/me/messages/send", 100},
+		{"POST", "/gmail/v1/users/me/messages/batchModify", 50},
+		{"GET", "/gmail/v1/users/me/threads/abc", 10},
+		{"GET", "/gmail/v1/users/me/history", 2},
+		{"GET", "/gmail/v1/users/me/labels", 1},
+		{"PATCH", "/gmail/v1/users/me/labels/Label_1", 5},
+		{"GET", "/gmail/v1/users/me/settings/vacation", 1},
+		{"PUT", "/gmail/v1/users/me/settings/vacation", 5},
+	} {
+		c.Check(quotaCost(test.method, test.path), qt.Equals, test.cost, qt.Commentf("%s %s", test.method, test.path))
+	}
+}
@@ -0,0 +1,284 @@
+package inboxer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"google.golang.org/api/gmail/v1"
+)
+
+// unsafeElements are removed along with their content by SanitizeHTMLBody.
+var unsafeElements = map[string]bool{
+	"script":   true,
+	"iframe":   true,
+	"frame":    true,
+	"frameset": true,
+	"object":   true,
+	"embed":    true,
+	"applet":   true,
+	"form":     true,
+	"base":     true,
+	"meta":     true,
+	"link":     true,
+	// style (and script) elements aren't raw text in foreign content, so
+	// markup hidden in them would be copied unchecked
+	"svg":  true,
+	"math": true,
+}
+
+// urlAttributes are the attributes holding URLs that get checked for unsafe
+// schemes.
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"background": true,
+	"lowsrc":     true,
+	"dynsrc":     true,
+	"xlink:href": true,
+}
+
+// SanitizeHTMLBody makes an html body (see GetBody) safe to display in a web
+// page. It removes scripts, frames, forms and other active content, event
+// handler attributes (onclick...), javascript: links and tracking pixels
+// (images of 1x1 pixels or less), while keeping the formatting of the email.
+func SanitizeHTMLBody(body string) string {
+	var out strings.Builder
+	z := html.NewTokenizer(strings.NewReader(body))
+
+	// skip is the name of the unsafe element being removed, and depth how
+	// deeply it is nested in itself.
+	skip, depth := "", 0
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return out.String()
+		}
+		raw := string(z.Raw())
+		tok := z.Token()
+
+		if skip != "" {
+			switch {
+			case tt == html.StartTagToken && tok.Data == skip:
+				depth++
+			case tt == html.EndTagToken && tok.Data == skip:
+				if depth--; depth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			if inStyle {
+				// css must not be html escaped, but it never needs a <, which
+				// could end the element early
+				out.WriteString(strings.ReplaceAll(raw, "<", `\3c `))
+				continue
+			}
+		case html.CommentToken:
+			// conditional comments can hide markup from the sanitizer
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if unsafeElements[tok.Data] {
+				if tt == html.StartTagToken && !isVoidElement(tok.Data) {
+					skip, depth = tok.Data, 1
+				}
+				continue
+			}
+			if tok.Data == "img" && isTrackingPixel(tok.Attr, 1) {
+				continue
+			}
+			tok.Attr = safeAttributes(tok.Attr)
+			inStyle = tok.Data == "style" && tt == html.StartTagToken
+		case html.EndTagToken:
+			if unsafeElements[tok.Data] {
+				continue
+			}
+			inStyle = false
+		}
+		out.WriteString(tok.String())
+	}
+}
+
+// safeAttributes drops event handlers and attributes holding unsafe URLs.
+func safeAttributes(attrs []html.Attribute) []html.Attribute {
+	safe := attrs[:0]
+	for _, a := range attrs {
+		key := strings.ToLower(a.Key)
+		if strings.HasPrefix(key, "on") || key == "srcdoc" {
+			continue
+		}
+		if urlAttributes[key] && unsafeURL(a.Val) {
+			continue
+		}
+		if key == "style" && unsafeStyle(a.Val) {
+			continue
+		}
+		safe = append(safe, a)
+	}
+	return safe
+}
+
+// unsafeURL reports whether the URL uses a scheme that can run code.
+func unsafeURL(u string) bool {
+	// browsers ignore whitespace and control characters in schemes
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(u))
+	return strings.HasPrefix(u, "javascript:") || strings.HasPrefix(u, "vbscript:") || strings.HasPrefix(u, "data:text/html")
+}
+
+// unsafeStyle reports whether an inline style can run code (old IE
+// expressions) or references a script URL.
+func unsafeStyle(style string) bool {
+	style = strings.ToLower(style)
+	return strings.Contains(style, "expression(") || strings.Contains(style, "javascript:")
+}
+
+func isVoidElement(tag string) bool {
+	switch tag {
+	case "base", "meta", "link", "embed", "img", "br", "hr", "input", "source", "area", "col", "param", "track", "wbr":
+		return true
+	}
+	return false
+}
+
+// isTrackingPixel reports whether the img attributes describe an image that
+// is at most maxSize pixels wide and high, the way tracking pixels are.
+// Images without dimensions are not considered pixels.
+func isTrackingPixel(attrs []html.Attribute, maxSize int) bool {
+	width, height := -1, -1
+	for _, a := range attrs {
+		switch strings.ToLower(a.Key) {
+		case "width":
+			width = parseDimension(a.Val, width)
+		case "height":
+			height = parseDimension(a.Val, height)
+		case "style":
+			for _, decl := range strings.Split(a.Val, ";") {
+				prop, val, ok := strings.Cut(decl, ":")
+				if !ok {
+					continue
+				}
+				val = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), "!important"))
+				switch strings.ToLower(strings.TrimSpace(prop)) {
+				case "width":
+					width = parseDimension(val, width)
+				case "height":
+					height = parseDimension(val, height)
+				}
+			}
+		}
+	}
+	return width >= 0 && height >= 0 && width <= maxSize && height <= maxSize
+}
+
+// parseDimension parses an html width or height ("1", "1px"), returning def
+// when it can't be parsed.
+func parseDimension(v string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(v)), "px"))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// TrackingPixelRules configures how FindTrackingPixels recognizes tracking
+// pixels.
+type TrackingPixelRules struct {
+	// MaxSize is the size in pixels under which (inclusive) an image with
+	// explicit dimensions is considered a pixel, whatever its URL.
+	MaxSize int
+	// Patterns match the URLs of known open tracking endpoints, whatever the
+	// size of the image.
+	Patterns []*regexp.Regexp
+}
+
+// DefaultTrackingPixelRules are the rules used by FindTrackingPixels when none
+// are given.
+var DefaultTrackingPixelRules = TrackingPixelRules{
+	MaxSize: 1,
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)/track/open`),
+		regexp.MustCompile(`(?i)sendgrid\.net/wf/open`),
+		regexp.MustCompile(`(?i)mailtrack\.io/trace`),
+		regexp.MustCompile(`(?i)/(?:open|pixel|beacon)\.(?:gif|png|php|aspx?)\b`),
+	},
+}
+
+// FindTrackingPixels returns the URLs of the images of the html body that look
+// like tracking pixels, in order of appearance. rules replaces
+// DefaultTrackingPixelRules when given. Messages without an html body have no
+// tracking pixels.
+func FindTrackingPixels(msg *gmail.Message, rules ...TrackingPixelRules) ([]string, error) {
+	r := DefaultTrackingPixelRules
+	if len(rules) > 0 {
+		r = rules[0]
+	}
+
+	if !hasPart(msg, "text/html") {
+		return nil, nil
+	}
+	body, err := GetBody(msg, "text/html")
+	if err != nil {
+		return nil, err
+	}
+
+	var pixels []string
+	seen := map[string]bool{}
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return pixels, nil
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tok := z.Token()
+		if tok.Data != "img" {
+			continue
+		}
+
+		src := ""
+		for _, a := range tok.Attr {
+			if strings.ToLower(a.Key) == "src" {
+				src = strings.TrimSpace(a.Val)
+			}
+		}
+		if src == "" || seen[src] {
+			continue
+		}
+		if isTrackingPixel(tok.Attr, r.MaxSize) || matchesAny(src, r.Patterns) {
+			seen[src] = true
+			pixels = append(pixels, src)
+		}
+	}
+}
+
+// hasPart reports whether the message has a part of the given mime type.
+func hasPart(msg *gmail.Message, mimeType string) bool {
+	found := false
+	walkParts(msg.Payload, func(p *gmail.MessagePart) {
+		found = found || p.MimeType == mimeType
+	})
+	return found
+}
+
+func matchesAny(s string, patterns []*regexp.Regexp) bool {
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
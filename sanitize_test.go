@@ -0,0 +1,89 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSanitizeHTMLBody(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{{
+		name: "script",
+		in:   `<p>Hello <b>Bob</b></p><script>alert("hi")</script><SCRIPT src="x.js"></SCRIPT><p>bye</p>`,
+		want: `<p>Hello <b>Bob</b></p><p>bye</p>`,
+	}, {
+		name: "tracking pixel",
+		in:   `<p>Sale!</p><img src="https://t.example.com/open?id=42" width="1" height="1"><img src="https://t.example.com/p.gif" style="width:0px;height:0px"><img src="https://cdn.example.com/banner.png" width="600" height="200">`,
+		want: `<p>Sale!</p><img src="https://cdn.example.com/banner.png" width="600" height="200">`,
+	}, {
+		name: "event handlers and javascript links",
+		in:   `<a href=" javascript:steal()" onclick="steal()" title="x">click</a><div onMouseOver="steal()" style="color:red">red</div>`,
+		want: `<a title="x">click</a><div style="color:red">red</div>`,
+	}, {
+		name: "frames and forms",
+		in:   `<iframe src="https://evil.example.com">fallback</iframe><object data="x.swf"><object data="y.swf"></object>fallback</object><form action="/login"><input name="password"></form><p>ok</p>`,
+		want: `<p>ok</p>`,
+	}, {
+		name: "comments and style",
+		in:   `<style>p > b { color: red }</style><!--[if mso]><script>x()</script><![endif]--><p>text &amp; more</p>`,
+		want: `<style>p > b { color: red }</style><p>text &amp; more</p>`,
+	}, {
+		name: "svg",
+		in:   `<svg><style><img src=x onerror=alert(1)></style></svg><p>ok</p>`,
+		want: `<p>ok</p>`,
+	}, {
+		name: "math",
+		in:   `<math><style><img src=x onerror=alert(1)></style></math><p>ok</p>`,
+		want: `<p>ok</p>`,
+	}, {
+		name: "markup in style",
+		in:   `<style>b { content: "</sty" }<img src=x onerror=alert(1)></style>`,
+		want: `<style>b { content: "\3c /sty" }\3c img src=x onerror=alert(1)></style>`,
+	}}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(SanitizeHTMLBody(test.in), qt.Equals, test.want)
+		})
+	}
+}
+
+func TestFindTrackingPixels(t *testing.T) {
+	c := qt.New(t)
+
+	body := `<p>Hi!</p>
+<img src="https://cdn.example.com/logo.png" width="120" height="40">
+<img src="https://t.example.com/o?u=42" width="1" height="1" alt="">
+<img src="https://u123.ct.sendgrid.net/wf/open?upn=abc">
+<img src="https://cdn.example.com/photo.jpg">`
+	msg := newMessage(newPart("text/plain", "Hi!"), newPart("text/html", body))
+
+	c.Run("default rules", func(c *qt.C) {
+		pixels, err := FindTrackingPixels(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(pixels, qt.DeepEquals, []string{"https://t.example.com/o?u=42", "https://u123.ct.sendgrid.net/wf/open?upn=abc"})
+	})
+
+	c.Run("custom rules", func(c *qt.C) {
+		pixels, err := FindTrackingPixels(msg, TrackingPixelRules{MaxSize: 150})
+		c.Assert(err, qt.IsNil)
+		c.Assert(pixels, qt.DeepEquals, []string{"https://cdn.example.com/logo.png", "https://t.example.com/o?u=42"})
+	})
+
+	c.Run("normal images only", func(c *qt.C) {
+		pixels, err := FindTrackingPixels(newMessage(newPart("text/html", `<img src="https://cdn.example.com/logo.png" width="120" height="40">`)))
+		c.Assert(err, qt.IsNil)
+		c.Assert(pixels, qt.HasLen, 0)
+	})
+
+	c.Run("no html body", func(c *qt.C) {
+		pixels, err := FindTrackingPixels(newMessage(newPart("text/plain", "hi")))
+		c.Assert(err, qt.IsNil)
+		c.Assert(pixels, qt.HasLen, 0)
+	})
+}
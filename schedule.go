@@ -0,0 +1,74 @@
+package inboxer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScheduledSend is a message waiting to be sent by ScheduleSend.
+type ScheduledSend struct {
+	At      time.Time
+	Message OutgoingMessage
+}
+
+// scheduledSends are the sends ScheduleSend is waiting for.
+type scheduledSends struct {
+	mu      sync.Mutex
+	pending map[*ScheduledSend]bool
+}
+
+func (p *scheduledSends) add(send *ScheduledSend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending == nil {
+		p.pending = map[*ScheduledSend]bool{}
+	}
+	p.pending[send] = true
+}
+
+func (p *scheduledSends) remove(send *ScheduledSend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, send)
+}
+
+// after waits for the duration to elapse. Tests replace it to control time.
+var after = time.After
+
+// ScheduleSend sends the message at the given time, blocking until then (a
+// time in the past sends it right away). It returns ctx.Err() without sending
+// when ctx is done first. The gmail API can't schedule sends: the message is
+// only kept in the process until it is sent, see PendingSends, and is lost if
+// the process stops before.
+func (s *Service) ScheduleSend(ctx context.Context, at time.Time, msg OutgoingMessage) error {
+	send := &ScheduledSend{At: at, Message: msg}
+	s.scheduled.add(send)
+	defer s.scheduled.remove(send)
+
+	if d := at.Sub(now()); d > 0 {
+		select {
+		case <-after(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	_, err := s.SendMessage(msg)
+	return err
+}
+
+// PendingSends returns the messages ScheduleSend is waiting to send, the
+// earliest first.
+func (s *Service) PendingSends() []ScheduledSend {
+	s.scheduled.mu.Lock()
+	defer s.scheduled.mu.Unlock()
+	var sends []ScheduledSend
+	for send := range s.scheduled.pending {
+		sends = append(sends, *send)
+	}
+	sort.SliceStable(sends, func(i, j int) bool {
+		return sends[i].At.Before(sends[j].At)
+	})
+	return sends
+}
@@ -0,0 +1,59 @@
+package inboxer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestScheduleSend(t *testing.T) {
+	c := qt.New(t)
+
+	current := time.Date(2023, 3, 6, 8, 59, 0, 0, time.UTC)
+	c.Patch(&now, func() time.Time { return current })
+	waits := make(chan time.Duration, 1)
+	fire := make(chan time.Time)
+	c.Patch(&after, func(d time.Duration) <-chan time.Time {
+		waits <- d
+		return fire
+	})
+
+	fake := &fakeGmail{}
+	s := newFakeService(t, fake)
+	msg := OutgoingMessage{To: []string{"bob@example.com"}, Subject: "Standup"}
+	at := current.Add(time.Minute)
+
+	c.Run("sent at the given time", func(c *qt.C) {
+		done := make(chan error)
+		go func() { done <- s.ScheduleSend(context.Background(), at, msg) }()
+
+		c.Assert(<-waits, qt.Equals, time.Minute)
+		c.Assert(s.PendingSends(), qt.DeepEquals, []ScheduledSend{{At: at, Message: msg}})
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 0)
+
+		fire <- at
+		c.Assert(<-done, qt.IsNil)
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 1)
+		c.Assert(s.PendingSends(), qt.HasLen, 0)
+	})
+
+	c.Run("cancelled", func(c *qt.C) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- s.ScheduleSend(ctx, at, msg) }()
+
+		<-waits
+		cancel()
+		c.Assert(<-done, qt.ErrorIs, context.Canceled)
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 1)
+		c.Assert(s.PendingSends(), qt.HasLen, 0)
+	})
+
+	c.Run("in the past", func(c *qt.C) {
+		c.Assert(s.ScheduleSend(context.Background(), current.Add(-time.Hour), msg), qt.IsNil)
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 2)
+		c.Assert(waits, qt.HasLen, 0)
+	})
+}
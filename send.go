@@ -0,0 +1,308 @@
+package inboxer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// OutgoingMessage is an email to be sent with SendMessage.
+type OutgoingMessage struct {
+	// From defaults to the account's primary address when empty.
+	From    string
+	To      []string
+	Cc      []string
+	Bcc     []string
+	Subject string
+	// Body is sent as text/plain, or text/html when HTML is set.
+	Body string
+	HTML bool
+	// MessageID is the Message-ID header of the message, e.g.
+	// "<order-42@example.com>". One is generated when empty. Reusing the same
+	// ID when retrying a send lets SendMessage detect duplicates (see
+	// Service.DedupeWindow).
+	MessageID string
+	// Headers are added to the message, e.g. an X-Tracking-Id to find it
+	// later with FindByHeader. The headers set from the other fields take
+	// precedence.
+	Headers map[string]string
+	// AppendSignature appends the signature of the From address (see
+	// GetSignature) to the body, the way the gmail web interface does.
+	AppendSignature bool
+	// RequestReadReceipt asks the recipients' clients to notify the From
+	// address (the primary address when From is empty) once the message is
+	// read, with a Disposition-Notification-To header (RFC 8098). Clients are
+	// free to ignore it.
+	RequestReadReceipt bool
+	// AllowManyRecipients sends the message whatever Service.MaxRecipients
+	// is.
+	AllowManyRecipients bool
+}
+
+// ErrTooManyRecipients is returned (wrapped) when sending a message to more
+// recipients than Service.MaxRecipients.
+var ErrTooManyRecipients = errors.New("too many recipients")
+
+// checkRecipients checks that the address lists hold no more recipients than
+// s.MaxRecipients, unless allowed.
+func (s *Service) checkRecipients(allow bool, lists ...[]string) error {
+	if allow || s.MaxRecipients <= 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, v := range list {
+			// an entry can hold several addresses
+			for _, a := range parseAddressList(v) {
+				seen[strings.ToLower(a.Address)] = true
+			}
+		}
+	}
+	if len(seen) > s.MaxRecipients {
+		return fmt.Errorf("%w: %d, the maximum is %d", ErrTooManyRecipients, len(seen), s.MaxRecipients)
+	}
+	return nil
+}
+
+// builder returns a MessageBuilder for the message.
+func (m OutgoingMessage) builder(messageID string) *MessageBuilder {
+	b := NewMessageBuilder()
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.SetHeader(name, m.Headers[name])
+	}
+
+	if m.From != "" {
+		b.SetHeader("From", m.From)
+	}
+	for _, h := range []struct {
+		name  string
+		addrs []string
+	}{{"To", m.To}, {"Cc", m.Cc}, {"Bcc", m.Bcc}} {
+		if len(h.addrs) > 0 {
+			b.SetHeader(h.name, strings.Join(h.addrs, ", "))
+		}
+	}
+	b.SetHeader("Subject", m.Subject)
+	b.SetHeader("Message-ID", messageID)
+
+	contentType := "text/plain"
+	if m.HTML {
+		contentType = "text/html"
+	}
+	return b.SetBody(contentType, m.Body)
+}
+
+// sendEntry is a send made with a caller supplied Message-ID.
+type sendEntry struct {
+	done chan struct{}
+	msg  *gmail.Message
+	err  error
+	at   time.Time
+}
+
+// sendCache remembers recent sends by Message-ID.
+type sendCache struct {
+	mu      sync.Mutex
+	entries map[string]*sendEntry
+}
+
+// SendMessage sends the message.
+//
+// When s.DedupeWindow is set and the message has a MessageID, sending a
+// message with the same ID again within the window returns the message sent
+// the first time without calling the API, so retries can't send duplicates.
+// This only works within the process: gmail itself doesn't deduplicate
+// messages, so a send retried after a restart is sent again.
+func (s *Service) SendMessage(msg OutgoingMessage) (*gmail.Message, error) {
+	if msg.MessageID == "" || s.DedupeWindow <= 0 {
+		return s.sendMessage(msg)
+	}
+
+	s.sent.mu.Lock()
+	if s.sent.entries == nil {
+		s.sent.entries = map[string]*sendEntry{}
+	}
+	for id, e := range s.sent.entries {
+		if !e.at.IsZero() && time.Since(e.at) > s.DedupeWindow {
+			delete(s.sent.entries, id)
+		}
+	}
+	e, ok := s.sent.entries[msg.MessageID]
+	if !ok {
+		e = &sendEntry{done: make(chan struct{})}
+		s.sent.entries[msg.MessageID] = e
+	}
+	s.sent.mu.Unlock()
+
+	if ok {
+		// an identical send is in flight or was made recently
+		<-e.done
+		if e.err == nil {
+			return e.msg, nil
+		}
+		return s.SendMessage(msg)
+	}
+
+	e.msg, e.err = s.sendMessage(msg)
+	s.sent.mu.Lock()
+	if e.err != nil {
+		delete(s.sent.entries, msg.MessageID)
+	} else {
+		e.at = time.Now()
+	}
+	s.sent.mu.Unlock()
+	close(e.done)
+	return e.msg, e.err
+}
+
+// sendMessage builds and sends the message.
+func (s *Service) sendMessage(msg OutgoingMessage) (*gmail.Message, error) {
+	if err := s.checkWritable("send messages"); err != nil {
+		return nil, err
+	}
+	if err := s.checkRecipients(msg.AllowManyRecipients, msg.To, msg.Cc, msg.Bcc); err != nil {
+		return nil, err
+	}
+	messageID := msg.MessageID
+	if messageID == "" {
+		messageID = newMessageID(msg.From)
+	}
+	if msg.AppendSignature {
+		sig, err := s.GetSignature(msg.From)
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = appendSignature(msg.Body, sig, msg.HTML)
+	}
+	if msg.RequestReadReceipt {
+		to := parseAddress(msg.From).Address
+		if to == "" {
+			err := s.call(func(ctx context.Context) error {
+				primary, err := s.primarySendAs(ctx)
+				if err == nil {
+					to = primary.SendAsEmail
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		headers := map[string]string{}
+		for name, value := range msg.Headers {
+			headers[name] = value
+		}
+		headers["Disposition-Notification-To"] = to
+		msg.Headers = headers
+	}
+	raw, err := msg.builder(messageID).BuildBase64()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Messages.Send("me", &gmail.Message{Raw: raw}).Context(ctx).Do()
+}
+
+// SendBulk sends the message to many recipients, e.g. a newsletter, as
+// separate messages to batches of up to batchSize recipients (gmail caps the
+// recipients of a message, at about 100), returning the messages sent. The
+// recipients (To, Cc and Bcc, each address once) get the message in Bcc,
+// hiding them from each other. The sends are made one after another, within
+// the limits of s.Limiter. When a send fails, the messages already sent are
+// returned along with the error. Batches larger than s.MaxRecipients fail with
+// ErrTooManyRecipients before anything is sent, unless msg.AllowManyRecipients
+// is set.
+//
+// Each batch gets its own Message-ID, derived from msg.MessageID when set
+// ("<news-42@example.com>" becomes "<news-42.2@example.com>" for the second
+// batch), so a retried SendBulk doesn't send the batches twice (see
+// Service.DedupeWindow).
+func (s *Service) SendBulk(msg OutgoingMessage, batchSize int) ([]*gmail.Message, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size %d", batchSize)
+	}
+	var recipients []string
+	seen := map[string]bool{}
+	for _, list := range [][]string{msg.To, msg.Cc, msg.Bcc} {
+		for _, v := range list {
+			for _, a := range parseAddressList(v) {
+				if key := strings.ToLower(a.Address); !seen[key] {
+					seen[key] = true
+					recipients = append(recipients, a.String())
+				}
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("message has no recipients")
+	}
+
+	batches := chunks(recipients, batchSize)
+	// checked up front, rather than failing every batch
+	if largest := len(batches[0]); !msg.AllowManyRecipients && s.MaxRecipients > 0 && largest > s.MaxRecipients {
+		return nil, fmt.Errorf("%w: batches of %d recipients, the maximum is %d", ErrTooManyRecipients, largest, s.MaxRecipients)
+	}
+	sent := make([]*gmail.Message, 0, len(batches))
+	for i, batch := range batches {
+		m := msg
+		m.To, m.Cc, m.Bcc = nil, nil, batch
+		m.MessageID = batchMessageID(msg.MessageID, i)
+		res, err := s.SendMessage(m)
+		if err != nil {
+			return sent, fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err)
+		}
+		sent = append(sent, res)
+	}
+	return sent, nil
+}
+
+// batchMessageID returns the Message-ID of the ith batch (from 0) of a bulk
+// send of the message with the given ID, or "" to generate one.
+func batchMessageID(id string, i int) string {
+	if id == "" || i == 0 {
+		return id
+	}
+	local, domain, ok := strings.Cut(strings.Trim(id, "<>"), "@")
+	if !ok {
+		return fmt.Sprintf("<%s.%d>", local, i+1)
+	}
+	return fmt.Sprintf("<%s.%d@%s>", local, i+1, domain)
+}
+
+// appendSignature appends the html signature to the body, converted to text
+// unless the body is html, below a signature delimiter.
+func appendSignature(body, sig string, html bool) string {
+	if sig == "" {
+		return body
+	}
+	if html {
+		return body + "<br><br>-- <br>" + sig
+	}
+	return body + "\n\n-- \n" + htmlToText(sig)
+}
+
+// newMessageID generates a unique Message-ID using the domain of from.
+func newMessageID(from string) string {
+	domain := domainOf(parseAddress(from).Address)
+	if domain == "" {
+		domain = "inboxer.local"
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
@@ -0,0 +1,236 @@
+package inboxer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// sentMessage parses a message recorded by the fake.
+func sentMessage(c *qt.C, msg *gmail.Message) *mail.Message {
+	raw, err := decodeBase64URL(msg.Raw)
+	c.Assert(err, qt.IsNil)
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	c.Assert(err, qt.IsNil)
+	return m
+}
+
+func TestSendMessage(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	_, err := newFakeService(t, fake).SendMessage(OutgoingMessage{
+		From:    "Me <me@example.com>",
+		To:      []string{"bob@example.com", "Carol <carol@example.com>"},
+		Subject: "Hello",
+		Body:    "Hi!",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.sent, qt.HasLen, 1)
+
+	m := sentMessage(c, fake.sent[0])
+	c.Assert(m.Header.Get("Subject"), qt.Equals, "Hello")
+	to, err := m.Header.AddressList("To")
+	c.Assert(err, qt.IsNil)
+	c.Assert(to, qt.HasLen, 2)
+	c.Assert(m.Header.Get("Message-Id"), qt.Matches, `<[0-9a-f]{32}@example\.com>`)
+}
+
+func TestSendMessageSignature(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	fake.addToCollection("sendAs", &gmail.SendAs{SendAsEmail: "me@example.com", IsPrimary: true, Signature: "<b>Me</b><br>ACME Inc."})
+	s := newFakeService(t, fake)
+
+	msg := OutgoingMessage{To: []string{"bob@example.com"}, Subject: "Hello", Body: "Hi!", AppendSignature: true}
+	_, err := s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+	msg.HTML = true
+	_, err = s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+
+	var bodies []string
+	for _, sent := range fake.sent {
+		body, err := io.ReadAll(quotedprintable.NewReader(sentMessage(c, sent).Body))
+		c.Assert(err, qt.IsNil)
+		bodies = append(bodies, string(body))
+	}
+	c.Assert(bodies, qt.DeepEquals, []string{
+		"Hi!\r\n\r\n-- \r\nMe\r\nACME Inc.",
+		"Hi!<br><br>-- <br><b>Me</b><br>ACME Inc.",
+	})
+}
+
+func TestSendMessageReadReceipt(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	fake.addToCollection("sendAs", &gmail.SendAs{SendAsEmail: "me@example.com", IsPrimary: true})
+	s := newFakeService(t, fake)
+
+	msg := OutgoingMessage{From: "Alias <alias@example.com>", To: []string{"bob@example.com"}, Subject: "Contract", RequestReadReceipt: true}
+	_, err := s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+	msg.From = ""
+	_, err = s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+	msg.RequestReadReceipt = false
+	_, err = s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(fake.sent, qt.HasLen, 3)
+	c.Assert(sentMessage(c, fake.sent[0]).Header.Get("Disposition-Notification-To"), qt.Equals, "alias@example.com")
+	c.Assert(sentMessage(c, fake.sent[1]).Header.Get("Disposition-Notification-To"), qt.Equals, "me@example.com")
+	c.Assert(sentMessage(c, fake.sent[2]).Header.Get("Disposition-Notification-To"), qt.Equals, "")
+}
+
+func TestSendMessageTooManyRecipients(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	s := newFakeService(t, fake)
+	s.MaxRecipients = 3
+
+	msg := OutgoingMessage{
+		To:      []string{"a@example.com", "b@example.com, C <c@example.com>"},
+		Cc:      []string{"d@example.com"},
+		Subject: "All hands",
+		Body:    "Hi all",
+	}
+	_, err := s.SendMessage(msg)
+	c.Assert(errors.Is(err, ErrTooManyRecipients), qt.IsTrue)
+	c.Assert(err, qt.ErrorMatches, "too many recipients: 4, the maximum is 3")
+	c.Assert(fake.sent, qt.HasLen, 0)
+
+	// the same address counts once
+	msg.Cc = []string{"A@example.com"}
+	_, err = s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+
+	msg.Bcc = []string{"e@example.com"}
+	msg.AllowManyRecipients = true
+	_, err = s.SendMessage(msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.sent, qt.HasLen, 2)
+}
+
+func TestSendBulk(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	limiter := &countingLimiter{}
+	s := newFakeService(t, fake)
+	s.Limiter = limiter
+
+	msg := OutgoingMessage{From: "News <news@example.com>", Subject: "Newsletter", Body: "News!", MessageID: "<news-42@example.com>"}
+	for i := 0; i < 250; i++ {
+		msg.To = append(msg.To, fmt.Sprintf("reader%d@example.org", i))
+	}
+	// already a recipient
+	msg.Cc = []string{"Reader0@example.org"}
+	sent, err := s.SendBulk(msg, 100)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sent, qt.HasLen, 3)
+	c.Assert(fake.sent, qt.HasLen, 3)
+	c.Assert(limiter.waits, qt.Equals, 3)
+
+	var sizes, messageIDs []string
+	for _, m := range fake.sent {
+		h := sentMessage(c, m).Header
+		c.Assert(h.Get("To"), qt.Equals, "")
+		c.Assert(h.Get("Cc"), qt.Equals, "")
+		bcc, err := h.AddressList("Bcc")
+		c.Assert(err, qt.IsNil)
+		sizes = append(sizes, fmt.Sprint(len(bcc)))
+		messageIDs = append(messageIDs, h.Get("Message-Id"))
+	}
+	c.Assert(sizes, qt.DeepEquals, []string{"100", "100", "50"})
+	c.Assert(messageIDs, qt.DeepEquals, []string{"<news-42@example.com>", "<news-42.2@example.com>", "<news-42.3@example.com>"})
+
+	_, err = s.SendBulk(OutgoingMessage{Subject: "Nobody"}, 100)
+	c.Assert(err, qt.ErrorMatches, "message has no recipients")
+
+	// batches must fit in MaxRecipients
+	s.MaxRecipients = 50
+	_, err = s.SendBulk(msg, 100)
+	c.Assert(err, qt.ErrorIs, ErrTooManyRecipients)
+	c.Assert(err, qt.ErrorMatches, ".*: batches of 100 recipients, the maximum is 50")
+	c.Assert(fake.sent, qt.HasLen, 3)
+	sent, err = s.SendBulk(msg, 50)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sent, qt.HasLen, 5)
+	msg.AllowManyRecipients = true
+	sent, err = s.SendBulk(msg, 100)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sent, qt.HasLen, 3)
+}
+
+func TestSendMessageIdempotency(t *testing.T) {
+	c := qt.New(t)
+
+	msg := OutgoingMessage{To: []string{"bob@example.com"}, Subject: "Invoice", Body: "attached", MessageID: "<invoice-42@example.com>"}
+
+	c.Run("same key", func(c *qt.C) {
+		fake := &fakeGmail{}
+		service := newFakeService(t, fake)
+		service.DedupeWindow = time.Minute
+
+		var wg sync.WaitGroup
+		results := make([]*gmail.Message, 3)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				sent, err := service.SendMessage(msg)
+				c.Check(err, qt.IsNil)
+				results[i] = sent
+			}(i)
+		}
+		wg.Wait()
+
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 1)
+		c.Assert(results[1].Id, qt.Equals, results[0].Id)
+		c.Assert(sentMessage(c, fake.sent[0]).Header.Get("Message-Id"), qt.Equals, "<invoice-42@example.com>")
+
+		other := msg
+		other.MessageID = "<invoice-43@example.com>"
+		_, err := service.SendMessage(other)
+		c.Assert(err, qt.IsNil)
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 2)
+	})
+
+	c.Run("disabled", func(c *qt.C) {
+		fake := &fakeGmail{}
+		service := newFakeService(t, fake)
+		for i := 0; i < 2; i++ {
+			_, err := service.SendMessage(msg)
+			c.Assert(err, qt.IsNil)
+		}
+		c.Assert(fake.callCount("POST /messages/send"), qt.Equals, 2)
+	})
+
+	c.Run("failed sends are retried", func(c *qt.C) {
+		fake := &fakeGmail{fail: map[string]int{"/messages/send": 503}}
+		service := newFakeService(t, fake)
+		service.DedupeWindow = time.Minute
+		_, err := service.SendMessage(msg)
+		c.Assert(err, qt.Not(qt.IsNil))
+
+		fake.mu.Lock()
+		fake.fail = nil
+		fake.mu.Unlock()
+		_, err = service.SendMessage(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(fake.sent, qt.HasLen, 1)
+	})
+}
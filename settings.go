@@ -0,0 +1,512 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// ListDelegates lists the delegates of the account. Delegates are only
+// available to Google Workspace accounts.
+func (s *Service) ListDelegates() ([]*gmail.Delegate, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	res, err := s.GmailSvc.Users.Settings.Delegates.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return res.Delegates, nil
+}
+
+// CreateDelegate grants email access to the mailbox. Depending on the domain
+// settings the delegate may have to accept the invitation first, in which
+// case the returned delegate has a "pending" VerificationStatus until then.
+// This requires a service account with domain-wide authority.
+func (s *Service) CreateDelegate(email string) (*gmail.Delegate, error) {
+	if err := s.checkWritable("change settings"); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Settings.Delegates.Create("me", &gmail.Delegate{DelegateEmail: email}).Context(ctx).Do()
+}
+
+// DeleteDelegate revokes the access granted to email.
+func (s *Service) DeleteDelegate(email string) error {
+	if err := s.checkWritable("change settings"); err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Settings.Delegates.Delete("me", email).Context(ctx).Do()
+}
+
+// ListForwardingAddresses lists the addresses mail can be forwarded to.
+func (s *Service) ListForwardingAddresses() ([]*gmail.ForwardingAddress, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	res, err := s.GmailSvc.Users.Settings.ForwardingAddresses.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return res.ForwardingAddresses, nil
+}
+
+// GetAutoForwarding returns the auto-forwarding setting of the account, which
+// forwards every incoming message (unlike filters forwarding some of them).
+func (s *Service) GetAutoForwarding() (*gmail.AutoForwarding, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Settings.GetAutoForwarding("me").Context(ctx).Do()
+}
+
+// UpdateAutoForwarding updates the auto-forwarding setting of the account.
+// The EmailAddress must be a verified forwarding address. This requires a
+// service account with domain-wide authority.
+func (s *Service) UpdateAutoForwarding(settings *gmail.AutoForwarding) (*gmail.AutoForwarding, error) {
+	if err := s.checkWritable("change settings"); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Settings.UpdateAutoForwarding("me", settings).Context(ctx).Do()
+}
+
+// EnableAutoForward forwards every incoming message to toAddress, which must
+// be a forwarding address (see ListForwardingAddresses) the recipient has
+// already verified; an error is returned otherwise. disposition is what
+// happens to the original messages: "leaveInInbox", "archive", "trash" or
+// "markRead".
+func (s *Service) EnableAutoForward(toAddress, disposition string) (*gmail.AutoForwarding, error) {
+	addrs, err := s.ListForwardingAddresses()
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, a := range addrs {
+		verified = verified || (strings.EqualFold(a.ForwardingEmail, toAddress) && a.VerificationStatus == "accepted")
+	}
+	if !verified {
+		return nil, fmt.Errorf("%s is not a verified forwarding address", toAddress)
+	}
+
+	return s.UpdateAutoForwarding(&gmail.AutoForwarding{
+		Enabled:      true,
+		EmailAddress: toAddress,
+		Disposition:  disposition,
+	})
+}
+
+// ErrUnsupported is returned for the settings the gmail API doesn't expose,
+// which can only be changed in the gmail web interface.
+var ErrUnsupported = errors.New("not supported by the gmail API")
+
+// ImportanceSettings is what the gmail API tells about importance markers.
+type ImportanceSettings struct {
+	// Messages and Unread count the messages marked important, that is with
+	// the IMPORTANT label.
+	Messages, Unread int64
+	// AlwaysImportant are the filters marking messages as important, and
+	// NeverImportant the ones preventing them from being marked important.
+	AlwaysImportant, NeverImportant []*gmail.Filter
+}
+
+// GetImportanceSettings returns the importance related configuration the
+// gmail API exposes: the IMPORTANT label and the filters adding or removing
+// it. Whether gmail marks messages as important automatically is not exposed
+// (see ImportanceMarkersEnabled).
+func (s *Service) GetImportanceSettings() (*ImportanceSettings, error) {
+	res := &ImportanceSettings{}
+	err := s.call(func(ctx context.Context) error {
+		label, err := s.GmailSvc.Users.Labels.Get("me", "IMPORTANT").Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		res.Messages, res.Unread = label.MessagesTotal, label.MessagesUnread
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.call(func(ctx context.Context) error {
+		list, err := s.GmailSvc.Users.Settings.Filters.List("me").Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		for _, f := range list.Filter {
+			if f.Action == nil {
+				continue
+			}
+			if contains(f.Action.AddLabelIds, "IMPORTANT") {
+				res.AlwaysImportant = append(res.AlwaysImportant, f)
+			}
+			if contains(f.Action.RemoveLabelIds, "IMPORTANT") {
+				res.NeverImportant = append(res.NeverImportant, f)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ImportanceMarkersEnabled would report whether gmail marks messages as
+// important automatically, but the gmail API doesn't expose this setting: it
+// always returns ErrUnsupported.
+func (s *Service) ImportanceMarkersEnabled() (bool, error) {
+	return false, ErrUnsupported
+}
+
+// SetImportanceMarkers would turn the automatic importance markers on or off,
+// but the gmail API doesn't expose this setting: it always returns
+// ErrUnsupported. Filters can still mark messages as important, or never as
+// important, by adding or removing the IMPORTANT label.
+func (s *Service) SetImportanceMarkers(enabled bool) error {
+	return ErrUnsupported
+}
+
+// Settings is a snapshot of the configuration of an account, as returned by
+// ExportSettings. It can be serialized to JSON and applied to another account
+// with ImportSettings. Labels holds the user labels the filters use: their
+// IDs differ from an account to another, so ImportSettings matches them by
+// name.
+type Settings struct {
+	Vacation            *gmail.VacationSettings    `json:"vacation,omitempty"`
+	IMAP                *gmail.ImapSettings        `json:"imap,omitempty"`
+	POP                 *gmail.PopSettings         `json:"pop,omitempty"`
+	Language            *gmail.LanguageSettings    `json:"language,omitempty"`
+	AutoForwarding      *gmail.AutoForwarding      `json:"autoForwarding,omitempty"`
+	ForwardingAddresses []*gmail.ForwardingAddress `json:"forwardingAddresses,omitempty"`
+	Filters             []*gmail.Filter            `json:"filters,omitempty"`
+	Labels              []*gmail.Label             `json:"labels,omitempty"`
+	SendAs              []*gmail.SendAs            `json:"sendAs,omitempty"`
+}
+
+// ExportSettings returns the vacation responder, IMAP, POP, language,
+// forwarding, filters (with the labels they use) and send-as configuration
+// of the account.
+func (s *Service) ExportSettings() (*Settings, error) {
+	settings := s.GmailSvc.Users.Settings
+	res := &Settings{}
+
+	steps := []struct {
+		what string
+		call func(ctx context.Context) error
+	}{
+		{"vacation", func(ctx context.Context) (err error) {
+			res.Vacation, err = settings.GetVacation("me").Context(ctx).Do()
+			return err
+		}},
+		{"imap", func(ctx context.Context) (err error) {
+			res.IMAP, err = settings.GetImap("me").Context(ctx).Do()
+			return err
+		}},
+		{"pop", func(ctx context.Context) (err error) {
+			res.POP, err = settings.GetPop("me").Context(ctx).Do()
+			return err
+		}},
+		{"language", func(ctx context.Context) (err error) {
+			res.Language, err = settings.GetLanguage("me").Context(ctx).Do()
+			return err
+		}},
+		{"auto-forwarding", func(ctx context.Context) (err error) {
+			res.AutoForwarding, err = settings.GetAutoForwarding("me").Context(ctx).Do()
+			return err
+		}},
+		{"forwarding addresses", func(ctx context.Context) error {
+			list, err := settings.ForwardingAddresses.List("me").Context(ctx).Do()
+			if err == nil {
+				res.ForwardingAddresses = list.ForwardingAddresses
+			}
+			return err
+		}},
+		{"filters", func(ctx context.Context) error {
+			list, err := settings.Filters.List("me").Context(ctx).Do()
+			if err == nil {
+				res.Filters = list.Filter
+			}
+			return err
+		}},
+		{"labels", func(ctx context.Context) error {
+			used := map[string]bool{}
+			for _, f := range res.Filters {
+				if f.Action != nil {
+					for _, id := range append(f.Action.AddLabelIds, f.Action.RemoveLabelIds...) {
+						used[id] = true
+					}
+				}
+			}
+			if len(used) == 0 {
+				return nil
+			}
+			list, err := s.GmailSvc.Users.Labels.List("me").Context(ctx).Do()
+			if err != nil {
+				return err
+			}
+			res.Labels = nil
+			for _, l := range list.Labels {
+				if l.Type == "user" && used[l.Id] {
+					res.Labels = append(res.Labels, l)
+				}
+			}
+			return nil
+		}},
+		{"send-as", func(ctx context.Context) error {
+			list, err := settings.SendAs.List("me").Context(ctx).Do()
+			if err == nil {
+				res.SendAs = list.SendAs
+			}
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if err := s.call(step.call); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.what, err)
+		}
+	}
+	return res, nil
+}
+
+// ImportSettings applies the settings to the account. Forwarding addresses,
+// filters and send-as aliases are created; the primary send-as address only
+// gets its display name, reply-to and signature updated. Nil fields are left
+// untouched. Filters use the labels of the account named like the exported
+// ones, which are created when missing; a filter using a user label missing
+// from settings.Labels is not created.
+//
+// Every setting is applied even if some fail, in which case the returned
+// error joins all the failures. Note that new forwarding addresses and
+// send-as aliases usually have to be verified before they can be used, and
+// creating them requires domain-wide authority.
+func (s *Service) ImportSettings(settings *Settings) error {
+	if err := s.checkWritable("change settings"); err != nil {
+		return err
+	}
+	svc := s.GmailSvc.Users.Settings
+
+	var errs []error
+	apply := func(what string, call func(ctx context.Context) error) {
+		if err := s.call(call); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", what, err))
+		}
+	}
+
+	if settings.Vacation != nil {
+		apply("vacation", func(ctx context.Context) error {
+			_, err := svc.UpdateVacation("me", settings.Vacation).Context(ctx).Do()
+			return err
+		})
+	}
+	if settings.IMAP != nil {
+		apply("imap", func(ctx context.Context) error {
+			_, err := svc.UpdateImap("me", settings.IMAP).Context(ctx).Do()
+			return err
+		})
+	}
+	if settings.POP != nil {
+		apply("pop", func(ctx context.Context) error {
+			_, err := svc.UpdatePop("me", settings.POP).Context(ctx).Do()
+			return err
+		})
+	}
+	if settings.Language != nil {
+		apply("language", func(ctx context.Context) error {
+			_, err := svc.UpdateLanguage("me", settings.Language).Context(ctx).Do()
+			return err
+		})
+	}
+	// forwarding addresses must exist before they are used by auto-forwarding
+	// and filters
+	for _, f := range settings.ForwardingAddresses {
+		f := f
+		apply("forwarding address "+f.ForwardingEmail, func(ctx context.Context) error {
+			_, err := svc.ForwardingAddresses.Create("me", &gmail.ForwardingAddress{ForwardingEmail: f.ForwardingEmail}).Context(ctx).Do()
+			return err
+		})
+	}
+	if settings.AutoForwarding != nil {
+		apply("auto-forwarding", func(ctx context.Context) error {
+			_, err := svc.UpdateAutoForwarding("me", settings.AutoForwarding).Context(ctx).Do()
+			return err
+		})
+	}
+	var labelIDs map[string]string
+	if len(settings.Labels) > 0 {
+		apply("labels", func(ctx context.Context) (err error) {
+			labelIDs, err = s.importLabels(ctx, settings.Labels)
+			return err
+		})
+	}
+	for _, f := range settings.Filters {
+		f := f
+		apply("filter "+f.Id, func(ctx context.Context) error {
+			action, err := filterAction(f.Action, labelIDs)
+			if err != nil {
+				return err
+			}
+			_, err = svc.Filters.Create("me", &gmail.Filter{Action: action, Criteria: f.Criteria}).Context(ctx).Do()
+			return err
+		})
+	}
+	for _, a := range settings.SendAs {
+		a := a
+		if a.IsPrimary {
+			apply("primary send-as", func(ctx context.Context) error {
+				primary, err := s.primarySendAs(ctx)
+				if err != nil {
+					return err
+				}
+				primary.DisplayName, primary.ReplyToAddress, primary.Signature = a.DisplayName, a.ReplyToAddress, a.Signature
+				_, err = svc.SendAs.Update("me", primary.SendAsEmail, primary).Context(ctx).Do()
+				return err
+			})
+			continue
+		}
+		apply("send-as "+a.SendAsEmail, func(ctx context.Context) error {
+			alias := *a
+			alias.VerificationStatus, alias.IsDefault = "", false
+			_, err := svc.SendAs.Create("me", &alias).Context(ctx).Do()
+			return err
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// importLabels returns the IDs of the labels of the account named like labels,
+// by the IDs of labels, creating the missing ones.
+func (s *Service) importLabels(ctx context.Context, labels []*gmail.Label) (map[string]string, error) {
+	list, err := s.GmailSvc.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string, len(list.Labels))
+	for _, l := range list.Labels {
+		byName[strings.ToLower(l.Name)] = l.Id
+	}
+
+	ids := make(map[string]string, len(labels))
+	for _, l := range labels {
+		id, ok := byName[strings.ToLower(l.Name)]
+		if !ok {
+			created, err := s.GmailSvc.Users.Labels.Create("me", &gmail.Label{
+				Name:                  l.Name,
+				LabelListVisibility:   l.LabelListVisibility,
+				MessageListVisibility: l.MessageListVisibility,
+				Color:                 l.Color,
+			}).Context(ctx).Do()
+			if err != nil {
+				return ids, fmt.Errorf("label %s: %w", l.Name, err)
+			}
+			id = created.Id
+			byName[strings.ToLower(l.Name)] = id
+		}
+		ids[l.Id] = id
+	}
+	return ids, nil
+}
+
+// filterAction returns action with the exported label IDs replaced by the
+// ones in labelIDs. User label IDs missing from labelIDs are an error, as
+// they would be another label, or none, in this account.
+func filterAction(action *gmail.FilterAction, labelIDs map[string]string) (*gmail.FilterAction, error) {
+	if action == nil {
+		return nil, nil
+	}
+	mapIDs := func(ids []string) ([]string, error) {
+		var out []string
+		for _, id := range ids {
+			if mapped, ok := labelIDs[id]; ok {
+				id = mapped
+			} else if strings.HasPrefix(id, "Label_") {
+				return nil, fmt.Errorf("unknown label %s", id)
+			}
+			out = append(out, id)
+		}
+		return out, nil
+	}
+
+	res := *action
+	var err error
+	if res.AddLabelIds, err = mapIDs(action.AddLabelIds); err != nil {
+		return nil, err
+	}
+	if res.RemoveLabelIds, err = mapIDs(action.RemoveLabelIds); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// signatureCache holds the signatures of the send-as addresses by address,
+// the primary one being under "".
+type signatureCache struct {
+	mu         sync.Mutex
+	signatures map[string]string
+}
+
+// GetSignature returns the signature (html) of the send-as address, or of the
+// primary address when sendAsEmail is empty, as set in the gmail settings.
+// Signatures are fetched once per Service, then cached.
+func (s *Service) GetSignature(sendAsEmail string) (string, error) {
+	key := strings.ToLower(parseAddress(sendAsEmail).Address)
+	s.signatures.mu.Lock()
+	defer s.signatures.mu.Unlock()
+	if sig, ok := s.signatures.signatures[key]; ok {
+		return sig, nil
+	}
+
+	var sendAs *gmail.SendAs
+	err := s.call(func(ctx context.Context) (err error) {
+		if key == "" {
+			sendAs, err = s.primarySendAs(ctx)
+		} else {
+			sendAs, err = s.GmailSvc.Users.Settings.SendAs.Get("me", key).Context(ctx).Do()
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if s.signatures.signatures == nil {
+		s.signatures.signatures = map[string]string{}
+	}
+	s.signatures.signatures[key] = sendAs.Signature
+	return sendAs.Signature, nil
+}
+
+// IsSendAsVerified reports whether mail can be sent from the send-as address:
+// it is the primary address, or an alias whose ownership was verified.
+// Messages sent from an unverified alias (pending verification) are sent from
+// the primary address instead.
+func (s *Service) IsSendAsVerified(email string) (bool, error) {
+	var sendAs *gmail.SendAs
+	err := s.call(func(ctx context.Context) (err error) {
+		sendAs, err = s.GmailSvc.Users.Settings.SendAs.Get("me", strings.ToLower(parseAddress(email).Address)).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	// the primary address has no verification status
+	return sendAs.IsPrimary || sendAs.VerificationStatus == "accepted", nil
+}
+
+// primarySendAs returns the primary address of the account.
+func (s *Service) primarySendAs(ctx context.Context) (*gmail.SendAs, error) {
+	res, err := s.GmailSvc.Users.Settings.SendAs.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range res.SendAs {
+		if a.IsPrimary {
+			return a, nil
+		}
+	}
+	return nil, errors.New("account has no primary send-as address")
+}
@@ -0,0 +1,222 @@
+package inboxer
+
+import (
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestListDelegates(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	fake.addToCollection("delegates",
+		&gmail.Delegate{DelegateEmail: "alice@example.com", VerificationStatus: "accepted"},
+		&gmail.Delegate{DelegateEmail: "bob@example.com", VerificationStatus: "pending"},
+	)
+
+	delegates, err := newFakeService(t, fake).ListDelegates()
+	c.Assert(err, qt.IsNil)
+	c.Assert(delegates, qt.HasLen, 2)
+	c.Assert(delegates[0].DelegateEmail, qt.Equals, "alice@example.com")
+	c.Assert(delegates[1].VerificationStatus, qt.Equals, "pending")
+}
+
+func TestSettingsRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	src := &fakeGmail{}
+	src.setSetting("vacation", &gmail.VacationSettings{EnableAutoReply: true, ResponseSubject: "Away", ResponseBodyPlainText: "Back Monday"})
+	src.setSetting("imap", &gmail.ImapSettings{Enabled: true, ExpungeBehavior: "archive"})
+	src.setSetting("pop", &gmail.PopSettings{AccessWindow: "disabled", Disposition: "leaveInInbox"})
+	src.setSetting("language", &gmail.LanguageSettings{DisplayLanguage: "pt-BR"})
+	src.setSetting("autoForwarding", &gmail.AutoForwarding{Enabled: true, EmailAddress: "backup@example.com", Disposition: "archive"})
+	src.addToCollection("forwardingAddresses", &gmail.ForwardingAddress{ForwardingEmail: "backup@example.com", VerificationStatus: "accepted"})
+	src.labels = []*gmail.Label{
+		{Id: "INBOX", Name: "INBOX", Type: "system"},
+		{Id: "Label_7", Name: "Work", Type: "user"},
+		{Id: "Label_8", Name: "Receipts", Type: "user"},
+		{Id: "Label_9", Name: "Unused", Type: "user"},
+	}
+	src.addToCollection("filters",
+		&gmail.Filter{
+			Id:       "f1",
+			Criteria: &gmail.FilterCriteria{From: "boss@example.com"},
+			Action:   &gmail.FilterAction{AddLabelIds: []string{"IMPORTANT", "Label_7"}},
+		},
+		&gmail.Filter{
+			Id:       "f2",
+			Criteria: &gmail.FilterCriteria{Query: "invoice"},
+			Action:   &gmail.FilterAction{AddLabelIds: []string{"Label_8"}, RemoveLabelIds: []string{"INBOX"}},
+		},
+	)
+	src.addToCollection("sendAs",
+		&gmail.SendAs{SendAsEmail: "me@example.com", IsPrimary: true, DisplayName: "Me", Signature: "-- me"},
+		&gmail.SendAs{SendAsEmail: "alias@example.com", DisplayName: "Alias", VerificationStatus: "accepted"},
+	)
+
+	exported, err := newFakeService(t, src).ExportSettings()
+	c.Assert(err, qt.IsNil)
+	data, err := json.Marshal(exported)
+	c.Assert(err, qt.IsNil)
+	settings := &Settings{}
+	c.Assert(json.Unmarshal(data, settings), qt.IsNil)
+
+	c.Assert(settings.Labels, qt.HasLen, 2)
+
+	// the filters get the labels of the same name, Work being created
+	dst := &fakeGmail{labels: []*gmail.Label{{Id: "Label_1", Name: "receipts", Type: "user"}}}
+	dst.addToCollection("sendAs", &gmail.SendAs{SendAsEmail: "other@example.com", IsPrimary: true})
+	err = newFakeService(t, dst).ImportSettings(settings)
+	c.Assert(err, qt.IsNil)
+
+	imported, err := newFakeService(t, dst).ExportSettings()
+	c.Assert(err, qt.IsNil)
+	c.Assert(imported.Vacation, qt.DeepEquals, exported.Vacation)
+	c.Assert(imported.IMAP, qt.DeepEquals, exported.IMAP)
+	c.Assert(imported.POP, qt.DeepEquals, exported.POP)
+	c.Assert(imported.Language, qt.DeepEquals, exported.Language)
+	c.Assert(imported.AutoForwarding, qt.DeepEquals, exported.AutoForwarding)
+	c.Assert(imported.ForwardingAddresses[0].ForwardingEmail, qt.Equals, "backup@example.com")
+	c.Assert(imported.Filters, qt.HasLen, 2)
+	c.Assert(imported.Filters[0].Criteria, qt.DeepEquals, exported.Filters[0].Criteria)
+	c.Assert(imported.Filters[0].Action, qt.DeepEquals, &gmail.FilterAction{AddLabelIds: []string{"IMPORTANT", "Label_2"}})
+	c.Assert(imported.Filters[1].Action, qt.DeepEquals, &gmail.FilterAction{AddLabelIds: []string{"Label_1"}, RemoveLabelIds: []string{"INBOX"}})
+	c.Assert(dst.labels, qt.HasLen, 2)
+	c.Assert(dst.labels[1].Name, qt.Equals, "Work")
+	c.Assert(imported.SendAs, qt.DeepEquals, []*gmail.SendAs{
+		{SendAsEmail: "other@example.com", IsPrimary: true, DisplayName: "Me", Signature: "-- me"},
+		{SendAsEmail: "alias@example.com", DisplayName: "Alias"},
+	})
+}
+
+func TestImportSettingsPartialFailure(t *testing.T) {
+	c := qt.New(t)
+
+	dst := &fakeGmail{fail: map[string]int{"/settings/imap": 403, "/settings/filters": 400}}
+	err := newFakeService(t, dst).ImportSettings(&Settings{
+		IMAP:     &gmail.ImapSettings{Enabled: true},
+		Language: &gmail.LanguageSettings{DisplayLanguage: "fr"},
+		Filters:  []*gmail.Filter{{Id: "f1", Criteria: &gmail.FilterCriteria{From: "a@example.com"}}},
+	})
+	c.Assert(err, qt.ErrorMatches, "(?s)imap: .*\nfilter f1: .*")
+	// the language was still applied
+	c.Assert(string(dst.settings["language"]), qt.Equals, `{"displayLanguage":"fr"}`)
+
+	// without its label, a filter would use another one
+	dst = &fakeGmail{}
+	err = newFakeService(t, dst).ImportSettings(&Settings{
+		Filters: []*gmail.Filter{{Id: "f1", Action: &gmail.FilterAction{AddLabelIds: []string{"Label_3"}}}},
+	})
+	c.Assert(err, qt.ErrorMatches, "filter f1: unknown label Label_3")
+	c.Assert(dst.callCount("POST /settings/filters"), qt.Equals, 0)
+}
+
+func TestEnableAutoForward(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	fake.addToCollection("forwardingAddresses",
+		&gmail.ForwardingAddress{ForwardingEmail: "archive@example.com", VerificationStatus: "accepted"},
+		&gmail.ForwardingAddress{ForwardingEmail: "new@example.com", VerificationStatus: "pending"},
+	)
+	s := newFakeService(t, fake)
+
+	got, err := s.EnableAutoForward("Archive@example.com", "archive")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Enabled, qt.IsTrue)
+
+	settings, err := s.GetAutoForwarding()
+	c.Assert(err, qt.IsNil)
+	c.Assert(settings.Enabled, qt.IsTrue)
+	c.Assert(settings.EmailAddress, qt.Equals, "Archive@example.com")
+	c.Assert(settings.Disposition, qt.Equals, "archive")
+
+	_, err = s.EnableAutoForward("new@example.com", "archive")
+	c.Assert(err, qt.ErrorMatches, "new@example.com is not a verified forwarding address")
+	_, err = s.EnableAutoForward("unknown@example.com", "archive")
+	c.Assert(err, qt.ErrorMatches, "unknown@example.com is not a verified forwarding address")
+	c.Assert(fake.callCount("PUT /settings/autoForwarding"), qt.Equals, 1)
+}
+
+func TestGetImportanceSettings(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{labels: []*gmail.Label{
+		{Id: "INBOX", MessagesTotal: 100},
+		{Id: "IMPORTANT", MessagesTotal: 12, MessagesUnread: 3},
+	}}
+	fake.addToCollection("filters",
+		&gmail.Filter{Id: "f1", Criteria: &gmail.FilterCriteria{From: "boss@example.com"}, Action: &gmail.FilterAction{AddLabelIds: []string{"IMPORTANT"}}},
+		&gmail.Filter{Id: "f2", Criteria: &gmail.FilterCriteria{From: "news@example.com"}, Action: &gmail.FilterAction{RemoveLabelIds: []string{"IMPORTANT", "INBOX"}}},
+		&gmail.Filter{Id: "f3", Criteria: &gmail.FilterCriteria{Query: "invoice"}, Action: &gmail.FilterAction{AddLabelIds: []string{"Label_1"}}},
+	)
+	s := newFakeService(t, fake)
+
+	settings, err := s.GetImportanceSettings()
+	c.Assert(err, qt.IsNil)
+	c.Assert(settings.Messages, qt.Equals, int64(12))
+	c.Assert(settings.Unread, qt.Equals, int64(3))
+	c.Assert(settings.AlwaysImportant, qt.HasLen, 1)
+	c.Assert(settings.AlwaysImportant[0].Id, qt.Equals, "f1")
+	c.Assert(settings.NeverImportant, qt.HasLen, 1)
+	c.Assert(settings.NeverImportant[0].Id, qt.Equals, "f2")
+
+	_, err = s.ImportanceMarkersEnabled()
+	c.Assert(err, qt.ErrorIs, ErrUnsupported)
+	c.Assert(s.SetImportanceMarkers(false), qt.ErrorIs, ErrUnsupported)
+}
+
+func TestGetSignature(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	fake.addToCollection("sendAs",
+		&gmail.SendAs{SendAsEmail: "alias@example.com", Signature: "Alias team"},
+		&gmail.SendAs{SendAsEmail: "me@example.com", IsPrimary: true, Signature: "<b>Me</b><br>ACME Inc."},
+	)
+	s := newFakeService(t, fake)
+
+	sig, err := s.GetSignature("")
+	c.Assert(err, qt.IsNil)
+	c.Assert(sig, qt.Equals, "<b>Me</b><br>ACME Inc.")
+	sig, err = s.GetSignature("Alias <Alias@example.com>")
+	c.Assert(err, qt.IsNil)
+	c.Assert(sig, qt.Equals, "Alias team")
+
+	// cached
+	_, err = s.GetSignature("")
+	c.Assert(err, qt.IsNil)
+	_, err = s.GetSignature("alias@example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(fake.callCount("GET /settings/sendAs"), qt.Equals, 1)
+	c.Assert(fake.callCount("GET /settings/sendAs/alias@example.com"), qt.Equals, 1)
+
+	_, err = s.GetSignature("unknown@example.com")
+	c.Assert(isNotFound(err), qt.IsTrue)
+}
+
+func TestIsSendAsVerified(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	fake.addToCollection("sendAs", &gmail.SendAs{SendAsEmail: "me@example.com", IsPrimary: true})
+	fake.addToCollection("sendAs", &gmail.SendAs{SendAsEmail: "alias@example.com", VerificationStatus: "accepted"})
+	fake.addToCollection("sendAs", &gmail.SendAs{SendAsEmail: "new@example.com", VerificationStatus: "pending"})
+	s := newFakeService(t, fake)
+
+	for email, want := range map[string]bool{
+		"me@example.com":            true,
+		"Alias <Alias@example.com>": true,
+		"new@example.com":           false,
+	} {
+		ok, err := s.IsSendAsVerified(email)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.Equals, want, qt.Commentf(email))
+	}
+
+	_, err := s.IsSendAsVerified("unknown@example.com")
+	c.Assert(isNotFound(err), qt.IsTrue)
+}
@@ -0,0 +1,156 @@
+package inboxer
+
+import (
+	"sort"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DomainStat is the number of messages sent from a domain.
+type DomainStat struct {
+	Domain string
+	Count  int
+	// Share is the fraction of the messages sent from the domain, between 0
+	// and 1.
+	Share float64
+}
+
+// MessagesByDomain counts the messages matching the query per sender domain
+// (the domain of the From address), and returns the topN busiest domains,
+// busiest first; a topN <= 0 returns every domain. Only the From header of the
+// messages is fetched. Messages without a From address are not counted.
+func (s *Service) MessagesByDomain(query string, topN int) ([]DomainStat, error) {
+	counts := map[string]int{}
+	total := 0
+	err := s.listPages(s.GmailSvc.Users.Messages.List("me").Q(query), func(res *gmail.ListMessagesResponse) error {
+		return s.forEachByID(res.Messages, "metadata", []string{"From"}, func(msg *gmail.Message) error {
+			if domain := addressDomain(parseAddress(GetHeaders(msg).Get("From")).Address); domain != "" {
+				counts[domain]++
+				total++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]DomainStat, 0, len(counts))
+	for domain, n := range counts {
+		stats = append(stats, DomainStat{Domain: domain, Count: n, Share: float64(n) / float64(total)})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Domain < stats[j].Domain
+	})
+	if topN > 0 && len(stats) > topN {
+		stats = stats[:topN]
+	}
+	return stats, nil
+}
+
+// addressDomain returns the domain of the address, lower cased, or "" when
+// there is none.
+func addressDomain(address string) string {
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(address[i+1:])
+}
+
+// FindDuplicates returns the clusters of messages matching the query that
+// look like copies of each other, e.g. mail delivered twice: messages with the
+// same Message-ID, or with the same subject, sender and date. Each cluster
+// holds the IDs of at least two messages, in the order they are listed. Only
+// the headers needed are fetched.
+func (s *Service) FindDuplicates(query string) ([][]string, error) {
+	var ids []string
+	// parent links each message (by index) to an earlier one of its cluster,
+	// the first one being the root
+	parent := map[int]int{}
+	first := map[string]int{}
+	root := func(i int) int {
+		for parent[i] != i {
+			i = parent[i]
+		}
+		return i
+	}
+	join := func(key string, i int) {
+		j, ok := first[key]
+		if !ok {
+			first[key] = i
+			return
+		}
+		ri, rj := root(i), root(j)
+		if ri > rj {
+			ri, rj = rj, ri
+		}
+		parent[rj] = ri
+	}
+
+	headers := []string{"Message-ID", "Subject", "From", "Date"}
+	err := s.listPages(s.GmailSvc.Users.Messages.List("me").Q(query), func(res *gmail.ListMessagesResponse) error {
+		return s.forEachByID(res.Messages, "metadata", headers, func(msg *gmail.Message) error {
+			i := len(ids)
+			ids = append(ids, msg.Id)
+			parent[i] = i
+			h := GetHeaders(msg)
+			if id := strings.TrimSpace(h.Get("Message-ID")); id != "" {
+				join("id\x00"+id, i)
+			}
+			from := strings.ToLower(parseAddress(h.Get("From")).Address)
+			if date := strings.TrimSpace(h.Get("Date")); from != "" && date != "" {
+				join("sent\x00"+h.Get("Subject")+"\x00"+from+"\x00"+date, i)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters [][]string
+	index := map[int]int{}
+	for i, id := range ids {
+		r := root(i)
+		n, ok := index[r]
+		if !ok {
+			n = len(clusters)
+			index[r] = n
+			clusters = append(clusters, nil)
+		}
+		clusters[n] = append(clusters[n], id)
+	}
+	dups := clusters[:0]
+	for _, cluster := range clusters {
+		if len(cluster) > 1 {
+			dups = append(dups, cluster)
+		}
+	}
+	return dups, nil
+}
+
+// MailboxSize returns an estimate of the storage used by the mailbox, in
+// bytes: the sum of the estimated sizes of all its messages, including those
+// in the spam and the trash. The gmail API doesn't expose the storage used by
+// the account (which Google Drive and Photos share anyway), so every message
+// is fetched, in the minimal format: this costs 5 quota units per message,
+// and takes a while on large mailboxes.
+func (s *Service) MailboxSize() (int64, error) {
+	var size int64
+	call := s.GmailSvc.Users.Messages.List("me").IncludeSpamTrash(true).MaxResults(maxPageSize)
+	err := s.listPages(call, func(res *gmail.ListMessagesResponse) error {
+		return s.forEachByID(res.Messages, "minimal", nil, func(msg *gmail.Message) error {
+			size += msg.SizeEstimate
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,85 @@
+package inboxer
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestMessagesByDomain(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{}
+	for i, from := range []string{
+		"News <news@mailchimp.com>",
+		"deals@Mailchimp.com",
+		"Alice <alice@example.com>",
+		"promo@mailchimp.com",
+		"Bob <bob@example.org>",
+		"",
+		"carol@example.com",
+	} {
+		msg := withHeaders(newMessage(), "From", from)
+		msg.Id = fmt.Sprint(i)
+		fake.messages = append(fake.messages, msg)
+	}
+
+	stats, err := newFakeService(t, fake).MessagesByDomain("", 2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(stats, qt.DeepEquals, []DomainStat{
+		{Domain: "mailchimp.com", Count: 3, Share: 0.5},
+		{Domain: "example.com", Count: 2, Share: 2.0 / 6},
+	})
+	for _, p := range fake.paramsOf("GET /messages/0") {
+		c.Assert(p.Get("format"), qt.Equals, "metadata")
+		c.Assert(p["metadataHeaders"], qt.DeepEquals, []string{"From"})
+	}
+
+	stats, err = newFakeService(t, fake).MessagesByDomain("", 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(stats, qt.HasLen, 3)
+	c.Assert(stats[2].Domain, qt.Equals, "example.org")
+}
+
+func TestFindDuplicates(t *testing.T) {
+	c := qt.New(t)
+
+	const date = "Mon, 6 Mar 2023 09:00:00 +0000"
+	fake := &fakeGmail{}
+	for i, headers := range [][]string{
+		{"Message-ID", "<a@example.com>", "Subject", "Invoice", "From", "billing@example.com", "Date", date},
+		{"Message-ID", "<b@example.com>", "Subject", "Lunch", "From", "alice@example.com", "Date", date},
+		{"Message-ID", "<a@example.com>", "Subject", "Invoice", "From", "billing@example.com", "Date", date},
+		// re-delivered with a new Message-ID
+		{"Message-ID", "<c@example.com>", "Subject", "Lunch", "From", "Alice <Alice@example.com>", "Date", date},
+		{"Message-ID", "<d@example.com>", "Subject", "Lunch", "From", "alice@example.com", "Date", "Tue, 7 Mar 2023 09:00:00 +0000"},
+		{"Subject", "No headers to compare"},
+		{"Subject", "No headers to compare"},
+	} {
+		msg := withHeaders(newMessage(), headers...)
+		msg.Id = fmt.Sprint(i)
+		fake.messages = append(fake.messages, msg)
+	}
+
+	dups, err := newFakeService(t, fake).FindDuplicates("")
+	c.Assert(err, qt.IsNil)
+	c.Assert(dups, qt.DeepEquals, [][]string{{"0", "2"}, {"1", "3"}})
+	c.Assert(fake.paramsOf("GET /messages/0")[0]["metadataHeaders"], qt.DeepEquals, []string{"Message-ID", "Subject", "From", "Date"})
+}
+
+func TestMailboxSize(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{pageSize: 2}
+	for i, size := range []int64{1200, 35000, 800, 4096, 10} {
+		fake.messages = append(fake.messages, &gmail.Message{Id: fmt.Sprint(i), SizeEstimate: size})
+	}
+	size, err := newFakeService(t, fake).MailboxSize()
+	c.Assert(err, qt.IsNil)
+	c.Assert(size, qt.Equals, int64(41106))
+	c.Assert(fake.queries, qt.HasLen, 3)
+	c.Assert(fake.queries[0].Get("includeSpamTrash"), qt.Equals, "true")
+	c.Assert(fake.paramsOf("GET /messages/4")[0].Get("format"), qt.Equals, "minimal")
+}
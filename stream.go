@@ -0,0 +1,93 @@
+package inboxer
+
+import (
+	"context"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// StreamOption customizes the messages sent by Stream.
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	includeSpamTrash bool
+	maxResults       uint
+	pageSize         int64
+}
+
+// defaultPageSize is the page size of Stream, the one of the API.
+const defaultPageSize = 100
+
+// IncludeSpamTrash makes Stream include the messages in SPAM and TRASH,
+// which are skipped otherwise.
+func IncludeSpamTrash() StreamOption {
+	return func(o *streamOptions) {
+		o.includeSpamTrash = true
+	}
+}
+
+// MaxResults makes Stream stop after n messages. Without it every matching
+// message is streamed, which on an empty query means the whole mailbox.
+func MaxResults(n uint) StreamOption {
+	return func(o *streamOptions) {
+		o.maxResults = n
+	}
+}
+
+// PageSize sets how many messages Stream lists per request, 100 by default.
+// Larger pages make fewer requests on large scans. It is clamped to 1-500,
+// the sizes the API accepts.
+func PageSize(n int) StreamOption {
+	return func(o *streamOptions) {
+		o.pageSize = clampPageSize(n)
+	}
+}
+
+// clampPageSize returns the page size closest to n the API accepts.
+func clampPageSize(n int) int64 {
+	switch {
+	case n < 1:
+		return 1
+	case n > maxPageSize:
+		return maxPageSize
+	}
+	return int64(n)
+}
+
+// Stream sends the messages matching the query on the returned channel, in
+// order, as soon as they are retrieved (see ForEachMessage). The channel is
+// closed when every message was sent, on error, or when ctx is done; the
+// returned function then reports the error, if any. It blocks until the
+// channel is closed.
+func (s *Service) Stream(ctx context.Context, query string, opts ...StreamOption) (<-chan *gmail.Message, func() error) {
+	o := &streamOptions{pageSize: defaultPageSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	call := s.GmailSvc.Users.Messages.List("me").Q(query).IncludeSpamTrash(o.includeSpamTrash).MaxResults(o.pageSize)
+	if o.maxResults > 0 && int64(o.maxResults) < o.pageSize {
+		// don't list more than needed
+		call.MaxResults(int64(o.maxResults))
+	}
+
+	ch := make(chan *gmail.Message)
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		defer close(ch)
+		err = s.forEachMessage(call, "", o.maxResults, func(msg *gmail.Message) error {
+			select {
+			case ch <- msg:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return ch, func() error {
+		<-done
+		return err
+	}
+}
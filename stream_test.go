@@ -0,0 +1,93 @@
+package inboxer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestStream(t *testing.T) {
+	c := qt.New(t)
+
+	mailbox := func() *fakeGmail {
+		f := &fakeGmail{}
+		for i := 1; i <= 5; i++ {
+			f.messages = append(f.messages, &gmail.Message{Id: fmt.Sprint(i)})
+		}
+		return f
+	}
+
+	c.Run("all", func(c *qt.C) {
+		ch, wait := newFakeService(t, mailbox()).Stream(context.Background(), "")
+		var got []*gmail.Message
+		for msg := range ch {
+			got = append(got, msg)
+		}
+		c.Assert(wait(), qt.IsNil)
+		c.Assert(ids(got), qt.DeepEquals, []string{"1", "2", "3", "4", "5"})
+	})
+
+	c.Run("capped", func(c *qt.C) {
+		fake := mailbox()
+		ch, wait := newFakeService(t, fake).Stream(context.Background(), "", MaxResults(3), IncludeSpamTrash())
+		var got []*gmail.Message
+		for msg := range ch {
+			got = append(got, msg)
+		}
+		c.Assert(wait(), qt.IsNil)
+		c.Assert(ids(got), qt.DeepEquals, []string{"1", "2", "3"})
+		c.Assert(fake.callCount("GET /messages"), qt.Equals, 1)
+		c.Assert(fake.callCount("GET /messages/4"), qt.Equals, 0)
+		c.Assert(fake.queries[0].Get("includeSpamTrash"), qt.Equals, "true")
+		c.Assert(fake.queries[0].Get("maxResults"), qt.Equals, "3")
+	})
+
+	c.Run("capped across pages", func(c *qt.C) {
+		fake := mailbox()
+		fake.pageSize = 2
+		ch, wait := newFakeService(t, fake).Stream(context.Background(), "", MaxResults(3))
+		var got []*gmail.Message
+		for msg := range ch {
+			got = append(got, msg)
+		}
+		c.Assert(wait(), qt.IsNil)
+		c.Assert(ids(got), qt.DeepEquals, []string{"1", "2", "3"})
+		c.Assert(fake.callCount("GET /messages"), qt.Equals, 2)
+		c.Assert(fake.callCount("GET /messages/4"), qt.Equals, 0)
+	})
+
+	c.Run("page size", func(c *qt.C) {
+		for _, test := range []struct {
+			opts     []StreamOption
+			pageSize string
+			lists    int
+		}{
+			{nil, "100", 1},
+			{[]StreamOption{PageSize(2)}, "2", 3},
+			{[]StreamOption{PageSize(2), MaxResults(1)}, "1", 1},
+			{[]StreamOption{PageSize(1000)}, "500", 1},
+			{[]StreamOption{PageSize(-5)}, "1", 5},
+		} {
+			fake := mailbox()
+			ch, wait := newFakeService(t, fake).Stream(context.Background(), "", test.opts...)
+			for range ch {
+			}
+			c.Assert(wait(), qt.IsNil)
+			c.Assert(fake.queries[0].Get("maxResults"), qt.Equals, test.pageSize)
+			c.Assert(fake.callCount("GET /messages"), qt.Equals, test.lists)
+		}
+	})
+
+	c.Run("cancelled", func(c *qt.C) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, wait := newFakeService(t, mailbox()).Stream(ctx, "")
+		c.Assert((<-ch).Id, qt.Equals, "1")
+		cancel()
+		for range ch {
+		}
+		c.Assert(wait(), qt.Equals, context.Canceled)
+	})
+}
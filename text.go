@@ -0,0 +1,228 @@
+package inboxer
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+	"google.golang.org/api/gmail/v1"
+)
+
+// ExtractText returns the text of the message, whatever its structure: the
+// text/plain body when there is one, else the text/html body converted to
+// text, else the snippet. Messages without any text return an empty string.
+func ExtractText(msg *gmail.Message) (string, error) {
+	for _, mimeType := range []string{"text/plain", "text/html"} {
+		body, err := GetBody(msg, mimeType)
+		if errors.Is(err, ErrBodyTooLarge) {
+			return "", err
+		}
+		if err != nil {
+			continue
+		}
+		if mimeType == "text/html" {
+			body = htmlToText(body)
+		}
+		if body = strings.TrimSpace(body); body != "" {
+			return body, nil
+		}
+	}
+	return GetSnippet(msg), nil
+}
+
+// GetSnippet returns the snippet of the message (the beginning of its text
+// shown in inbox lists) as plain text: the API returns it html escaped.
+func GetSnippet(msg *gmail.Message) string {
+	return html.UnescapeString(msg.Snippet)
+}
+
+// blockElements are the html elements rendered on their own lines.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// htmlToText converts an html body to plain text: tags are dropped along with
+// scripts and styles, entities are decoded and whitespace is collapsed, block
+// elements (paragraphs, list items...) being kept on separate lines.
+func htmlToText(body string) string {
+	var lines []string
+	var line strings.Builder
+	flush := func() {
+		if l := strings.Join(strings.Fields(line.String()), " "); l != "" {
+			lines = append(lines, l)
+		}
+		line.Reset()
+	}
+
+	z := xhtml.NewTokenizer(strings.NewReader(body))
+	skip := ""
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			flush()
+			return strings.Join(lines, "\n")
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+
+		switch {
+		case skip != "":
+			if tt == xhtml.EndTagToken && tag == skip {
+				skip = ""
+			}
+		case tt == xhtml.StartTagToken && (tag == "script" || tag == "style" || tag == "head" || tag == "title"):
+			skip = tag
+		case tt == xhtml.TextToken:
+			// the tokenizer has already decoded the entities
+			line.WriteString(string(z.Text()))
+		case blockElements[tag]:
+			flush()
+		}
+	}
+}
+
+var (
+	// originalMessage is the line Outlook puts above the quoted message.
+	originalMessage = regexp.MustCompile(`^-{2,}\s*Original Message\s*-{2,}$`)
+	// separator is the line of underscores Outlook puts above the headers of
+	// the quoted message.
+	separator = regexp.MustCompile(`^_{10,}$`)
+)
+
+// StripQuotedReply returns the new part of a reply body (see ExtractText),
+// the way mail clients collapse the history: quoted lines (starting with ">")
+// are dropped along with the "On ... wrote:" line introducing them, and
+// everything from a signature delimiter ("-- ") or an Outlook style quoted
+// message ("-----Original Message-----", or a From:/Sent:/Subject: block) on
+// is cut. It errs on the side of keeping text: the unquoted answers of an
+// inline reply are kept, and an "On ... wrote:" line only goes when quoted
+// lines follow it.
+func StripQuotedReply(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "--" || originalMessage.MatchString(line) || isQuotedHeaders(lines[i:]) ||
+			separator.MatchString(line) && isQuotedHeaders(lines[i+1:]) {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		if n := attributionLines(lines[i:]); n > 0 && quotedNext(lines[i+n:]) {
+			i += n - 1
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	return strings.Trim(strings.Join(kept, "\n"), "\n\t ")
+}
+
+// attributionLines returns how many lines (0, 1 or 2, as gmail wraps long
+// ones) the "On <date>, <sender> wrote:" line at the start of lines takes.
+func attributionLines(lines []string) int {
+	if !strings.HasPrefix(strings.TrimSpace(lines[0]), "On ") {
+		return 0
+	}
+	for i := 0; i < len(lines) && i < 2; i++ {
+		if strings.HasSuffix(strings.TrimSpace(lines[i]), "wrote:") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// quotedNext reports whether the first non blank line is a quoted one.
+func quotedNext(lines []string) bool {
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			return strings.HasPrefix(l, ">")
+		}
+	}
+	return false
+}
+
+// isQuotedHeaders reports whether lines start with the headers Outlook puts
+// above the quoted message: From:, followed by Sent: (or Date:) and Subject:.
+func isQuotedHeaders(lines []string) bool {
+	if len(lines) == 0 || !strings.HasPrefix(strings.TrimSpace(lines[0]), "From:") {
+		return false
+	}
+	sent, subject := false, false
+	for i := 1; i < len(lines) && i < 6; i++ {
+		l := strings.TrimSpace(lines[i])
+		sent = sent || strings.HasPrefix(l, "Sent:") || strings.HasPrefix(l, "Date:")
+		subject = subject || strings.HasPrefix(l, "Subject:")
+	}
+	return sent && subject
+}
+
+// bareURL matches the URLs written in text.
+var bareURL = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// ExtractURLs returns the URLs of the message, once each and in order: the
+// link targets (href attributes) and the URLs written in the text of the html
+// body, then the URLs of the plain text body. Messages without a body return
+// no URLs.
+func ExtractURLs(msg *gmail.Message) ([]string, error) {
+	var urls []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	body, err := GetBody(msg, "text/html")
+	if errors.Is(err, ErrBodyTooLarge) {
+		return nil, err
+	}
+	if err == nil {
+		z := xhtml.NewTokenizer(strings.NewReader(body))
+		for tt := z.Next(); tt != xhtml.ErrorToken; tt = z.Next() {
+			switch tt {
+			case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+				for _, a := range z.Token().Attr {
+					if a.Key == "href" && !strings.HasPrefix(a.Val, "#") {
+						add(strings.TrimSpace(a.Val))
+					}
+				}
+			case xhtml.TextToken:
+				for _, u := range findURLs(string(z.Text())) {
+					add(u)
+				}
+			}
+		}
+	}
+
+	body, err = GetBody(msg, "text/plain")
+	if errors.Is(err, ErrBodyTooLarge) {
+		return nil, err
+	}
+	for _, u := range findURLs(body) {
+		add(u)
+	}
+	return urls, nil
+}
+
+// findURLs returns the URLs written in text, without the punctuation that
+// may follow them.
+func findURLs(text string) []string {
+	urls := bareURL.FindAllString(text, -1)
+	for i, u := range urls {
+		u = strings.TrimRight(u, ".,;:!?'")
+		// keep the parenthesis of a URL like a wikipedia one
+		for strings.HasSuffix(u, ")") && strings.Count(u, "(") < strings.Count(u, ")") {
+			u = u[:len(u)-1]
+		}
+		urls[i] = u
+	}
+	return urls
+}
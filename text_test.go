@@ -0,0 +1,121 @@
+package inboxer
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestExtractText(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("plain", func(c *qt.C) {
+		msg := newMessage(newPart("text/plain", "Hello Bob,\n\nsee you!\n"), newPart("text/html", "<p>ignored</p>"))
+		text, err := ExtractText(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(text, qt.Equals, "Hello Bob,\n\nsee you!")
+	})
+
+	c.Run("not multipart", func(c *qt.C) {
+		msg := &gmail.Message{Payload: newPart("text/plain", "just text")}
+		text, err := ExtractText(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(text, qt.Equals, "just text")
+	})
+
+	c.Run("html only", func(c *qt.C) {
+		msg := newMessage(newPart("text/html", `<html><head><title>Newsletter</title><style>p { color: red }</style></head>
+			<body><h1>Big   news</h1><p>Caf&eacute; &amp; <b>cr&egrave;me</b>
+			are back.</p><script>track()</script><ul><li>one</li><li>two</li></ul>line<br>break</body></html>`))
+		text, err := ExtractText(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(text, qt.Equals, "Big news\nCafé & crème are back.\none\ntwo\nline\nbreak")
+	})
+
+	c.Run("snippet", func(c *qt.C) {
+		msg := &gmail.Message{Snippet: "It&#39;s only a snippet"}
+		text, err := ExtractText(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(text, qt.Equals, "It's only a snippet")
+	})
+
+	c.Run("empty", func(c *qt.C) {
+		text, err := ExtractText(newMessage())
+		c.Assert(err, qt.IsNil)
+		c.Assert(text, qt.Equals, "")
+	})
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		name, body, want string
+	}{{
+		name: "gmail",
+		body: "Sounds good, see you then.\r\n\r\nOn Mon, Jan 2, 2023 at 10:00 AM Alice <alice@example.com> wrote:\r\n> Lunch on Friday?\r\n>\r\n> Alice\r\n",
+		want: "Sounds good, see you then.",
+	}, {
+		name: "wrapped attribution",
+		body: "Yes.\n\nOn Mon, Jan 2, 2023 at 10:00 AM Alice Longname <\nalice.longname@example.com> wrote:\n\n> Ready?\n",
+		want: "Yes.",
+	}, {
+		name: "outlook",
+		body: "Done, thanks.\n\nBob\n\n________________________________\nFrom: Alice <alice@example.com>\nSent: Monday, January 2, 2023 10:00 AM\nTo: Bob <bob@example.com>\nSubject: Report\n\nCould you send the report?\n",
+		want: "Done, thanks.\n\nBob",
+	}, {
+		name: "outlook original message",
+		body: "Done.\n\n-----Original Message-----\nFrom: Alice\nCould you send the report?\n",
+		want: "Done.",
+	}, {
+		name: "signature",
+		body: "See attached.\n\n-- \nBob Smith\nACME Inc.\n",
+		want: "See attached.",
+	}, {
+		name: "inline reply",
+		body: "On Mon, Jan 2, 2023, Alice wrote:\n> Friday?\nYes.\n> Noon?\nBetter at one.\n",
+		want: "Yes.\nBetter at one.",
+	}, {
+		name: "nothing quoted",
+		body: "On Monday I wrote: the report is late.\nFrom: the team, with 1 > 0 confidence.\n",
+		want: "On Monday I wrote: the report is late.\nFrom: the team, with 1 > 0 confidence.",
+	}} {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(StripQuotedReply(test.body), qt.Equals, test.want)
+		})
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("html", func(c *qt.C) {
+		msg := newMessage(
+			newPart("text/plain", "Sign in at https://example.com/login."),
+			newPart("text/html", `<p>Your account is locked. <a href="https://evil.example/verify?id=1&amp;t=2">Sign in</a>
+				or go to https://example.com/login. <a href="#top">Top</a> <a href="mailto:help@example.com">Help</a>
+				<a href="https://evil.example/verify?id=1&amp;t=2">again</a></p>`),
+		)
+		urls, err := ExtractURLs(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(urls, qt.DeepEquals, []string{
+			"https://evil.example/verify?id=1&t=2",
+			"https://example.com/login",
+			"mailto:help@example.com",
+		})
+	})
+
+	c.Run("plain", func(c *qt.C) {
+		msg := newMessage(newPart("text/plain", "See https://en.wikipedia.org/wiki/Go_(programming_language), (or http://go.dev)!\nNo URL here."))
+		urls, err := ExtractURLs(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(urls, qt.DeepEquals, []string{"https://en.wikipedia.org/wiki/Go_(programming_language)", "http://go.dev"})
+	})
+
+	c.Run("no body", func(c *qt.C) {
+		urls, err := ExtractURLs(&gmail.Message{})
+		c.Assert(err, qt.IsNil)
+		c.Assert(urls, qt.HasLen, 0)
+	})
+}
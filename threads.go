@@ -0,0 +1,101 @@
+package inboxer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// GetThread retrieves a thread, including all of its messages, by its ID.
+func (s *Service) GetThread(threadID string) (*gmail.Thread, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.GmailSvc.Users.Threads.Get("me", threadID).Context(ctx).Do()
+}
+
+// LatestInThread returns the most recently received message of the thread.
+func (s *Service) LatestInThread(threadID string) (*gmail.Message, error) {
+	thread, err := s.GetThread(threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *gmail.Message
+	for _, msg := range thread.Messages {
+		if latest == nil || msg.InternalDate > latest.InternalDate {
+			latest = msg
+		}
+	}
+	if latest == nil {
+		return nil, errors.New("thread has no messages")
+	}
+	return latest, nil
+}
+
+// ThreadMessages returns the messages of the thread in chronological order,
+// oldest first, ready to be displayed as a conversation.
+func (s *Service) ThreadMessages(threadID string) ([]*gmail.Message, error) {
+	thread, err := s.GetThread(threadID)
+	if err != nil {
+		return nil, err
+	}
+	sortByDate(thread.Messages)
+	return thread.Messages, nil
+}
+
+// sortByDate sorts the messages by the date gmail received them, oldest
+// first. Messages received at the same time keep their order.
+func sortByDate(msgs []*gmail.Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].InternalDate < msgs[j].InternalDate
+	})
+}
+
+// ThreadUnreadCount returns how many messages of the thread are unread. Only
+// the metadata of the messages is fetched.
+func (s *Service) ThreadUnreadCount(threadID string) (int, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	thread, err := s.GmailSvc.Users.Threads.Get("me", threadID).Format("metadata").Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, msg := range thread.Messages {
+		if contains(msg.LabelIds, "UNREAD") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetThreadMessages gets the messages of the thread in a single request,
+// rather than one request per message (see GetMessage). When msgIDs are given,
+// only those messages are returned, in that order; they must belong to the
+// thread.
+func (s *Service) GetThreadMessages(threadID string, msgIDs ...string) ([]*gmail.Message, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	thread, err := s.GmailSvc.Users.Threads.Get("me", threadID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(msgIDs) == 0 {
+		return thread.Messages, nil
+	}
+
+	byID := make(map[string]*gmail.Message, len(thread.Messages))
+	for _, msg := range thread.Messages {
+		byID[msg.Id] = msg
+	}
+	msgs := make([]*gmail.Message, len(msgIDs))
+	for i, id := range msgIDs {
+		if msgs[i] = byID[id]; msgs[i] == nil {
+			return nil, fmt.Errorf("message %s is not in thread %s", id, threadID)
+		}
+	}
+	return msgs, nil
+}
@@ -0,0 +1,104 @@
+package inboxer
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// unorderedThread is a thread whose messages are not listed chronologically.
+func unorderedThread() *fakeGmail {
+	return &fakeGmail{messages: []*gmail.Message{
+		{Id: "b", ThreadId: "t1", InternalDate: 1672660800000},
+		{Id: "c", ThreadId: "t1", InternalDate: 1672747200000},
+		{Id: "x", ThreadId: "t2", InternalDate: 1672833600000},
+		{Id: "a", ThreadId: "t1", InternalDate: 1672574400000},
+	}}
+}
+
+func TestLatestInThread(t *testing.T) {
+	c := qt.New(t)
+
+	service := newFakeService(t, unorderedThread())
+	msg, err := service.LatestInThread("t1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.Id, qt.Equals, "c")
+
+	_, err = service.LatestInThread("missing")
+	c.Assert(isNotFound(err), qt.IsTrue)
+}
+
+func TestThreadMessages(t *testing.T) {
+	c := qt.New(t)
+
+	msgs, err := newFakeService(t, unorderedThread()).ThreadMessages("t1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"a", "b", "c"})
+}
+
+func TestThreadUnreadCount(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{
+		{Id: "a", ThreadId: "t1", LabelIds: []string{"INBOX"}},
+		{Id: "b", ThreadId: "t1", LabelIds: []string{"INBOX", "UNREAD"}},
+		{Id: "c", ThreadId: "t1", LabelIds: []string{"SENT"}},
+		{Id: "d", ThreadId: "t1", LabelIds: []string{"UNREAD", "INBOX"}},
+		{Id: "x", ThreadId: "t2", LabelIds: []string{"UNREAD"}},
+	}}
+	n, err := newFakeService(t, fake).ThreadUnreadCount("t1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 2)
+	c.Assert(fake.paramsOf("GET /threads/t1")[0].Get("format"), qt.Equals, "metadata")
+}
+
+func TestGetThreadMessages(t *testing.T) {
+	c := qt.New(t)
+
+	fake := unorderedThread()
+	s := newFakeService(t, fake)
+
+	msgs, err := s.GetThreadMessages("t1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"b", "c", "a"})
+	c.Assert(fake.paramsOf("GET /threads/t1")[0].Get("format"), qt.Equals, "full")
+
+	msgs, err = s.GetThreadMessages("t1", "a", "c")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids(msgs), qt.DeepEquals, []string{"a", "c"})
+	c.Assert(fake.callCount("GET /messages/a"), qt.Equals, 0)
+
+	_, err = s.GetThreadMessages("t1", "a", "x")
+	c.Assert(err, qt.ErrorMatches, "message x is not in thread t1")
+}
+
+func BenchmarkThreadFetch(b *testing.B) {
+	fake := &fakeGmail{}
+	var msgIDs []string
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("m%d", i)
+		fake.messages = append(fake.messages, newMessage(newPart("text/plain", "message "+id)))
+		fake.messages[i].Id, fake.messages[i].ThreadId = id, "t1"
+		msgIDs = append(msgIDs, id)
+	}
+	s := newFakeService(b, fake)
+
+	b.Run("per message", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, id := range msgIDs {
+				if _, err := s.GetMessage(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("thread get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetThreadMessages("t1", msgIDs...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package inboxer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStore loads and saves the OAuth token of a Service, e.g. to keep it in
+// a database rather than in the token file written by SetupGmailService (see
+// WithTokenStore). Stores able to delete the token, for Service.Revoke,
+// implement TokenDeleter as well.
+type TokenStore interface {
+	Load() (*oauth2.Token, error)
+	Save(*oauth2.Token) error
+}
+
+// TokenDeleter is implemented by the TokenStores that can delete the token.
+type TokenDeleter interface {
+	Delete() error
+}
+
+// FileTokenStore stores the token in a JSON file, in the format of the token
+// file written by SetupGmailService. It is the default TokenStore.
+type FileTokenStore string
+
+// Load reads the token from the file.
+func (f FileTokenStore) Load() (*oauth2.Token, error) {
+	return tokenFromFile(string(f))
+}
+
+// Save writes the token to the file.
+func (f FileTokenStore) Save(token *oauth2.Token) error {
+	return writeToken(string(f), token)
+}
+
+// Delete removes the file. A missing file is already deleted.
+func (f FileTokenStore) Delete() error {
+	if err := os.Remove(string(f)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// tokenSource provides the token of a Service. It refreshes the token when it
+// expires, or earlier when asked to, saving the new token to the store.
+type tokenSource struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+	store TokenStore
+	// refresh gets a new token with the refresh token.
+	refresh func(refreshToken string) (*oauth2.Token, error)
+	// forcedErr is the error of the last forceRefresh.
+	forcedErr error
+}
+
+// newTokenSource returns a tokenSource refreshing token with config.
+func newTokenSource(config *oauth2.Config, token *oauth2.Token, store TokenStore) *tokenSource {
+	return &tokenSource{
+		token: token,
+		store: store,
+		refresh: func(refreshToken string) (*oauth2.Token, error) {
+			return config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshToken}).Token()
+		},
+	}
+}
+
+// Token returns the current token, refreshing it first when it has expired.
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token.Valid() {
+		return ts.token, nil
+	}
+	return ts.refreshLocked()
+}
+
+// forceRefresh refreshes the token, even if it hasn't expired yet.
+func (ts *tokenSource) forceRefresh() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token, err := ts.refreshLocked()
+	ts.forcedErr = err
+	return token, err
+}
+
+func (ts *tokenSource) refreshLocked() (*oauth2.Token, error) {
+	if ts.token.RefreshToken == "" {
+		return nil, fmt.Errorf("%w: the token has no refresh token", ErrReauthRequired)
+	}
+	token, err := ts.refresh(ts.token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		// refresh tokens are usually not renewed
+		t := *token
+		t.RefreshToken = ts.token.RefreshToken
+		token = &t
+	}
+	ts.token = token
+	if ts.store != nil {
+		if err := ts.store.Save(token); err != nil {
+			return nil, fmt.Errorf("cannot save the refreshed token: %w", err)
+		}
+	}
+	return token, nil
+}
+
+// revokeURL is Google's OAuth token revocation endpoint.
+var revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// Revoke logs the Service out: it revokes the access granted by the user to
+// the application (all of its tokens, not only the current one), then deletes
+// the token from the TokenStore, which must implement TokenDeleter. A token
+// that is no longer valid (e.g. revoked from the Google account settings) has
+// nothing left to revoke, and is just deleted. Requests made afterwards fail
+// with ErrReauthRequired.
+func (s *Service) Revoke() error {
+	if s.tokens == nil {
+		return errors.New("the service doesn't manage its token")
+	}
+	if err := s.checkWritable("revoke the token"); err != nil {
+		return err
+	}
+	s.tokens.mu.Lock()
+	defer s.tokens.mu.Unlock()
+
+	// revoking the refresh token revokes the access tokens it issued too
+	token := s.tokens.token.RefreshToken
+	if token == "" {
+		token = s.tokens.token.AccessToken
+	}
+	if token != "" {
+		ctx, cancel := s.context()
+		defer cancel()
+		if err := revokeToken(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	s.tokens.token = &oauth2.Token{}
+	if s.tokens.store == nil {
+		return nil
+	}
+	d, ok := s.tokens.store.(TokenDeleter)
+	if !ok {
+		return fmt.Errorf("token revoked, but the token store (%T) can't delete it", s.tokens.store)
+	}
+	return d.Delete()
+}
+
+// revokeToken revokes the token with Google's revocation endpoint.
+func revokeToken(ctx context.Context, token string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var body struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+	if body.Error == "invalid_token" {
+		// already revoked or expired
+		return nil
+	}
+	return fmt.Errorf("cannot revoke the token: %s: %s", res.Status, strings.TrimSpace(body.Error+" "+body.Description))
+}
+
+// expiry returns when the token expires, or false if it doesn't.
+func (ts *tokenSource) expiry() (time.Time, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.token.Expiry, !ts.token.Expiry.IsZero()
+}
+
+// TokenRefreshError returns the error of the last refresh made by
+// RefreshTokenBefore, nil if it succeeded or none was made yet.
+func (s *Service) TokenRefreshError() error {
+	if s.tokens == nil {
+		return nil
+	}
+	s.tokens.mu.Lock()
+	defer s.tokens.mu.Unlock()
+	return s.tokens.forcedErr
+}
+
+// TokenExpiry returns when the current access token of the Service expires.
+// It returns false when the token doesn't expire, or isn't managed by the
+// Service (one not built by NewGmailService or NewGmailServiceWithOptions).
+func (s *Service) TokenExpiry() (time.Time, bool) {
+	if s.tokens == nil {
+		return time.Time{}, false
+	}
+	return s.tokens.expiry()
+}
+
+// tokenRetryInterval is how long RefreshTokenBefore waits after a first
+// failed refresh before trying again, the wait doubling with each failure.
+const tokenRetryInterval = time.Minute
+
+// RefreshTokenBefore refreshes the access token in the background when it is
+// about to expire, window before its expiry, rather than when a request is
+// made with an expired token, so long running programs don't have requests
+// delayed or failed by a refresh. Refreshed tokens are saved to the
+// TokenStore. A token is refreshed at most once per half of its lifetime, so
+// a window as long as the lifetime doesn't refresh it over and over.
+//
+// Failed refreshes are retried after a minute, then waiting twice as long
+// each time, until the token expires: RefreshTokenBefore then stops, leaving
+// the requests to refresh the token (and fail) as they need it. See
+// TokenRefreshError for the error of the last refresh. It also stops when
+// ctx is done.
+func (s *Service) RefreshTokenBefore(ctx context.Context, window time.Duration) error {
+	if s.tokens == nil {
+		return errors.New("the service doesn't manage its token")
+	}
+	go func() {
+		retry := tokenRetryInterval
+		for {
+			expiry, ok := s.tokens.expiry()
+			if !ok {
+				return
+			}
+			wait := expiry.Add(-window).Sub(now())
+			if wait <= 0 {
+				left := expiry.Sub(now())
+				if _, err := s.tokens.forceRefresh(); err != nil {
+					if left <= 0 {
+						return
+					}
+					wait, retry = retry, retry*2
+					if wait > left {
+						wait = left
+					}
+				} else if expiry, ok = s.tokens.expiry(); !ok {
+					return
+				} else {
+					retry = tokenRetryInterval
+					left := expiry.Sub(now())
+					if wait = left - window; wait < left/2 {
+						wait = left / 2
+					}
+				}
+			}
+			select {
+			case <-after(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,287 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/oauth2"
+)
+
+// memoryStore is a TokenStore keeping the token in memory.
+type memoryStore struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+	saves int
+}
+
+func (m *memoryStore) Load() (*oauth2.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token, nil
+}
+
+func (m *memoryStore) Save(token *oauth2.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = token
+	m.saves++
+	return nil
+}
+
+func (m *memoryStore) Delete() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = nil
+	return nil
+}
+
+// refreshCounter returns a refresh function issuing tokens valid for an hour.
+func refreshCounter(refreshes *int) func(string) (*oauth2.Token, error) {
+	return func(refreshToken string) (*oauth2.Token, error) {
+		*refreshes++
+		return &oauth2.Token{AccessToken: "new-access", Expiry: time.Now().Add(time.Hour)}, nil
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	c := qt.New(t)
+
+	store := FileTokenStore(filepath.Join(c.TempDir(), TokenFile))
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Date(2023, 3, 6, 9, 0, 0, 0, time.UTC)}
+	c.Assert(store.Save(token), qt.IsNil)
+	loaded, err := store.Load()
+	c.Assert(err, qt.IsNil)
+	c.Assert(loaded.AccessToken, qt.Equals, "access")
+	c.Assert(loaded.RefreshToken, qt.Equals, "refresh")
+	c.Assert(loaded.Expiry.Equal(token.Expiry), qt.IsTrue)
+
+	info, err := os.Stat(string(store))
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0600))
+}
+
+func TestTokenSource(t *testing.T) {
+	c := qt.New(t)
+
+	refreshes := 0
+	store := &memoryStore{}
+	ts := &tokenSource{
+		token:   &oauth2.Token{AccessToken: "old-access", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)},
+		store:   store,
+		refresh: refreshCounter(&refreshes),
+	}
+
+	token, err := ts.Token()
+	c.Assert(err, qt.IsNil)
+	c.Assert(token.AccessToken, qt.Equals, "new-access")
+	c.Assert(token.RefreshToken, qt.Equals, "refresh")
+	c.Assert(store.token, qt.Equals, token)
+
+	// valid tokens are reused
+	_, err = ts.Token()
+	c.Assert(err, qt.IsNil)
+	c.Assert(refreshes, qt.Equals, 1)
+
+	ts.token = &oauth2.Token{AccessToken: "old-access", Expiry: time.Now().Add(-time.Minute)}
+	_, err = ts.Token()
+	c.Assert(err, qt.ErrorIs, ErrReauthRequired)
+}
+
+func TestRefreshTokenBefore(t *testing.T) {
+	c := qt.New(t)
+
+	// each subtest gets its channels, for the goroutines of the previous ones
+	// not to get its timers
+	patchAfter := func(c *qt.C) (waits chan time.Duration, fire chan time.Time) {
+		waits, fire = make(chan time.Duration), make(chan time.Time)
+		c.Patch(&after, func(d time.Duration) <-chan time.Time {
+			waits <- d
+			return fire
+		})
+		return waits, fire
+	}
+	between := func(d, min, max time.Duration) {
+		c.Helper()
+		c.Assert(d > min && d <= max, qt.IsTrue, qt.Commentf("%v", d))
+	}
+
+	c.Run("before expiry", func(c *qt.C) {
+		waits, fire := patchAfter(c)
+		refreshes := 0
+		store := &memoryStore{}
+		s := newFakeService(c.TB, &fakeGmail{})
+		expiry := time.Now().Add(30 * time.Second)
+		s.tokens = &tokenSource{
+			token:   &oauth2.Token{AccessToken: "old-access", RefreshToken: "refresh", Expiry: expiry},
+			store:   store,
+			refresh: refreshCounter(&refreshes),
+		}
+		got, ok := s.TokenExpiry()
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(got, qt.Equals, expiry)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c.Assert(s.RefreshTokenBefore(ctx, 5*time.Minute), qt.IsNil)
+
+		// about to expire: refreshed right away, before any request needs it,
+		// then waits until 5 minutes before the new expiry
+		between(<-waits, 54*time.Minute, 55*time.Minute)
+		c.Assert(refreshes, qt.Equals, 1)
+		c.Assert(store.saves, qt.Equals, 1)
+		c.Assert(store.token.AccessToken, qt.Equals, "new-access")
+		got, _ = s.TokenExpiry()
+		c.Assert(got.After(expiry), qt.IsTrue)
+		c.Assert(s.TokenRefreshError(), qt.IsNil)
+
+		fire <- time.Now()
+		between(<-waits, 54*time.Minute, 55*time.Minute)
+		c.Assert(refreshes, qt.Equals, 1)
+	})
+
+	c.Run("window longer than the lifetime", func(c *qt.C) {
+		waits, _ := patchAfter(c)
+		refreshes := 0
+		s := newFakeService(c.TB, &fakeGmail{})
+		s.tokens = &tokenSource{
+			token:   &oauth2.Token{AccessToken: "old-access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)},
+			refresh: refreshCounter(&refreshes),
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c.Assert(s.RefreshTokenBefore(ctx, 2*time.Hour), qt.IsNil)
+
+		// refreshed at most once per half lifetime, not continuously
+		between(<-waits, 29*time.Minute, 30*time.Minute)
+		c.Assert(refreshes, qt.Equals, 1)
+	})
+
+	c.Run("failures", func(c *qt.C) {
+		waits, fire := patchAfter(c)
+		clock := time.Now()
+		c.Patch(&now, func() time.Time { return clock })
+		attempts := make(chan struct{})
+		s := newFakeService(c.TB, &fakeGmail{})
+		s.tokens = &tokenSource{
+			token: &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: clock.Add(3 * time.Minute)},
+			refresh: func(string) (*oauth2.Token, error) {
+				attempts <- struct{}{}
+				return nil, errors.New("token endpoint down")
+			},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c.Assert(s.RefreshTokenBefore(ctx, 5*time.Minute), qt.IsNil)
+
+		// retried with a growing delay, up to the expiry
+		<-attempts
+		c.Assert(<-waits, qt.Equals, time.Minute)
+		c.Assert(s.TokenRefreshError(), qt.ErrorMatches, "token endpoint down")
+		clock = clock.Add(time.Minute)
+		fire <- clock
+		<-attempts
+		c.Assert(<-waits, qt.Equals, 2*time.Minute)
+
+		// then gives up once the token has expired
+		clock = clock.Add(2 * time.Minute)
+		fire <- clock
+		<-attempts
+		select {
+		case d := <-waits:
+			c.Fatalf("still refreshing after the expiry, waiting %v", d)
+		case <-time.After(50 * time.Millisecond):
+		}
+		c.Assert(s.TokenRefreshError(), qt.ErrorMatches, "token endpoint down")
+	})
+}
+
+func TestRefreshTokenBeforeUnmanaged(t *testing.T) {
+	c := qt.New(t)
+
+	s := newFakeService(t, &fakeGmail{})
+	_, ok := s.TokenExpiry()
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(s.RefreshTokenBefore(context.Background(), time.Minute), qt.ErrorMatches, "the service doesn't manage its token")
+}
+
+func TestWithTokenStore(t *testing.T) {
+	c := qt.New(t)
+
+	credentials := writeCredentials(c)
+	c.Patch(&tokenCacheFile, func() (string, error) { return filepath.Join(c.TempDir(), "missing.json"), nil })
+
+	expiry := time.Now().Add(time.Hour).Round(0)
+	store := &memoryStore{token: &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry}}
+	s, err := NewGmailServiceWithOptions(credentials, []string{"https://mail.google.com/"}, WithTokenStore(store))
+	c.Assert(err, qt.IsNil)
+	got, ok := s.TokenExpiry()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(got, qt.Equals, expiry)
+}
+
+func TestRevoke(t *testing.T) {
+	c := qt.New(t)
+
+	var revoked []string
+	status, body := http.StatusOK, ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked = append(revoked, r.FormValue("token"))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	c.Cleanup(srv.Close)
+	c.Patch(&revokeURL, srv.URL)
+
+	newService := func(c *qt.C) (*Service, *memoryStore) {
+		store := &memoryStore{token: &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}}
+		s := newFakeService(c.TB, &fakeGmail{})
+		s.tokens = &tokenSource{token: store.token, store: store, refresh: refreshCounter(new(int))}
+		return s, store
+	}
+
+	c.Run("revoked", func(c *qt.C) {
+		revoked, status, body = nil, http.StatusOK, ""
+		s, store := newService(c)
+		c.Assert(s.Revoke(), qt.IsNil)
+		c.Assert(revoked, qt.DeepEquals, []string{"refresh"})
+		c.Assert(store.token, qt.IsNil)
+		_, err := s.tokens.Token()
+		c.Assert(errors.Is(err, ErrReauthRequired), qt.IsTrue)
+	})
+
+	c.Run("already invalid", func(c *qt.C) {
+		revoked, status, body = nil, http.StatusBadRequest, `{"error": "invalid_token", "error_description": "Token expired or revoked"}`
+		s, store := newService(c)
+		c.Assert(s.Revoke(), qt.IsNil)
+		c.Assert(revoked, qt.HasLen, 1)
+		c.Assert(store.token, qt.IsNil)
+	})
+
+	c.Run("failed", func(c *qt.C) {
+		revoked, status, body = nil, http.StatusInternalServerError, `{"error": "internal_failure"}`
+		s, store := newService(c)
+		c.Assert(s.Revoke(), qt.ErrorMatches, "cannot revoke the token: 500 Internal Server Error: internal_failure")
+		c.Assert(store.token, qt.Not(qt.IsNil))
+	})
+
+	c.Run("file store", func(c *qt.C) {
+		revoked, status, body = nil, http.StatusOK, ""
+		store := FileTokenStore(filepath.Join(c.TempDir(), TokenFile))
+		c.Assert(store.Save(&oauth2.Token{AccessToken: "access"}), qt.IsNil)
+		s := newFakeService(c.TB, &fakeGmail{})
+		s.tokens = &tokenSource{token: &oauth2.Token{AccessToken: "access"}, store: store}
+		c.Assert(s.Revoke(), qt.IsNil)
+		c.Assert(revoked, qt.DeepEquals, []string{"access"})
+		_, err := os.Stat(string(store))
+		c.Assert(errors.Is(err, fs.ErrNotExist), qt.IsTrue)
+	})
+}
@@ -0,0 +1,112 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrReauthRequired is returned (wrapped) when the authorization of the
+// application was revoked or has expired: the refresh token was rejected
+// (invalid_grant) or the API answered 401 Unauthorized. The user has to go
+// through the OAuth flow again (see SetupGmailService).
+var ErrReauthRequired = errors.New("re-authorization required")
+
+// ErrInsufficientScope is returned (wrapped) when the token wasn't granted a
+// scope the request needs, e.g. when modifying a message with a read-only
+// token. The user has to authorize the application again with the missing
+// scope (see SetupGmailService), unlike other 403 errors.
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// ErrReadOnlyMode is returned (wrapped) by the requests changing the mailbox
+// or its settings when Service.ReadOnly is set.
+var ErrReadOnlyMode = errors.New("read-only mode")
+
+// checkWritable fails with ErrReadOnlyMode when s.ReadOnly is set. The
+// methods changing the mailbox call it, for ReadOnly to hold even when
+// GmailSvc doesn't go through the transport of the Service.
+func (s *Service) checkWritable(what string) error {
+	if s.ReadOnly {
+		return fmt.Errorf("%w: cannot %s", ErrReadOnlyMode, what)
+	}
+	return nil
+}
+
+// isInsufficientScope reports whether err is the 403 error the API returns
+// when the token lacks the scope needed: its reason, or its message, says
+// "insufficient" (insufficientPermissions, "Request had insufficient
+// authentication scopes").
+func isInsufficientScope(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if strings.Contains(strings.ToLower(item.Reason), "insufficient") {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(apiErr.Message), "insufficient")
+}
+
+// Limiter rate limits API requests. *rate.Limiter from golang.org/x/time/rate
+// implements it.
+type Limiter interface {
+	// Wait blocks until a request can be made, or ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// transport wraps the http.RoundTripper of the Service's client to apply
+// s.ReadOnly and s.Limiter, count the quota units used and detect
+// authorization failures.
+type transport struct {
+	base http.RoundTripper
+	s    *Service
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// the API only reads with GET requests
+	if t.s.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %s %s refused", ErrReadOnlyMode, r.Method, r.URL.Path)
+	}
+	if t.s.Limiter != nil {
+		if err := t.s.Limiter.Wait(r.Context()); err != nil {
+			return nil, err
+		}
+	}
+	t.s.quota.Add(quotaCost(r.Method, r.URL.Path))
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		var re *oauth2.RetrieveError
+		if errors.As(err, &re) && strings.Contains(string(re.Body), "invalid_grant") {
+			return nil, fmt.Errorf("%w: %w", ErrReauthRequired, err)
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// keep the details of the API error available to errors.As
+		err := googleapi.CheckResponse(resp)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %w", ErrReauthRequired, err)
+	}
+	return resp, nil
+}
+
+// instrument returns a copy of client whose requests go through transport.
+func (s *Service) instrument(client *http.Client) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &transport{base: base, s: s}
+	return &c
+}
@@ -0,0 +1,179 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestReauthRequired(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("invalid grant", func(c *qt.C) {
+		tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`))
+		}))
+		defer tokenSrv.Close()
+		gmailSrv := httptest.NewServer(&fakeGmail{})
+		defer gmailSrv.Close()
+
+		config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenSrv.URL}}
+		expired := &oauth2.Token{AccessToken: "old", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}
+		s, err := newService(config.Client(context.Background(), expired), WithEndpoint(gmailSrv.URL+"/"))
+		c.Assert(err, qt.IsNil)
+
+		_, err = s.GetLabels()
+		c.Assert(errors.Is(err, ErrReauthRequired), qt.IsTrue, qt.Commentf("%v", err))
+	})
+
+	c.Run("unauthorized", func(c *qt.C) {
+		fake := &fakeGmail{fail: map[string]int{"/labels": http.StatusUnauthorized}}
+		_, err := newFakeService(t, fake).GetLabels()
+		c.Assert(errors.Is(err, ErrReauthRequired), qt.IsTrue, qt.Commentf("%v", err))
+		var apiErr *googleapi.Error
+		c.Assert(errors.As(err, &apiErr), qt.IsTrue)
+		c.Assert(apiErr.Code, qt.Equals, http.StatusUnauthorized)
+	})
+
+	c.Run("other errors", func(c *qt.C) {
+		fake := &fakeGmail{fail: map[string]int{"/labels": http.StatusForbidden}}
+		_, err := newFakeService(t, fake).GetLabels()
+		c.Assert(err, qt.Not(qt.IsNil))
+		c.Assert(errors.Is(err, ErrReauthRequired), qt.IsFalse)
+	})
+}
+
+func TestInsufficientScope(t *testing.T) {
+	c := qt.New(t)
+
+	fake := &fakeGmail{messages: []*gmail.Message{{Id: "m1", LabelIds: []string{"UNREAD"}}}}
+	readOnly := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": {
+				"code": 403,
+				"message": "Request had insufficient authentication scopes.",
+				"errors": [{"message": "Insufficient Permission", "domain": "global", "reason": "insufficientPermissions"}],
+				"status": "PERMISSION_DENIED"
+			}}`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+
+	req := &gmail.ModifyMessageRequest{RemoveLabelIds: []string{"UNREAD"}}
+	_, err := newTestService(t, readOnly).MarkAs("m1", req)
+	c.Assert(err, qt.ErrorIs, ErrInsufficientScope)
+	var apiErr *googleapi.Error
+	c.Assert(errors.As(err, &apiErr), qt.IsTrue)
+	c.Assert(apiErr.Code, qt.Equals, http.StatusForbidden)
+
+	// other 403 errors are left as they are
+	fake.fail = map[string]int{"/messages/m1/modify": http.StatusForbidden}
+	_, err = newFakeService(t, fake).MarkAs("m1", req)
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(errors.Is(err, ErrInsufficientScope), qt.IsFalse)
+}
+
+func TestReadOnly(t *testing.T) {
+	c := qt.New(t)
+
+	msg := newMessage(newPart("text/plain", "Hi"))
+	msg.Id, msg.ThreadId, msg.LabelIds = "m1", "t1", []string{"INBOX", "UNREAD"}
+	fake := &fakeGmail{
+		messages: []*gmail.Message{msg},
+		labels:   []*gmail.Label{{Id: "Label_1", Name: "Work"}},
+	}
+	mutations := func(s *Service) map[string]func() error {
+		return map[string]func() error{
+			"MarkAs": func() error {
+				_, err := s.MarkAs("m1", &gmail.ModifyMessageRequest{RemoveLabelIds: []string{"UNREAD"}})
+				return err
+			},
+			"BatchModify":      func() error { return s.BatchModify([]string{"m1"}, nil, []string{"INBOX"}) },
+			"MarkAllAsRead":    func() error { return s.MarkAllAsRead() },
+			"MarkThreadAsRead": func() error { return s.MarkThreadAsRead("t1") },
+			"ApplyToQuery": func() error {
+				_, err := s.ApplyToQuery("", nil, []string{"INBOX"})
+				return err
+			},
+			"SendMessage": func() error {
+				_, err := s.SendMessage(OutgoingMessage{From: "me@example.com", To: []string{"bob@example.com"}, Body: "Hi"})
+				return err
+			},
+			"Forward": func() error {
+				_, err := s.Forward(msg, []string{"bob@example.com"}, "", nil)
+				return err
+			},
+			"CreateLabel": func() error {
+				_, err := s.CreateLabel(&gmail.Label{Name: "Personal"})
+				return err
+			},
+			"UpdateLabel": func() error {
+				_, err := s.UpdateLabel(&gmail.Label{Id: "Label_1", Name: "Job"})
+				return err
+			},
+			"SetLabelColor": func() error { return s.SetLabelColor("Label_1", "#fb4c2f", "#ffffff") },
+			"RemoveLabelEverywhere": func() error {
+				_, err := s.RemoveLabelEverywhere("Work")
+				return err
+			},
+			"UpdateAutoForwarding": func() error {
+				_, err := s.UpdateAutoForwarding(&gmail.AutoForwarding{Enabled: false})
+				return err
+			},
+			"ImportMessage": func() error {
+				_, err := s.ImportMessage([]byte("Subject: hi\r\n\r\nhi"), nil)
+				return err
+			},
+			"CreateDelegate": func() error {
+				_, err := s.CreateDelegate("bob@example.com")
+				return err
+			},
+			"DeleteDelegate": func() error { return s.DeleteDelegate("bob@example.com") },
+			"ImportSettings": func() error { return s.ImportSettings(&Settings{IMAP: &gmail.ImapSettings{}}) },
+			"Revoke":         func() error { return s.Revoke() },
+		}
+	}
+
+	s := newFakeService(t, fake)
+	s.ReadOnly = true
+	s.tokens = &tokenSource{token: &oauth2.Token{AccessToken: "access"}, store: &memoryStore{}}
+	for name, mutate := range mutations(s) {
+		c.Check(mutate(), qt.ErrorIs, ErrReadOnlyMode, qt.Commentf(name))
+	}
+
+	// the methods refuse too when GmailSvc doesn't go through the transport
+	// of the Service
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	gmailSvc, err := gmail.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	c.Assert(err, qt.IsNil)
+	literal := &Service{GmailSvc: gmailSvc, ReadOnly: true, tokens: s.tokens}
+	for name, mutate := range mutations(literal) {
+		c.Check(mutate(), qt.ErrorIs, ErrReadOnlyMode, qt.Commentf("literal %s", name))
+	}
+
+	for _, call := range fake.calls {
+		c.Assert(strings.HasPrefix(call, "GET "), qt.IsTrue, qt.Commentf(call))
+	}
+	c.Assert(msg.LabelIds, qt.DeepEquals, []string{"INBOX", "UNREAD"})
+
+	// reading still works
+	got, err := s.GetMessage("m1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Id, qt.Equals, "m1")
+}
@@ -3,11 +3,20 @@ package inboxer
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"google.golang.org/api/gmail/v1"
 	"strconv"
 	"time"
 )
 
+// DefaultMaxBodyBytes is the size above which GetBody, and the Service unless
+// its MaxBodyBytes says otherwise, refuse to decode a part.
+const DefaultMaxBodyBytes = 25 << 20
+
+// ErrBodyTooLarge is returned (wrapped) when a part is larger than the
+// allowed maximum (see DefaultMaxBodyBytes and Service.MaxBodyBytes).
+var ErrBodyTooLarge = errors.New("body too large")
+
 // PartialMetadata stores email metadata. Some fields may sound redundant, but
 // in fact have different contexts. Some are slices of string because the ones
 // that have multiple values are still being sorted from those that don't.
@@ -19,8 +28,8 @@ type PartialMetadata struct {
 	From string
 	// Subject is the email subject
 	Subject string
-	// Mailing list contains the name of the mailing list that the email was
-	// posted to, if any.
+	// MailingList contains the List-Id of the mailing list that the email was
+	// posted to, if any (see GetMailingList).
 	MailingList string
 	// CC is the "carbon copy" list of addresses
 	CC []string
@@ -44,8 +53,13 @@ func GetPartialMetadata(msg *gmail.Message) *PartialMetadata {
 			info.From = v.Value
 		case "Subject":
 			info.Subject = v.Value
-		case "Mailing-list":
+		case "List-Id", "List-ID":
 			info.MailingList = v.Value
+		case "Mailing-list":
+			// non standard header, only used without a List-Id
+			if info.MailingList == "" {
+				info.MailingList = v.Value
+			}
 		case "CC":
 			info.CC = append(info.CC, v.Value)
 		case "To":
@@ -82,31 +96,89 @@ func ReceivedTime(datetime int64) (time.Time, error) {
 
 // GetBody gets, decodes, and returns the body of the email. It returns an
 // error if decoding goes wrong. mimeType is used to indicate whether you want
-// the plain text or html encoding ("text/html", "text/plain").
+// the plain text or html encoding ("text/html", "text/plain"). Bodies larger
+// than DefaultMaxBodyBytes are not decoded; ErrBodyTooLarge is returned
+// instead.
 func GetBody(msg *gmail.Message, mimeType string) (string, error) {
+	part := findBodyPart(msg, mimeType)
+	if part == nil {
+		return "", errors.New("couldn't read body")
+	}
+	return decodeBody(part.Body, DefaultMaxBodyBytes)
+}
+
+// findBodyPart returns the part of the message holding the body of the given
+// mime type, or nil.
+func findBodyPart(msg *gmail.Message, mimeType string) *gmail.MessagePart {
+	if msg.Payload == nil {
+		return nil
+	}
+	if msg.Payload.MimeType == mimeType && hasBodyData(msg.Payload) {
+		// not a multipart message
+		return msg.Payload
+	}
 	// Loop through the message payload parts to find the parts with the
 	// mimetypes we want.
 	for _, v := range msg.Payload.Parts {
 		if v.MimeType == "multipart/alternative" {
 			for _, l := range v.Parts {
-				if l.MimeType == mimeType && l.Body.Size >= 1 {
-					dec, err := FromBase64(l.Body.Data)
-					if err != nil {
-						return "", err
-					}
-					return dec, nil
+				if l.MimeType == mimeType && hasBodyData(l) {
+					return l
 				}
 			}
 		}
-		if v.MimeType == mimeType && v.Body.Size >= 1 {
-			dec, err := FromBase64(v.Body.Data)
-			if err != nil {
-				return "", err
-			}
-			return dec, nil
+		if v.MimeType == mimeType && hasBodyData(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// hasBodyData reports whether the part holds data. Body.Size can't be relied
+// upon: some parts carry data while declaring a size of 0.
+func hasBodyData(p *gmail.MessagePart) bool {
+	return p.Body != nil && p.Body.Data != ""
+}
+
+// decodeBody decodes the base64 data of the body, unless it is larger than
+// limit bytes.
+func decodeBody(body *gmail.MessagePartBody, limit int64) (string, error) {
+	if err := checkBodySize(body, limit); err != nil {
+		return "", err
+	}
+	return FromBase64(body.Data)
+}
+
+// checkBodySize returns ErrBodyTooLarge when the body declares, or holds,
+// more than limit bytes. A limit <= 0 means no limit.
+func checkBodySize(body *gmail.MessagePartBody, limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+	size := body.Size
+	// the declared size can't be trusted
+	if n := int64(base64.URLEncoding.DecodedLen(len(body.Data))); n > size {
+		size = n
+	}
+	if size > limit {
+		return fmt.Errorf("%w: %d bytes (limit %d)", ErrBodyTooLarge, size, limit)
+	}
+	return nil
+}
+
+// GetBodyPreferred works like GetBody, but tries each of the preferred mime
+// types in order, returning the first body found along with its mime type.
+// For instance GetBodyPreferred(msg, "text/plain", "text/html") returns the
+// plain text body, or the html one when there is none.
+func GetBodyPreferred(msg *gmail.Message, prefs ...string) (string, string, error) {
+	err := errors.New("couldn't read body")
+	for _, mimeType := range prefs {
+		var body string
+		if body, err = GetBody(msg, mimeType); err == nil {
+			return body, mimeType, nil
 		}
 	}
-	return "", errors.New("couldn't read body")
+	return "", "", err
 }
 
 // CheckForUnreadByLabel checks for unread mail matching the specified label.
@@ -127,3 +199,15 @@ func CheckForUnreadByLabel(srv *gmail.Service, label string) (int64, error) {
 
 	return inbox.MessagesUnread + inbox.ThreadsUnread, nil
 }
+
+// FilterInbound returns the messages that were not sent by ownAddress, e.g.
+// to leave your own replies out of a thread.
+func FilterInbound(msgs []*gmail.Message, ownAddress string) []*gmail.Message {
+	var inbound []*gmail.Message
+	for _, msg := range msgs {
+		if !sameAddress(GetHeaders(msg).Get("From"), ownAddress) {
+			inbound = append(inbound, msg)
+		}
+	}
+	return inbound
+}
@@ -0,0 +1,65 @@
+package inboxer
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestFilterInbound(t *testing.T) {
+	c := qt.New(t)
+
+	msgs := []*gmail.Message{
+		withHeaders(&gmail.Message{Id: "1"}, "From", "Alice <alice@example.com>"),
+		withHeaders(&gmail.Message{Id: "2"}, "From", "Me <Me@Example.com>"),
+		withHeaders(&gmail.Message{Id: "3"}, "From", "bob@example.com"),
+		withHeaders(&gmail.Message{Id: "4"}, "From", "me@example.com"),
+	}
+
+	var ids []string
+	for _, msg := range FilterInbound(msgs, "me@example.com") {
+		ids = append(ids, msg.Id)
+	}
+	c.Assert(ids, qt.DeepEquals, []string{"1", "3"})
+}
+
+func TestGetBodyPreferred(t *testing.T) {
+	c := qt.New(t)
+
+	msg := newMessage(newPart("text/html", "<p>hello</p>"))
+	body, mimeType, err := GetBodyPreferred(msg, "text/plain", "text/html")
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, "<p>hello</p>")
+	c.Assert(mimeType, qt.Equals, "text/html")
+
+	_, _, err = GetBodyPreferred(msg, "text/plain")
+	c.Assert(err, qt.ErrorMatches, "couldn't read body")
+	_, _, err = GetBodyPreferred(msg)
+	c.Assert(err, qt.ErrorMatches, "couldn't read body")
+}
+
+func TestGetBodyTooLarge(t *testing.T) {
+	c := qt.New(t)
+
+	part := newPart("text/plain", "small, but claims otherwise")
+	part.Body.Size = 300 << 20
+	_, err := GetBody(newMessage(part), "text/plain")
+	c.Assert(errors.Is(err, ErrBodyTooLarge), qt.IsTrue, qt.Commentf("%v", err))
+}
+
+func TestGetBodyWithoutSize(t *testing.T) {
+	c := qt.New(t)
+
+	part := newPart("text/plain", "hello")
+	part.Body.Size = 0
+	body, err := GetBody(newMessage(part), "text/plain")
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, "hello")
+
+	empty := newPart("text/plain", "")
+	empty.Body.Size = 10
+	_, err = GetBody(newMessage(empty), "text/plain")
+	c.Assert(err, qt.ErrorMatches, "couldn't read body")
+}